@@ -0,0 +1,162 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/mattn/go-xmpp"
+	"github.com/yetist/xmppbot/robot"
+	"github.com/yetist/xmppbot/utils"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// check-config: 载入并检查配置文件，返回退出码。
+func checkConfig() int {
+	if cfg.AppPath == "" {
+		fmt.Println("config file: not found")
+		return 1
+	}
+	fmt.Printf("config file: %s\n", cfg.AppPath)
+	if cfgErr != nil {
+		fmt.Printf("error: %v\n", cfgErr)
+		return 1
+	}
+	errs := robot.CheckConfig(cfg)
+	for _, err := range errs {
+		fmt.Printf("error: %v\n", err)
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+	fmt.Println("config ok")
+	return 0
+}
+
+// list-plugins: 列出编译进来的模块及其可配置属性。
+func listPlugins() {
+	for _, name := range robot.RegisteredPlugins() {
+		meta, _ := robot.GetPluginMeta(name)
+		state := "not configured"
+		if v, ok := cfg.Plugin[name]; ok {
+			if v["enable"] == true {
+				state = "enabled"
+			} else {
+				state = "disabled"
+			}
+		}
+		fmt.Printf("%-10s [%s] %s\n", name, state, meta.Summary)
+		for _, o := range meta.Options {
+			var flags []string
+			if o.Required {
+				flags = append(flags, "required")
+			}
+			if o.Default != nil {
+				flags = append(flags, fmt.Sprintf("default: %v", o.Default))
+			}
+			line := fmt.Sprintf("    %-10s %-6s %s", o.Name, o.Type, o.Description)
+			if len(flags) > 0 {
+				line += " (" + strings.Join(flags, ", ") + ")"
+			}
+			fmt.Println(line)
+		}
+	}
+}
+
+// send: 连接服务器，发送一条消息后退出，消息为"-"时从标准输入读取。
+func sendMessage(args []string) int {
+	fs := flag.NewFlagSet("send", flag.ExitOnError)
+	room := fs.Bool("room", false, "send to a chat room")
+	nick := fs.String("nick", "", "nickname in the chat room, default is the nickname in config or the username")
+	password := fs.String("room-password", "", "chat room password")
+	timeout := fs.Int("timeout", 10, "seconds to wait for joining the chat room")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: xmppbot [options] send [-room] <jid> <message|->\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return 2
+	}
+	to := fs.Arg(0)
+	text := strings.Join(fs.Args()[1:], " ")
+	if text == "-" {
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "read message error: %v\n", err)
+			return 1
+		}
+		text = strings.TrimRight(string(data), "\n")
+	}
+
+	// 配置文件中的聊天室自动使用配置的昵称及密码
+	for _, v := range cfg.Setup.Rooms {
+		if v["jid"] == to {
+			*room = true
+			if n, ok := v["nickname"].(string); ok && *nick == "" {
+				*nick = n
+			}
+			if p, ok := v["password"].(string); ok && *password == "" {
+				*password = p
+			}
+		}
+	}
+	if *room && *nick == "" {
+		bare, _ := utils.SplitJID(cfg.Account.Username)
+		*nick = strings.SplitN(bare, "@", 2)[0]
+	}
+
+	client, err := dial()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "connect error: %v\n", err)
+		return 1
+	}
+	defer client.Close()
+
+	if *room {
+		if *password != "" {
+			client.JoinProtectedMUC(to, *nick, *password)
+		} else {
+			client.JoinMUC(to, *nick)
+		}
+		if !waitJoined(client, to+"/"+*nick, time.Duration(*timeout)*time.Second) {
+			fmt.Fprintf(os.Stderr, "join %s timeout\n", to)
+			return 1
+		}
+		_, err = client.Send(xmpp.Chat{Remote: to, Type: "groupchat", Text: text})
+		client.LeaveMUC(to)
+	} else {
+		_, err = client.Send(xmpp.Chat{Remote: to, Type: "chat", Text: text})
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "send error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// 等待服务器返回自己在聊天室中的presence.
+func waitJoined(client robot.Transport, occupant string, timeout time.Duration) bool {
+	joined := make(chan bool, 1)
+	go func() {
+		for {
+			stanza, err := client.Recv()
+			if err != nil {
+				joined <- false
+				return
+			}
+			if v, ok := stanza.(xmpp.Presence); ok && v.From == occupant {
+				joined <- v.Type != "error"
+				return
+			}
+		}
+	}()
+	select {
+	case ok := <-joined:
+		return ok
+	case <-time.After(timeout):
+		return false
+	}
+}
@@ -61,24 +61,20 @@ func LoadConfig(name, version, cfgname string) (config Config, err error) {
 		config.AppVersion = version
 		config.AppConfig = cfgname
 	}()
-	if utils.IsFile(cwdconf) {
-		if _, err = toml.DecodeFile(cwdconf, &config); err != nil {
-			return
+	for _, conf := range []string{cwdconf, selfconf, userconf, sysconf} {
+		if utils.IsFile(conf) {
+			return LoadFile(conf)
 		}
-	} else if utils.IsFile(selfconf) {
-		if _, err = toml.DecodeFile(selfconf, &config); err != nil {
-			return
-		}
-	} else if utils.IsFile(userconf) {
-		if _, err = toml.DecodeFile(userconf, &config); err != nil {
-			return
-		}
-	} else if utils.IsFile(sysconf) {
-		if _, err = toml.DecodeFile(sysconf, &config); err != nil {
-			return
-		}
-	} else {
-		fmt.Printf("\n*** 无法找到配置文件，有效的配置文件路径列表为(按顺序查找)***\n\n1. %s\n2. %s\n3. %s\n", selfconf, userconf, sysconf)
 	}
+	fmt.Printf("\n*** 无法找到配置文件，有效的配置文件路径列表为(按顺序查找)***\n\n1. %s\n2. %s\n3. %s\n", selfconf, userconf, sysconf)
+	return
+}
+
+// LoadFile 从指定的文件载入配置。
+func LoadFile(filename string) (config Config, err error) {
+	if _, err = toml.DecodeFile(filename, &config); err != nil {
+		return
+	}
+	config.AppPath = filename
 	return
 }
@@ -0,0 +1,149 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"github.com/BurntSushi/toml"
+	"github.com/yetist/xmppbot/utils"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Render 生成保存config时将写入配置文件的内容。
+// 配置文件中存在但Config不认识的配置项将被保留，注释不会被保留。
+func Render(config Config) (string, error) {
+	raw := map[string]interface{}{}
+	if utils.IsFile(config.AppPath) {
+		if _, err := toml.DecodeFile(config.AppPath, &raw); err != nil {
+			return "", err
+		}
+	}
+
+	// 先把Config转换为和raw相同的结构，再合并到raw中
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(config); err != nil {
+		return "", err
+	}
+	known := map[string]interface{}{}
+	if _, err := toml.Decode(buf.String(), &known); err != nil {
+		return "", err
+	}
+	merge(raw, known)
+
+	// 空的聊天室列表不会被编码，需要明确写入空数组，否则文件中原有的聊天室会被保留
+	if setup, ok := raw["setup"].(map[string]interface{}); ok && len(config.Setup.Rooms) == 0 {
+		if _, ok := setup["rooms"]; ok {
+			setup["rooms"] = []map[string]interface{}{}
+		}
+	}
+
+	buf.Reset()
+	if err := toml.NewEncoder(&buf).Encode(raw); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Save 将配置写回到载入时使用的配置文件中。
+func Save(config Config) error {
+	if config.AppPath == "" {
+		return errors.New("no config file was loaded")
+	}
+	text, err := Render(config)
+	if err != nil {
+		return err
+	}
+	mode := os.FileMode(0600)
+	if fi, err := os.Stat(config.AppPath); err == nil {
+		mode = fi.Mode()
+	}
+	tmp := config.AppPath + ".tmp"
+	if err = ioutil.WriteFile(tmp, []byte(text), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp, config.AppPath)
+}
+
+// ParseValue 按old的类型解析字符串val, old为nil时自动识别类型。
+func ParseValue(old interface{}, val string) interface{} {
+	switch old.(type) {
+	case bool:
+		return utils.StringToBool(val)
+	case int64:
+		if i, err := strconv.ParseInt(val, 10, 64); err == nil {
+			return i
+		}
+		return old
+	case float64:
+		if f, err := strconv.ParseFloat(val, 64); err == nil {
+			return f
+		}
+		return old
+	case string:
+		return val
+	case []interface{}, []string:
+		var list []string
+		for _, v := range strings.Split(val, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				list = append(list, v)
+			}
+		}
+		return list
+	case nil:
+		if i, err := strconv.ParseInt(val, 10, 64); err == nil {
+			return i
+		}
+		if f, err := strconv.ParseFloat(val, 64); err == nil {
+			return f
+		}
+		if val == "true" || val == "false" {
+			return val == "true"
+		}
+	}
+	return val
+}
+
+// 把src合并到dst中，dst中不存在的零值配置项不会被加入。
+func merge(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if sub, ok := v.(map[string]interface{}); ok {
+			if d, ok := dst[k].(map[string]interface{}); ok {
+				merge(d, sub)
+				continue
+			}
+			if isZero(sub) {
+				continue
+			}
+			d := map[string]interface{}{}
+			merge(d, sub)
+			dst[k] = d
+			continue
+		}
+		if _, ok := dst[k]; ok || !isZero(v) {
+			dst[k] = v
+		}
+	}
+}
+
+func isZero(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	if m, ok := v.(map[string]interface{}); ok {
+		for _, i := range m {
+			if !isZero(i) {
+				return false
+			}
+		}
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Map:
+		return rv.Len() == 0
+	}
+	return reflect.DeepEqual(v, reflect.Zero(rv.Type()).Interface())
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenderRooms(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "xmppbot.toml")
+	text := `[setup]
+cmd_prefix = "--"
+unknown = "keep"
+
+[[setup.rooms]]
+jid = "room@conference.example.org"
+nickname = "bot"
+`
+	if err := ioutil.WriteFile(path, []byte(text), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 离开所有聊天室后，保存的配置中不应再有聊天室
+	cfg.Setup.Rooms = nil
+	out, err := Render(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "room@conference.example.org") || !strings.Contains(out, "rooms = []") {
+		t.Errorf("rooms not cleared:\n%s", out)
+	}
+	if !strings.Contains(out, `unknown = "keep"`) {
+		t.Errorf("unknown option not kept:\n%s", out)
+	}
+
+	cfg.Setup.Rooms = []map[string]interface{}{{"jid": "new@conference.example.org", "nickname": "bot"}}
+	if out, _ = Render(cfg); !strings.Contains(out, "new@conference.example.org") || strings.Contains(out, "rooms = []") {
+		t.Errorf("rooms not saved:\n%s", out)
+	}
+}
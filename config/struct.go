@@ -1,29 +1,60 @@
 package config
 
 type Config struct {
-	AppName    string
-	AppVersion string
-	AppConfig  string
+	AppName    string `toml:"-"`
+	AppVersion string `toml:"-"`
+	AppConfig  string `toml:"-"`
+	AppPath    string `toml:"-"` // 实际载入的配置文件路径
 	Account    struct {
-		Username string
-		Password string
-		Resource string
-		Server   string
-		Port     int
-		NoTLS    bool
-		SelfSign bool `toml:"self_signed"`
-		Session  bool
-	}
+		Username string `toml:"username"`
+		Password string `toml:"password"`
+		Resource string `toml:"resource"`
+		Server   string `toml:"server"`
+		Port     int    `toml:"port"`
+		NoTLS    bool   `toml:"notls"`
+		SelfSign bool   `toml:"self_signed"`
+		Session  bool   `toml:"session"`
+	} `toml:"account"`
 	Setup struct {
-		Admin         []string
-		Debug         bool
-		AutoSubscribe bool   `toml:"auto_subscribe"`
-		CmdPrefix     string `toml:"cmd_prefix"`
-		Status        string
-		StatusMessage string `toml:"status_message"`
-		WebHost       string `toml:"web_host"`
-		WebPort       int    `toml:"web_port"`
-		Rooms         []map[string]interface{}
-	}
-	Plugin map[string]map[string]interface{}
+		Admin           []string                 `toml:"admin"`
+		Debug           bool                     `toml:"debug"`
+		AutoSubscribe   bool                     `toml:"auto_subscribe"`
+		CmdPrefix       string                   `toml:"cmd_prefix"`
+		Status          string                   `toml:"status"`
+		StatusMessage   string                   `toml:"status_message"`
+		WebHost         string                   `toml:"web_host"`
+		WebPort         int                      `toml:"web_port"`
+		WebUser         string                   `toml:"web_user"`     // 管理页面(如审计日志)的认证用户名
+		WebPassword     string                   `toml:"web_password"` // 为空时不提供管理页面
+		StateDB         string                   `toml:"state_db"`
+		WatchConfig     bool                     `toml:"watch_config"`     // 配置文件修改后自动重新载入
+		ShutdownTimeout int                      `toml:"shutdown_timeout"` // 退出时等待各模块停止的秒数
+		MaxFailures     int                      `toml:"max_failures"`     // 模块连续出错多少次后自动停用
+		Rooms           []map[string]interface{} `toml:"rooms"`
+		Reconnect       struct {
+			Min    int     `toml:"min"`    // 首次重连等待秒数
+			Max    int     `toml:"max"`    // 最长等待秒数
+			Factor float64 `toml:"factor"` // 每次失败后等待时间的倍数
+			Jitter float64 `toml:"jitter"` // 随机抖动比例, 0~1
+		} `toml:"reconnect"`
+		Queue struct {
+			Size    int    `toml:"size"`    // 每个worker的队列长度
+			Workers int    `toml:"workers"` // 每个模块处理消息的goroutine数
+			Policy  string `toml:"policy"`  // 队列满时: drop丢弃新消息, block暂停接收
+		} `toml:"queue"`
+		Outbox struct {
+			Rate      float64 `toml:"rate"`       // 每秒最多发送的消息数
+			Burst     int     `toml:"burst"`      // 允许连续发送的消息数
+			DestRate  float64 `toml:"dest_rate"`  // 每个好友或聊天室每秒最多发送的消息数
+			DestBurst int     `toml:"dest_burst"` // 每个好友或聊天室允许连续发送的消息数
+			Size      int     `toml:"size"`       // 等待发送的消息数上限，超过时丢弃
+			Coalesce  int     `toml:"coalesce"`   // 合并发往同一聊天室的连续消息，合并后的最大长度
+		} `toml:"outbox"`
+		Reply struct {
+			MaxSize     int  `toml:"max_size"`     // 每条消息的最大长度，超过时按行拆分
+			MaxParts    int  `toml:"max_parts"`    // 一次最多发送的消息数，其余的通过--more查看
+			RoomPrivate bool `toml:"room_private"` // 聊天室中较长的回复改为私聊发送
+		} `toml:"reply"`
+	} `toml:"setup"`
+	Plugin map[string]map[string]interface{} `toml:"plugin"`
 }
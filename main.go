@@ -6,13 +6,14 @@ import (
 	"fmt"
 	"github.com/mattn/go-xmpp"
 	"github.com/yetist/xmppbot/config"
-	"github.com/yetist/xmppbot/plugins"
+	_ "github.com/yetist/xmppbot/plugins"
 	"github.com/yetist/xmppbot/robot"
 	"github.com/yetist/xmppbot/utils"
 	"log"
 	"os"
+	"os/signal"
 	"strconv"
-	"time"
+	"syscall"
 )
 
 const (
@@ -21,10 +22,13 @@ const (
 	AppConfig  = "xmppbot.toml"
 )
 
-var cfg config.Config
+var (
+	cfg    config.Config
+	cfgErr error
+)
 
 func init() {
-	cfg, _ = config.LoadConfig(AppName, AppVersion, AppConfig)
+	cfg, cfgErr = config.LoadConfig(AppName, AppVersion, AppConfig)
 	flag.StringVar(&cfg.Account.Username, "username", cfg.Account.Username, "username")
 	flag.StringVar(&cfg.Account.Password, "password", cfg.Account.Password, "password")
 	flag.StringVar(&cfg.Account.Resource, "resource", cfg.Account.Resource, "resource")
@@ -61,15 +65,30 @@ func NewClient() (client *xmpp.Client, err error) {
 	return
 }
 
-func parseArgs() {
+func parseArgs() (command string) {
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "usage: xmppbot [options]\n")
+		fmt.Fprintf(os.Stderr, "usage: xmppbot [options] [command]\n\n")
+		fmt.Fprintf(os.Stderr, "commands:\n")
+		fmt.Fprintf(os.Stderr, "  run                     start the bot (default)\n")
+		fmt.Fprintf(os.Stderr, "  check-config            validate the config file and all plugin options\n")
+		fmt.Fprintf(os.Stderr, "  send [-room] <jid> <message>\n")
+		fmt.Fprintf(os.Stderr, "                          send one message to a friend or a chat room, then exit\n")
+		fmt.Fprintf(os.Stderr, "  list-plugins            list compiled-in plugins and their options\n")
+		fmt.Fprintf(os.Stderr, "  version                 show version\n\n")
+		fmt.Fprintf(os.Stderr, "options:\n")
 		flag.PrintDefaults()
 		os.Exit(2)
 	}
 
 	flag.Parse()
 
+	if command = flag.Arg(0); command == "" {
+		command = "run"
+	}
+	if command != "run" && command != "send" {
+		return
+	}
+
 	if cfg.Account.Username == "" || cfg.Account.Password == "" {
 		if cfg.Setup.Debug && cfg.Account.Username == "" && cfg.Account.Password == "" {
 			fmt.Fprintf(os.Stderr, "no Username or Password were given; attempting ANONYMOUS auth\n")
@@ -81,51 +100,75 @@ func parseArgs() {
 		fmt.Fprintf(os.Stderr, "invalid status setup, allowed are: away, chat, dnd, xa.\n")
 		os.Exit(1)
 	}
+	return
 }
 
-// 新增模块在这里注册
-func CreatePlugin(name string, opt map[string]interface{}) robot.PluginIface {
-	var plugin robot.PluginIface
-	switch name {
-	case "random":
-		plugin = plugins.NewRandom(name, opt)
-	case "url":
-		plugin = plugins.NewUrl(name, opt)
-	case "tuling":
-		plugin = plugins.NewTuling(name, opt)
-	case "logger":
-		plugin = plugins.NewLogger(name, opt)
-	case "notify":
-		plugin = plugins.NewNotify(name, opt)
-	case "about":
-		plugin = plugins.NewAbout(name, opt)
+func dial() (robot.Transport, error) {
+	client, err := NewClient()
+	if err != nil {
+		return nil, err
 	}
-	return plugin
+	return robot.NewXMPPTransport(client), nil
 }
 
 func main() {
-	var client *xmpp.Client
-	var err error
+	switch parseArgs() {
+	case "run":
+		os.Exit(run())
+	case "check-config":
+		os.Exit(checkConfig())
+	case "send":
+		os.Exit(sendMessage(flag.Args()[1:]))
+	case "list-plugins":
+		listPlugins()
+	case "version":
+		fmt.Printf("%s %s\n", AppName, AppVersion)
+	default:
+		flag.Usage()
+	}
+}
 
-	parseArgs()
-	quit := make(chan bool)
+func run() int {
+	client, err := dial()
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
 
-	for {
-		if client, err = NewClient(); err != nil {
-			log.Fatal(err)
+	bot := robot.NewBot(client, cfg)
+	bot.Start()
+	manager := robot.NewConnManager(bot, dial)
+
+	// SIGHUP: 重新载入配置文件
+	// SIGINT/SIGTERM: 离开聊天室、停止各模块后退出，再次收到时立即退出
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP, os.Interrupt, syscall.SIGTERM)
+	status := make(chan int, 1)
+	go func() {
+		stopping := false
+		for s := range sig {
+			switch {
+			case s == syscall.SIGHUP:
+				bot.Restart()
+			case stopping:
+				log.Printf("received %v again, exit now", s)
+				os.Exit(1)
+			default:
+				stopping = true
+				log.Printf("received %v, shutting down", s)
+				go func() {
+					code := 0
+					if err := bot.Shutdown(bot.ShutdownTimeout()); err != nil {
+						log.Print(err)
+						code = 1
+					}
+					manager.Stop()
+					status <- code
+				}()
+			}
 		}
+	}()
 
-		bot := robot.NewBot(client, cfg, CreatePlugin)
-		bot.Start()
-		bot.Run(quit)
-
-		select {
-		case <-quit:
-			fmt.Printf("Got Stop\n")
-		default:
-			fmt.Printf("Got some thing\n")
-		}
-		bot.Stop()
-		time.Sleep(30 * time.Second)
-	}
+	manager.Run()
+	return <-status
 }
@@ -12,10 +12,17 @@ import (
 type About struct {
 	Name string
 	bot  *robot.Bot
+	*robot.OptionSet
+}
+
+func init() {
+	robot.RegisterPlugin("about", func(name string, opt map[string]interface{}) robot.PluginIface {
+		return NewAbout(name, opt)
+	}, robot.PluginMeta{Summary: "关于模块，提供Bot相关的消息。"})
 }
 
 func NewAbout(name string, opt map[string]interface{}) *About {
-	return &About{Name: name}
+	return &About{Name: name, OptionSet: robot.NewOptionSet(nil, opt)}
 }
 
 func (m *About) GetName() string {
@@ -46,6 +53,9 @@ func (m *About) Start(bot *robot.Bot) {
 	fmt.Printf("[%s] Starting...\n", m.GetName())
 	m.bot = bot
 	m.bot.SetPerm(m.GetName(), robot.AllTalk)
+	m.bot.AddCommand(m.GetName(), robot.Command{Name: m.GetName() + " version", Help: "显示bot版本信息", Handler: m.cmd_mod_version})
+	m.bot.AddCommand(m.GetName(), robot.Command{Name: m.GetName() + " ip", Help: "显示bot的ip地址", Handler: m.cmd_mod_ip})
+	m.bot.AddCommand(m.GetName(), robot.Command{Name: m.GetName() + " todo", Help: "显示bot的开发计划", Handler: m.cmd_mod_todo})
 }
 
 func (m *About) Stop() {
@@ -58,55 +68,18 @@ func (m *About) Restart() {
 }
 
 func (m *About) Chat(msg xmpp.Chat) {
-	if len(msg.Text) == 0 || !msg.Stamp.IsZero() {
-		return
-	}
-	if strings.HasPrefix(msg.Text, m.bot.GetCmdString(m.GetName())) && m.bot.HasPerm(m.GetName(), msg) {
-		cmd := strings.TrimSpace(msg.Text[len(m.bot.GetCmdString(m.GetName())):])
-		m.ModCommand(cmd, msg)
-	}
+	m.bot.RunCommand(m.GetName(), msg)
 }
 
 func (m *About) Presence(pres xmpp.Presence) {
 }
 
-func (m *About) GetOptions() map[string]string {
-	return map[string]string{}
-}
-
-func (m *About) SetOption(key, val string) {
-}
-
-func (m *About) ModCommand(cmd string, msg xmpp.Chat) {
-	if cmd == "" || cmd == "help" {
-		m.cmd_mod_help(cmd, msg)
-	} else if cmd == "version" {
-		m.cmd_mod_version(cmd, msg)
-	} else if cmd == "ip" {
-		m.cmd_mod_ip(cmd, msg)
-	} else if cmd == "todo" {
-		m.cmd_mod_todo(cmd, msg)
-	} else {
-		m.bot.ReplyAuto(msg, "不支持的命令: "+cmd)
-	}
-}
-
-func (m *About) cmd_mod_help(cmd string, msg xmpp.Chat) {
-	help_msg := []string{"===关于命令===",
-		m.bot.GetCmdString(m.Name) + " help     显示本信息",
-		m.bot.GetCmdString(m.Name) + " version  显示bot版本信息",
-		m.bot.GetCmdString(m.Name) + " ip       显示bot的ip地址",
-		m.bot.GetCmdString(m.Name) + " todo     显示bot的开发计划",
-	}
-	m.bot.ReplyAuto(msg, strings.Join(help_msg, "\n"))
-}
-
-func (m *About) cmd_mod_version(cmd string, msg xmpp.Chat) {
+func (m *About) cmd_mod_version(msg xmpp.Chat, args *robot.Args) {
 	cfg := m.bot.GetConfig()
 	m.bot.ReplyPub(msg, cfg.AppName+"-"+cfg.AppVersion)
 }
 
-func (m *About) cmd_mod_ip(cmd string, msg xmpp.Chat) {
+func (m *About) cmd_mod_ip(msg xmpp.Chat, args *robot.Args) {
 	local_ip := getLocalIP()
 	public_ip := getPubIP()
 	txt := "== ip地址信息 == "
@@ -119,7 +92,7 @@ func (m *About) cmd_mod_ip(cmd string, msg xmpp.Chat) {
 	m.bot.ReplyAuto(msg, txt)
 }
 
-func (m *About) cmd_mod_todo(cmd string, msg xmpp.Chat) {
+func (m *About) cmd_mod_todo(msg xmpp.Chat, args *robot.Args) {
 	text := []string{
 		"Bot开发计划：",
 		"1. 增加gitlab支持，转发gitlab的项目提交日志",
@@ -0,0 +1,487 @@
+package plugins
+
+import (
+	"fmt"
+	"github.com/mattn/go-xmpp"
+	"github.com/yetist/xmppbot/robot"
+	"github.com/yetist/xmppbot/utils"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 记录的发言者超过此数目时清理不活跃的记录
+const maxSpamTracks = 1000
+
+type spamEvent struct {
+	at   time.Time
+	text string // 规范化后的消息内容
+	urls int
+}
+
+// 聊天室中一个成员最近的发言及违规情况
+type spamTrack struct {
+	events []spamEvent
+	level  int       // 已采取到第几级措施
+	last   time.Time // 最近一次违规的时间
+	seen   time.Time
+}
+
+type Antispam struct {
+	Name    string
+	bot     *robot.Bot
+	lock    sync.Mutex
+	tracks  map[string]*spamTrack        // "聊天室/昵称"
+	blocked map[string]bool              // 本模块忽略了其消息的"聊天室/昵称"
+	rooms   map[string]map[string]string // 各聊天室单独设置的属性
+	cache   map[string]*robot.OptionSet  // 各聊天室生效的属性
+	now     func() time.Time             // 当前时间，测试时可替换
+	*robot.OptionSet
+}
+
+var antispamOptions = robot.Schema{
+	{Name: "protect", Type: robot.BoolOption, Default: true, Description: "是否保护聊天室，可对单个聊天室设置"},
+	{Name: "rate", Type: robot.IntOption, Default: 6, Description: "window秒内每人最多发送的消息数"},
+	{Name: "window", Type: robot.IntOption, Default: 10, Description: "统计发言速度的时间(秒)"},
+	{Name: "dup", Type: robot.IntOption, Default: 3, Description: "dup_window秒内同一内容每人最多发送的次数"},
+	{Name: "dup_window", Type: robot.IntOption, Default: 60, Description: "统计重复内容的时间(秒)"},
+	{Name: "urls", Type: robot.IntOption, Default: 4, Description: "url_window秒内每人最多发送的链接数"},
+	{Name: "url_window", Type: robot.IntOption, Default: 60, Description: "统计链接数的时间(秒)"},
+	{Name: "actions", Type: robot.ListOption, Default: []string{"warn", "block", "devoice", "kick"},
+		Description: "逐级采取的措施: warn警告, block忽略其消息, devoice禁言, kick踢出"},
+	{Name: "reset", Type: robot.IntOption, Default: 600, Description: "多少秒内未再违规时，重新从第一级措施开始"},
+	{Name: "allow", Type: robot.ListOption, Description: "不受限制的昵称或jid, 聊天室的主持人及bot管理员也不受限制"},
+}
+
+// 措施的说明
+var spamActions = map[string]string{
+	"warn":    "警告",
+	"block":   "忽略消息",
+	"devoice": "禁言",
+	"kick":    "踢出聊天室",
+}
+
+func init() {
+	robot.RegisterPlugin("antispam", func(name string, opt map[string]interface{}) robot.PluginIface {
+		return NewAntispam(name, opt)
+	}, robot.PluginMeta{Summary: "聊天室防刷屏模块", Options: antispamOptions})
+}
+
+func NewAntispam(name string, opt map[string]interface{}) *Antispam {
+	return &Antispam{
+		Name:      name,
+		tracks:    map[string]*spamTrack{},
+		blocked:   map[string]bool{},
+		rooms:     map[string]map[string]string{},
+		cache:     map[string]*robot.OptionSet{},
+		now:       time.Now,
+		OptionSet: robot.NewOptionSet(antispamOptions, opt),
+	}
+}
+
+func (m *Antispam) GetName() string {
+	return m.Name
+}
+
+func (m *Antispam) GetSummary() string {
+	return "聊天室防刷屏模块"
+}
+
+func (m *Antispam) Help() string {
+	msg := []string{
+		m.GetSummary() + ": 限制聊天室中的刷屏、重复消息及链接。支持命令:",
+		m.bot.GetCmdString(m.GetName()) + "    防刷屏模块命令" + m.bot.ShowPerm(m.GetName()),
+	}
+	return strings.Join(msg, "\n")
+}
+
+func (m *Antispam) Description() string {
+	return m.Describe(m.Help(),
+		"统计每个成员在一段时间内的发言数、重复内容及链接数，超过限制时按actions逐级采取措施，",
+		"reset秒内未再违规则重新从第一级开始。可以通过命令对单个聊天室设置以下属性。")
+}
+
+func (m *Antispam) CheckEnv() bool {
+	return true
+}
+
+func (m *Antispam) Start(bot *robot.Bot) {
+	fmt.Printf("[%s] Starting...\n", m.GetName())
+	m.bot = bot
+	m.bot.SetPerm(m.GetName(), robot.AllTalk|robot.AdminPerm)
+	rid := robot.Arg{Name: "Rid", Type: robot.JIDArg}
+	m.bot.AddCommand(m.GetName(), robot.Command{Name: m.GetName() + " show", Args: []robot.Arg{rid},
+		Help: "查看聊天室的防刷屏设置", Handler: m.cmd_mod_show})
+	m.bot.AddCommand(m.GetName(), robot.Command{Name: m.GetName() + " set", Args: []robot.Arg{rid, {Name: "Key"}, {Name: "Value", Variadic: true}},
+		Help: "单独设置聊天室的属性，如\"set room@conference.example.com rate 10\", 列表以逗号分隔", Audit: true, Handler: m.cmd_mod_set})
+	m.bot.AddCommand(m.GetName(), robot.Command{Name: m.GetName() + " unset", Args: []robot.Arg{rid, {Name: "Key"}},
+		Help: "取消聊天室单独设置的属性", Audit: true, Handler: m.cmd_mod_unset})
+	m.bot.AddCommand(m.GetName(), robot.Command{Name: m.GetName() + " reset", Args: []robot.Arg{rid, {Name: "Nick"}},
+		Help: "清除Nick的违规记录，并不再忽略其消息", Audit: true, Handler: m.cmd_mod_reset})
+	m.lock.Lock()
+	if _, err := m.bot.GetStore().Get(m.GetName(), "rooms", &m.rooms); err != nil {
+		fmt.Printf("[%s] Load rooms error: %v\n", m.GetName(), err)
+	}
+	if m.rooms == nil {
+		m.rooms = map[string]map[string]string{}
+	}
+	var blocked []string
+	if _, err := m.bot.GetStore().Get(m.GetName(), "blocked", &blocked); err != nil {
+		fmt.Printf("[%s] Load blocked error: %v\n", m.GetName(), err)
+	}
+	m.blocked = map[string]bool{}
+	for _, v := range blocked {
+		m.blocked[v] = true
+	}
+	m.cache = map[string]*robot.OptionSet{}
+	m.lock.Unlock()
+}
+
+func (m *Antispam) Stop() {
+	fmt.Printf("[%s] Stop\n", m.GetName())
+}
+
+func (m *Antispam) Restart() {
+	m.Load(m.bot.GetPluginOption(m.GetName()))
+	m.lock.Lock()
+	m.cache = map[string]*robot.OptionSet{}
+	m.lock.Unlock()
+}
+
+func (m *Antispam) SetOption(key, val string) error {
+	if err := m.OptionSet.SetOption(key, val); err != nil {
+		return err
+	}
+	m.lock.Lock()
+	m.cache = map[string]*robot.OptionSet{}
+	m.lock.Unlock()
+	return nil
+}
+
+func (m *Antispam) Chat(msg xmpp.Chat) {
+	if len(msg.Text) == 0 || !msg.Stamp.IsZero() {
+		return
+	}
+	if msg.Type == "chat" {
+		m.bot.RunCommand(m.GetName(), msg)
+		return
+	}
+	if msg.Type != "groupchat" || m.bot.SentThis(msg) {
+		return
+	}
+	roomid, nick := utils.SplitJID(msg.Remote)
+	if nick == "" {
+		return
+	}
+	opt := m.roomOptions(roomid)
+	if opt.Bool("protect") && !m.allowed(opt, roomid, nick) {
+		if action, reason := m.check(opt, msg.Remote, msg.Text); action != "" {
+			m.punish(opt, roomid, nick, action, reason)
+			return
+		}
+	}
+	m.bot.RunCommand(m.GetName(), msg)
+}
+
+func (m *Antispam) Presence(pres xmpp.Presence) {
+}
+
+// 聊天室生效的属性: 模块属性加上聊天室单独设置的属性
+func (m *Antispam) roomOptions(roomid string) *robot.OptionSet {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.optionsOf(roomid)
+}
+
+// 同roomOptions, 需持有m.lock
+func (m *Antispam) optionsOf(roomid string) *robot.OptionSet {
+	if opt, ok := m.cache[roomid]; ok {
+		return opt
+	}
+	opt := robot.NewOptionSet(antispamOptions, nil)
+	for _, v := range antispamOptions {
+		switch v.Type {
+		case robot.BoolOption:
+			opt.Set(v.Name, utils.BoolToString(m.Bool(v.Name)))
+		case robot.IntOption:
+			opt.Set(v.Name, strconv.Itoa(m.Int(v.Name)))
+		case robot.ListOption:
+			opt.Set(v.Name, strings.Join(m.List(v.Name), ","))
+		}
+	}
+	for k, v := range m.rooms[roomid] {
+		opt.Set(k, v)
+	}
+	m.cache[roomid] = opt
+	return opt
+}
+
+// 白名单中的成员、聊天室的主持人和管理员，以及bot管理员不受限制。
+// 成员的presence变化后，重新查询到角色之前角色为空，此时只按白名单和jid判断。
+func (m *Antispam) allowed(opt *robot.OptionSet, roomid, nick string) bool {
+	allow := opt.List("allow")
+	if utils.ListContains(allow, nick) {
+		return true
+	}
+	o, ok := m.bot.GetOccupant(roomid, nick)
+	if !ok {
+		return false
+	}
+	if o.Role == "moderator" || o.Affiliation == "owner" || o.Affiliation == "admin" {
+		return true
+	}
+	if o.JID != "" {
+		jid, _ := utils.SplitJID(o.JID)
+		return utils.ListContains(allow, jid) || m.bot.IsAdminID(jid)
+	}
+	return false
+}
+
+func spamText(text string) string {
+	return strings.ToLower(strings.Join(strings.Fields(text), " "))
+}
+
+// 需要保留多久的发言记录
+func spamKeep(opt *robot.OptionSet) time.Duration {
+	keep := opt.Int("window")
+	if v := opt.Int("dup_window"); v > keep {
+		keep = v
+	}
+	if v := opt.Int("url_window"); v > keep {
+		keep = v
+	}
+	return time.Duration(keep) * time.Second
+}
+
+// 记录发言，超过限制时返回要采取的措施及原因
+func (m *Antispam) check(opt *robot.OptionSet, who, text string) (action, reason string) {
+	now := m.now()
+	window := time.Duration(opt.Int("window")) * time.Second
+	dupWindow := time.Duration(opt.Int("dup_window")) * time.Second
+	urlWindow := time.Duration(opt.Int("url_window")) * time.Second
+	keep := spamKeep(opt)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.prune(now)
+	t, ok := m.tracks[who]
+	if !ok {
+		t = &spamTrack{}
+		m.tracks[who] = t
+	}
+	t.seen = now
+	ev := spamEvent{at: now, text: spamText(text), urls: len(GetUrls(text))}
+	events := []spamEvent{}
+	for _, v := range t.events {
+		if now.Sub(v.at) < keep {
+			events = append(events, v)
+		}
+	}
+	t.events = append(events, ev)
+
+	var count, dup, urls int
+	for _, v := range t.events {
+		age := now.Sub(v.at)
+		if age < window {
+			count++
+		}
+		if age < dupWindow && v.text == ev.text {
+			dup++
+		}
+		if age < urlWindow {
+			urls += v.urls
+		}
+	}
+	switch {
+	case count > opt.Int("rate"):
+		reason = "刷屏"
+	case dup > opt.Int("dup"):
+		reason = "重复发送相同内容"
+	case urls > opt.Int("urls"):
+		reason = "发送过多链接"
+	default:
+		return "", ""
+	}
+
+	actions := opt.List("actions")
+	if len(actions) == 0 {
+		return "", ""
+	}
+	if now.Sub(t.last) > time.Duration(opt.Int("reset"))*time.Second {
+		t.level = 0
+	}
+	if t.level < len(actions) {
+		t.level++
+	}
+	t.last = now
+	t.events = nil
+	return actions[t.level-1], reason
+}
+
+// 清理不活跃的记录，按各聊天室生效的属性判断，需持有m.lock
+func (m *Antispam) prune(now time.Time) {
+	if len(m.tracks) <= maxSpamTracks {
+		return
+	}
+	for k, v := range m.tracks {
+		roomid, _ := utils.SplitJID(k)
+		opt := m.optionsOf(roomid)
+		reset := time.Duration(opt.Int("reset")) * time.Second
+		if now.Sub(v.seen) > spamKeep(opt) && now.Sub(v.last) > reset {
+			delete(m.tracks, k)
+		}
+	}
+}
+
+// 下一级措施的说明
+func (m *Antispam) nextAction(opt *robot.OptionSet, action string) string {
+	actions := opt.List("actions")
+	for i, v := range actions {
+		if v == action && i+1 < len(actions) {
+			return spamActions[actions[i+1]]
+		}
+	}
+	return ""
+}
+
+func (m *Antispam) punish(opt *robot.OptionSet, roomid, nick, action, reason string) {
+	var err error
+	switch action {
+	case "warn":
+		text := nick + ": 请不要" + reason
+		if next := m.nextAction(opt, action); next != "" {
+			text += "，否则将被" + next
+		}
+		m.bot.SendPub(roomid, text+"。")
+	case "block":
+		if m.bot.BlockNick(roomid, nick) {
+			m.lock.Lock()
+			m.blocked[roomid+"/"+nick] = true
+			m.lock.Unlock()
+			m.saveBlocked()
+		}
+		m.bot.SendPub(roomid, fmt.Sprintf("/me 因%s忽略了 %s 的消息", reason, nick))
+	case "devoice", "kick":
+		// 等待服务器回应时不阻塞模块的worker
+		go func() {
+			role := "visitor"
+			if action == "kick" {
+				role = "none"
+			}
+			m.report(action, roomid, nick, reason, m.bot.SetRole(roomid, nick, role, reason))
+		}()
+		return
+	default:
+		err = fmt.Errorf("unknown action %s", action)
+	}
+	m.report(action, roomid, nick, reason, err)
+}
+
+func (m *Antispam) report(action, roomid, nick, reason string, err error) {
+	if err != nil {
+		fmt.Printf("[%s] %s %s/%s error: %v\n", m.GetName(), action, roomid, nick, err)
+	} else {
+		fmt.Printf("[%s] %s %s/%s: %s\n", m.GetName(), action, roomid, nick, reason)
+	}
+}
+
+func (m *Antispam) saveRooms() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.cache = map[string]*robot.OptionSet{}
+	if err := m.bot.GetStore().Put(m.GetName(), "rooms", m.rooms); err != nil {
+		fmt.Printf("[%s] Save rooms error: %v\n", m.GetName(), err)
+	}
+}
+
+func (m *Antispam) saveBlocked() {
+	m.lock.Lock()
+	blocked := []string{}
+	for k := range m.blocked {
+		blocked = append(blocked, k)
+	}
+	m.lock.Unlock()
+	sort.Strings(blocked)
+	if err := m.bot.GetStore().Put(m.GetName(), "blocked", blocked); err != nil {
+		fmt.Printf("[%s] Save blocked error: %v\n", m.GetName(), err)
+	}
+}
+
+func (m *Antispam) cmd_mod_show(msg xmpp.Chat, args *robot.Args) {
+	rid := args.String("Rid")
+	opt := m.roomOptions(rid)
+	m.lock.Lock()
+	custom := m.rooms[rid]
+	m.lock.Unlock()
+	options := opt.GetOptions()
+	var keys []string
+	for k := range options {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	lines := []string{"==" + rid + " 的防刷屏设置=="}
+	for _, k := range keys {
+		line := fmt.Sprintf("%-10s : %s", k, options[k])
+		if _, ok := custom[k]; ok {
+			line += " (单独设置)"
+		}
+		lines = append(lines, line)
+	}
+	m.bot.ReplyAuto(msg, strings.Join(lines, "\n"))
+}
+
+func (m *Antispam) cmd_mod_set(msg xmpp.Chat, args *robot.Args) {
+	rid, key, val := args.String("Rid"), args.String("Key"), args.String("Value")
+	// 先检查属性名及值是否有效
+	if err := robot.NewOptionSet(antispamOptions, nil).Set(key, val); err != nil {
+		m.bot.ReplyAuto(msg, err.Error())
+		return
+	}
+	m.lock.Lock()
+	if m.rooms[rid] == nil {
+		m.rooms[rid] = map[string]string{}
+	}
+	m.rooms[rid][key] = val
+	m.lock.Unlock()
+	m.saveRooms()
+	m.bot.ReplyAuto(msg, "已设置"+rid+"的"+key+"为"+val)
+}
+
+func (m *Antispam) cmd_mod_unset(msg xmpp.Chat, args *robot.Args) {
+	rid, key := args.String("Rid"), args.String("Key")
+	m.lock.Lock()
+	_, ok := m.rooms[rid][key]
+	delete(m.rooms[rid], key)
+	if len(m.rooms[rid]) == 0 {
+		delete(m.rooms, rid)
+	}
+	m.lock.Unlock()
+	if !ok {
+		m.bot.ReplyAuto(msg, rid+"没有单独设置"+key)
+		return
+	}
+	m.saveRooms()
+	m.bot.ReplyAuto(msg, "已取消"+rid+"单独设置的"+key)
+}
+
+func (m *Antispam) cmd_mod_reset(msg xmpp.Chat, args *robot.Args) {
+	rid, nick := args.String("Rid"), args.String("Nick")
+	key := rid + "/" + nick
+	m.lock.Lock()
+	delete(m.tracks, key)
+	ours := m.blocked[key]
+	delete(m.blocked, key)
+	m.lock.Unlock()
+	text := "已清除" + nick + "在" + rid + "中的违规记录"
+	if ours {
+		// 只取消本模块设置的忽略，管理员通过命令设置的忽略需通过命令取消
+		m.bot.UnblockNick(rid, nick)
+		m.saveBlocked()
+		text += "，并不再忽略其消息"
+	} else if room := m.bot.GetRoom(rid); room != nil && room.IsBlocked(nick) {
+		text += "。" + nick + "是由管理员忽略的，请使用" + m.bot.GetCmdString("room") + " unblock取消"
+	}
+	m.bot.ReplyAuto(msg, text)
+}
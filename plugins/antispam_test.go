@@ -0,0 +1,98 @@
+package plugins
+
+import (
+	"fmt"
+	"github.com/yetist/xmppbot/robot"
+	"testing"
+	"time"
+)
+
+func TestAntispamCheck(t *testing.T) {
+	type step struct {
+		at     int // 秒
+		text   string
+		action string
+		reason string
+	}
+	tests := []struct {
+		name  string
+		opts  map[string]string
+		steps []step
+	}{
+		{"rate", map[string]string{"rate": "3", "window": "10"}, []step{
+			{0, "a", "", ""}, {4, "b", "", ""}, {8, "c", "", ""}, {12, "d", "", ""},
+			{13, "e", "warn", "刷屏"},
+		}},
+		{"dup", map[string]string{"dup": "2"}, []step{
+			{0, "Hi", "", ""}, {1, " hi ", "", ""}, {2, "HI", "warn", "重复发送相同内容"},
+			{100, "hi", "", ""},
+		}},
+		{"urls", map[string]string{"urls": "2"}, []step{
+			{0, "see http://a.com and https://b.com", "", ""},
+			{1, "http://c.com", "warn", "发送过多链接"},
+			{100, "http://d.com", "", ""},
+		}},
+		{"escalate", map[string]string{"rate": "1", "reset": "60"}, []step{
+			{0, "a", "", ""}, {1, "b", "warn", "刷屏"},
+			{2, "c", "", ""}, {3, "d", "block", "刷屏"},
+			{4, "e", "", ""}, {5, "f", "devoice", "刷屏"},
+			{6, "g", "", ""}, {7, "h", "kick", "刷屏"},
+			{20, "i", "", ""}, {21, "j", "kick", "刷屏"},
+			{100, "k", "", ""}, {101, "l", "warn", "刷屏"},
+		}},
+		{"actions", map[string]string{"rate": "1", "actions": "block,kick"}, []step{
+			{0, "a", "", ""}, {1, "b", "block", "刷屏"},
+			{2, "c", "", ""}, {3, "d", "kick", "刷屏"},
+		}},
+	}
+	for _, tt := range tests {
+		opt := robot.NewOptionSet(antispamOptions, nil)
+		for k, v := range tt.opts {
+			if err := opt.Set(k, v); err != nil {
+				t.Fatalf("%s: set %s: %v", tt.name, k, err)
+			}
+		}
+		start := time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)
+		var now time.Time
+		m := NewAntispam("antispam", nil)
+		m.now = func() time.Time { return now }
+		for _, s := range tt.steps {
+			now = start.Add(time.Duration(s.at) * time.Second)
+			action, reason := m.check(opt, "r@c/spammer", s.text)
+			if action != s.action || reason != s.reason {
+				t.Errorf("%s: at %ds %q = %q, %q, want %q, %q", tt.name, s.at, s.text, action, reason, s.action, s.reason)
+			}
+		}
+	}
+}
+
+// 记录超过maxSpamTracks时，按各聊天室的属性清理不活跃的记录
+func TestAntispamPrune(t *testing.T) {
+	now := time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)
+	stale := now.Add(-time.Hour)
+	m := NewAntispam("antispam", nil)
+	m.rooms["r2@c"] = map[string]string{"reset": "7200"}
+	for i := 0; i < 3; i++ {
+		m.tracks[fmt.Sprintf("r@c/n%d", i)] = &spamTrack{seen: stale, last: stale}
+	}
+	m.prune(now)
+	if len(m.tracks) != 3 {
+		t.Fatalf("pruned below limit: %d tracks left", len(m.tracks))
+	}
+
+	for i := 3; i < maxSpamTracks; i++ {
+		m.tracks[fmt.Sprintf("r@c/n%d", i)] = &spamTrack{seen: stale, last: stale}
+	}
+	m.tracks["r@c/active"] = &spamTrack{seen: now.Add(-time.Second)}
+	m.tracks["r@c/punished"] = &spamTrack{seen: stale, last: now.Add(-time.Minute)}
+	m.tracks["r2@c/punished"] = &spamTrack{seen: stale, last: stale}
+	m.prune(now)
+	if len(m.tracks) != 3 {
+		t.Errorf("%d tracks left, want 3", len(m.tracks))
+	}
+	for _, k := range []string{"r@c/active", "r@c/punished", "r2@c/punished"} {
+		if _, ok := m.tracks[k]; !ok {
+			t.Errorf("%s pruned", k)
+		}
+	}
+}
@@ -8,15 +8,21 @@ import (
 )
 
 type Example struct {
-	Name   string
-	Option map[string]bool
-	bot    *robot.Bot
+	Name string
+	bot  *robot.Bot
+	*robot.OptionSet
+}
+
+func init() {
+	robot.RegisterPlugin("example", func(name string, opt map[string]interface{}) robot.PluginIface {
+		return NewExample(name, opt)
+	}, robot.PluginMeta{Summary: "示例模块"})
 }
 
 func NewExample(name string, opt map[string]interface{}) *Example {
 	return &Example{
-		Name:   name,
-		Option: map[string]bool{},
+		Name:      name,
+		OptionSet: robot.NewOptionSet(nil, opt),
 	}
 }
 
@@ -36,11 +42,8 @@ func (m *Example) Help() string {
 }
 
 func (m *Example) Description() string {
-	msg := []string{m.Help(),
-		"当有好友或群聊消息时将自动回复原内容。",
-		"本模块可配置属性:",
-	}
-	return strings.Join(msg, "\n")
+	return m.Describe(m.Help(),
+		"当有好友或群聊消息时将自动回复原内容。")
 }
 
 func (m *Example) CheckEnv() bool {
@@ -69,11 +72,3 @@ func (m *Example) Chat(msg xmpp.Chat) {
 
 func (m *Example) Presence(pres xmpp.Presence) {
 }
-
-func (m *Example) GetOptions() map[string]string {
-	opts := map[string]string{}
-	return opts
-}
-
-func (m *Example) SetOption(key, val string) {
-}
@@ -33,28 +33,40 @@ const (
 )
 
 type Logger struct {
-	Name   string
-	Option map[string]bool
-	bot    *robot.Bot
-	x      *xorm.Engine
+	Name string
+	bot  *robot.Bot
+	x    *xorm.Engine
+	*robot.OptionSet
+}
+
+var loggerOptions = robot.Schema{
+	{Name: "chat", Type: robot.BoolOption, Default: true, Description: "是否响应好友消息"},
+	{Name: "room", Type: robot.BoolOption, Default: true, Description: "是否响应群聊消息"},
+	{Name: "dbtype", Type: robot.StringOption, Default: "sqlite3", Description: "数据库类型, sqlite3或mysql", Hidden: true},
+	{Name: "dbname", Type: robot.StringOption, Required: true, Description: "数据库名称", Hidden: true},
+	{Name: "dbuser", Type: robot.StringOption, Description: "数据库用户名", Hidden: true},
+	{Name: "dbpass", Type: robot.StringOption, Description: "数据库密码", Hidden: true},
+}
+
+func init() {
+	robot.RegisterPlugin("logger", func(name string, opt map[string]interface{}) robot.PluginIface {
+		return NewLogger(name, opt)
+	}, robot.PluginMeta{Summary: "日志记录模块", Options: loggerOptions})
 }
 
 func NewLogger(name string, opt map[string]interface{}) *Logger {
 	var err error
 
 	m := &Logger{
-		Name: name,
-		Option: map[string]bool{
-			"chat": opt["chat"].(bool),
-			"room": opt["room"].(bool),
-		},
+		Name:      name,
+		OptionSet: robot.NewOptionSet(loggerOptions, opt),
 	}
 
-	switch opt["dbtype"].(string) {
+	switch m.String("dbtype") {
 	case "sqlite3":
-		m.x, err = xorm.NewEngine("sqlite3", opt["dbname"].(string))
+		m.x, err = xorm.NewEngine("sqlite3", m.String("dbname"))
 	case "mysql":
-		m.x, err = xorm.NewEngine("mysql", opt["dbuser"].(string)+" "+opt["dbpass"].(string)+" "+opt["dbname"].(string))
+		m.x, err = xorm.NewEngine("mysql", m.String("dbuser")+" "+m.String("dbpass")+" "+m.String("dbname"))
 	}
 	if err != nil {
 		fmt.Printf("[%s] Database initial error: %v\n", name, err)
@@ -78,18 +90,10 @@ func (m *Logger) Help() string {
 }
 
 func (m *Logger) Description() string {
-	msg := []string{m.Help(),
+	return m.Describe(m.Help(),
 		"当有好友或群聊消息时将自动记录日志．对好友消息，将只记录好友发出的消息，不记录bot回应的消息，对群聊消息将全部记录。",
 		"在本模块启用时，将同时提供一个web服务来查询所有历史聊天记录。",
-		"历史记录的网址为 http://your-host-name/" + m.GetName() + "/",
-		"本模块可配置属性:",
-	}
-	options := m.GetOptions()
-	keys := utils.SortMapKeys(options)
-	for _, v := range keys {
-		msg = append(msg, fmt.Sprintf("%-20s : %s", v, options[v]))
-	}
-	return strings.Join(msg, "\n")
+		"历史记录的网址为 http://your-host-name/"+m.GetName()+"/")
 }
 
 type ChatLogger struct {
@@ -213,11 +217,11 @@ func (m *Logger) Chat(msg xmpp.Chat) {
 	}
 
 	if msg.Type == "chat" {
-		if m.Option["chat"] {
+		if m.Bool("chat") {
 			m.LogInsert(msg)
 		}
 	} else if msg.Type == "groupchat" {
-		if m.Option["room"] {
+		if m.Bool("room") {
 			m.LogInsert(msg)
 		}
 	}
@@ -225,21 +229,3 @@ func (m *Logger) Chat(msg xmpp.Chat) {
 
 func (m *Logger) Presence(pres xmpp.Presence) {
 }
-
-func (m *Logger) GetOptions() map[string]string {
-	opts := map[string]string{}
-	for k, v := range m.Option {
-		if k == "chat" {
-			opts[k] = utils.BoolToString(v) + "  #是否响应好友消息"
-		} else if k == "room" {
-			opts[k] = utils.BoolToString(v) + "  #是否响应群聊消息"
-		}
-	}
-	return opts
-}
-
-func (m *Logger) SetOption(key, val string) {
-	if _, ok := m.Option[key]; ok {
-		m.Option[key] = utils.StringToBool(val)
-	}
-}
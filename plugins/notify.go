@@ -14,22 +14,28 @@ import (
 type Notify struct {
 	Name   string
 	Allows []string
-	Option map[string]string
 	bot    *robot.Bot
+	*robot.OptionSet
+}
+
+var notifyOptions = robot.Schema{
+	{Name: "authuser", Type: robot.StringOption, Required: true, Description: "认证用户名"},
+	{Name: "authpass", Type: robot.StringOption, Required: true, Description: "认证密码"},
+	{Name: "allows", Type: robot.ListOption, Description: "允许访问的ip地址", Hidden: true},
+}
+
+func init() {
+	robot.RegisterPlugin("notify", func(name string, opt map[string]interface{}) robot.PluginIface {
+		return NewNotify(name, opt)
+	}, robot.PluginMeta{Summary: "通知转发模块", Options: notifyOptions})
 }
 
 func NewNotify(name string, opt map[string]interface{}) *Notify {
-	var allows []string
-	for _, i := range opt["allows"].([]interface{}) {
-		allows = append(allows, i.(string))
-	}
+	options := robot.NewOptionSet(notifyOptions, opt)
 	return &Notify{
-		Name: name,
-		Option: map[string]string{
-			"authuser": opt["authuser"].(string),
-			"authpass": opt["authpass"].(string),
-		},
-		Allows: allows,
+		Name:      name,
+		OptionSet: options,
+		Allows:    options.List("allows"),
 	}
 }
 
@@ -50,18 +56,10 @@ func (m *Notify) Help() string {
 }
 
 func (m *Notify) Description() string {
-	msg := []string{m.Help(),
+	return m.Describe(m.Help(),
 		"本模块启用时，将提供web服务来接收通知，并根据相关信息将通知转发到合适的好友或聊天室。",
-		"通知消息的接收网址为http://your-host-name/" + m.GetName() + "/<JID>/",
-		"需要使用POST模式向此网址发送消息，定义参数subject和body，如果ip地址被允许，消息将会发给JID用户。",
-		"本模块可配置属性:",
-	}
-	options := m.GetOptions()
-	keys := utils.SortMapKeys(options)
-	for _, v := range keys {
-		msg = append(msg, fmt.Sprintf("%-20s : %s", v, options[v]))
-	}
-	return strings.Join(msg, "\n")
+		"通知消息的接收网址为http://your-host-name/"+m.GetName()+"/<JID>/",
+		"需要使用POST模式向此网址发送消息，定义参数subject和body，如果ip地址被允许，消息将会发给JID用户。")
 }
 
 func (m *Notify) CheckEnv() bool {
@@ -78,7 +76,7 @@ func (m *Notify) JIDPage(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("WWW-Authenticate", "Basic realm=\"xmppbot\"")
 		http.Error(w, http.StatusText(401), 401)
 		return
-	} else if !(m.Option["authuser"] == username && m.Option["authpass"] == password) {
+	} else if !(m.String("authuser") == username && m.String("authpass") == password) {
 		w.Header().Set("WWW-Authenticate", "Basic realm=\"xmppbot\"")
 		http.Error(w, http.StatusText(401), 401)
 		return
@@ -101,7 +99,18 @@ func (m *Notify) JIDPage(w http.ResponseWriter, r *http.Request) {
 func (m *Notify) Start(bot *robot.Bot) {
 	fmt.Printf("[%s] Starting...\n", m.GetName())
 	m.bot = bot
+	var allows []string
+	if ok, err := m.bot.GetStore().Get(m.GetName(), "allows", &allows); ok {
+		m.Allows = allows
+	} else if err != nil {
+		fmt.Printf("[%s] Load allows error: %v\n", m.GetName(), err)
+	}
 	m.bot.SetPerm(m.GetName(), robot.ChatTalk|robot.AdminPerm)
+	m.bot.AddCommand(m.GetName(), robot.Command{Name: m.GetName() + " list-allows", Help: "列出允许访问的ip地址", Handler: m.cmd_mod_list_allows})
+	m.bot.AddCommand(m.GetName(), robot.Command{Name: m.GetName() + " add-allow", Args: []robot.Arg{{Name: "ip"}},
+		Help: "添加新的ip地址到可允许访问列表", Handler: m.cmd_mod_add_allow})
+	m.bot.AddCommand(m.GetName(), robot.Command{Name: m.GetName() + " del-allow", Args: []robot.Arg{{Name: "ip"}},
+		Help: "从允许访问列表中删除一个ip地址", Handler: m.cmd_mod_del_allow})
 	m.bot.AddHandler(m.GetName(), "/{jid}/", m.JIDPage, "jidpage")
 }
 
@@ -116,62 +125,13 @@ func (m *Notify) Restart() {
 }
 
 func (m *Notify) Chat(msg xmpp.Chat) {
-	if len(msg.Text) == 0 || !msg.Stamp.IsZero() {
-		return
-	}
-
-	if strings.HasPrefix(msg.Text, m.bot.GetCmdString(m.GetName())) && m.bot.HasPerm(m.GetName(), msg) {
-		cmd := strings.TrimSpace(msg.Text[len(m.bot.GetCmdString(m.GetName())):])
-		m.ModCommand(cmd, msg)
-	}
+	m.bot.RunCommand(m.GetName(), msg)
 }
 
 func (m *Notify) Presence(pres xmpp.Presence) {
 }
 
-func (m *Notify) GetOptions() map[string]string {
-	opts := make(map[string]string, 0)
-	for k, v := range m.Option {
-		if k == "authuser" {
-			opts[k] = v + "  #认证用户名"
-		} else if k == "authpass" {
-			opts[k] = v + "  #认证密码"
-		}
-	}
-	return opts
-}
-
-func (m *Notify) SetOption(key, val string) {
-	if _, ok := m.Option[key]; ok {
-		m.Option[key] = val
-	}
-}
-
-func (m *Notify) ModCommand(cmd string, msg xmpp.Chat) {
-	if cmd == "" || cmd == "help" {
-		m.cmd_mod_help(cmd, msg)
-	} else if cmd == "list-allows" {
-		m.cmd_mod_list_allows(cmd, msg)
-	} else if strings.HasPrefix(cmd, "add-allow ") {
-		m.cmd_mod_add_allow(cmd, msg)
-	} else if strings.HasPrefix(cmd, "del-allow ") {
-		m.cmd_mod_del_allow(cmd, msg)
-	} else {
-		m.bot.ReplyAuto(msg, "不支持的命令: "+cmd)
-	}
-}
-
-func (m *Notify) cmd_mod_help(cmd string, msg xmpp.Chat) {
-	help_msg := []string{"==通知转发命令==",
-		m.bot.GetCmdString(m.Name) + " help            显示本信息",
-		m.bot.GetCmdString(m.Name) + " list-allows     列出允许访问的ip地址",
-		m.bot.GetCmdString(m.Name) + " add-allow <ip>  添加新的ip地址到可允许访问列表",
-		m.bot.GetCmdString(m.Name) + " del-allow <ip>  从允许访问列表中删除一个ip地址",
-	}
-	m.bot.ReplyAuto(msg, strings.Join(help_msg, "\n"))
-}
-
-func (m *Notify) cmd_mod_list_allows(cmd string, msg xmpp.Chat) {
+func (m *Notify) cmd_mod_list_allows(msg xmpp.Chat, args *robot.Args) {
 	var allows_list []string
 	for k, v := range m.Allows {
 		allows_list = append(allows_list, fmt.Sprintf("%2d: %s", k+1, v))
@@ -189,25 +149,31 @@ func (m *Notify) IsAllowed(ip string) bool {
 	return false
 }
 
-func (m *Notify) cmd_mod_add_allow(cmd string, msg xmpp.Chat) {
-	tokens := strings.SplitN(cmd, " ", 2)
-	if len(tokens) == 2 {
-		if m.IsAllowed(tokens[1]) {
-			m.bot.ReplyAuto(msg, tokens[1]+" 已经存在于ip地址列表中，不需再次增加！")
-		} else {
-			m.Allows = append(m.Allows, tokens[1])
-			m.bot.ReplyAuto(msg, "您已添加 "+tokens[1]+"到ip地址列表!")
-		}
+func (m *Notify) cmd_mod_add_allow(msg xmpp.Chat, args *robot.Args) {
+	ip := args.String("ip")
+	if m.IsAllowed(ip) {
+		m.bot.ReplyAuto(msg, ip+" 已经存在于ip地址列表中，不需再次增加！")
+	} else {
+		m.Allows = append(m.Allows, ip)
+		m.saveAllows()
+		m.bot.ReplyAuto(msg, "您已添加 "+ip+"到ip地址列表!")
 	}
 }
 
-func (m *Notify) cmd_mod_del_allow(cmd string, msg xmpp.Chat) {
-	tokens := strings.SplitN(cmd, " ", 2)
-	if m.IsAllowed(tokens[1]) {
-		m.Allows = utils.ListDelete(m.Allows, tokens[1])
-		m.bot.ReplyAuto(msg, "禁用了ip地址："+tokens[1])
+func (m *Notify) cmd_mod_del_allow(msg xmpp.Chat, args *robot.Args) {
+	ip := args.String("ip")
+	if m.IsAllowed(ip) {
+		m.Allows = utils.ListDelete(m.Allows, ip)
+		m.saveAllows()
+		m.bot.ReplyAuto(msg, "禁用了ip地址："+ip)
 	} else {
-		m.bot.ReplyAuto(msg, "ip地址 "+tokens[1]+" 不在列表中!")
+		m.bot.ReplyAuto(msg, "ip地址 "+ip+" 不在列表中!")
+	}
+}
+
+func (m *Notify) saveAllows() {
+	if err := m.bot.GetStore().Put(m.GetName(), "allows", m.Allows); err != nil {
+		fmt.Printf("[%s] Save allows error: %v\n", m.GetName(), err)
 	}
 }
 
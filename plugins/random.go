@@ -19,18 +19,29 @@ type Random struct {
 	FuckList   []string
 	RandomList []string
 	bot        *robot.Bot
-	Option     map[string]bool
+	*robot.OptionSet
+}
+
+var randomOptions = robot.Schema{
+	{Name: "chat", Type: robot.BoolOption, Default: true, Description: "是否在好友间启用随机回复"},
+	{Name: "room", Type: robot.BoolOption, Default: true, Description: "是否在群聊时启用随机回复"},
+	{Name: "fuck", Type: robot.StringOption, Required: true, Description: "fuck命令的回复内容文件", Hidden: true},
+	{Name: "random", Type: robot.StringOption, Required: true, Description: "随机回复内容文件", Hidden: true},
+}
+
+func init() {
+	robot.RegisterPlugin("random", func(name string, opt map[string]interface{}) robot.PluginIface {
+		return NewRandom(name, opt)
+	}, robot.PluginMeta{Summary: "和Bot聊天时自动回复消息", Options: randomOptions})
 }
 
 func NewRandom(name string, opt map[string]interface{}) *Random {
+	options := robot.NewOptionSet(randomOptions, opt)
 	return &Random{
 		Name:       name,
-		FuckPath:   opt["fuck"].(string),
-		RandomPath: opt["random"].(string),
-		Option: map[string]bool{
-			"chat": true,
-			"room": true,
-		},
+		FuckPath:   options.String("fuck"),
+		RandomPath: options.String("random"),
+		OptionSet:  options,
 	}
 }
 
@@ -52,15 +63,7 @@ func (m *Random) Help() string {
 }
 
 func (m *Random) Description() string {
-	msg := []string{m.Help(),
-		"本模块可配置属性:",
-	}
-	options := m.GetOptions()
-	keys := utils.SortMapKeys(options)
-	for _, v := range keys {
-		msg = append(msg, fmt.Sprintf("%-20s : %s", v, options[v]))
-	}
-	return strings.Join(msg, "\n")
+	return m.Describe(m.Help())
 }
 
 func (m *Random) CheckEnv() bool {
@@ -76,6 +79,7 @@ func (m *Random) CheckEnv() bool {
 func (m *Random) Start(bot *robot.Bot) {
 	fmt.Printf("[%s] Starting...\n", m.GetName())
 	m.bot = bot
+	m.bot.AddCommand(m.GetName(), robot.Command{Name: "fuck", Help: "无聊透顶的命令，慎用", Perm: robot.AllTalk, Handler: m.fuck})
 	rand.Seed(time.Now().Unix())
 
 	if data, err := ioutil.ReadFile(m.FuckPath); err == nil {
@@ -93,6 +97,8 @@ func (m *Random) Stop() {
 
 func (m *Random) Restart() {
 	rand.Seed(time.Now().Unix())
+	m.FuckPath = m.String("fuck")
+	m.RandomPath = m.String("random")
 
 	// 重新载入文件内容。
 	if data, err := ioutil.ReadFile(m.FuckPath); err == nil {
@@ -109,22 +115,17 @@ func (m *Random) Chat(msg xmpp.Chat) {
 		return
 	}
 	if msg.Type == "chat" {
-		if m.Option["chat"] {
-			if msg.Text == m.bot.GetCmdString("fuck") {
-				m.bot.ReplyAuto(msg, m.FuckList[rand.Intn(len(m.FuckList))])
-			} else {
-				m.bot.ReplyAuto(msg, m.RandomList[rand.Intn(len(m.RandomList))])
-			}
+		if m.Bool("chat") && !m.bot.RunCommand(m.GetName(), msg) {
+			m.bot.ReplyAuto(msg, m.RandomList[rand.Intn(len(m.RandomList))])
 		}
 	} else if msg.Type == "groupchat" {
-		if m.Option["room"] {
+		if m.Bool("room") {
 			//忽略bot自己发送的消息
 			if m.bot.SentThis(msg) || m.bot.BlockRemote(msg) {
 				return
 			}
-			if msg.Text == m.bot.GetCmdString("fuck") {
-				roomid, nick := utils.SplitJID(msg.Remote)
-				m.bot.SendPub(roomid, nick+": "+m.FuckList[rand.Intn(len(m.FuckList))])
+			if m.bot.RunCommand(m.GetName(), msg) {
+				return
 			}
 			if ok, _ := m.bot.Called(msg); ok {
 				roomid, _ := utils.SplitJID(msg.Remote)
@@ -134,23 +135,14 @@ func (m *Random) Chat(msg xmpp.Chat) {
 	}
 }
 
-func (m *Random) Presence(pres xmpp.Presence) {
-}
-
-func (m *Random) GetOptions() map[string]string {
-	opts := map[string]string{}
-	for k, v := range m.Option {
-		if k == "chat" {
-			opts[k] = utils.BoolToString(v) + "  #是否在好友间启用随机回复"
-		} else if k == "room" {
-			opts[k] = utils.BoolToString(v) + "  #是否在群聊时启用随机回复"
-		}
+func (m *Random) fuck(msg xmpp.Chat, args *robot.Args) {
+	if msg.Type == "groupchat" {
+		roomid, nick := utils.SplitJID(msg.Remote)
+		m.bot.SendPub(roomid, nick+": "+m.FuckList[rand.Intn(len(m.FuckList))])
+	} else {
+		m.bot.ReplyAuto(msg, m.FuckList[rand.Intn(len(m.FuckList))])
 	}
-	return opts
 }
 
-func (m *Random) SetOption(key, val string) {
-	if _, ok := m.Option[key]; ok {
-		m.Option[key] = utils.StringToBool(val)
-	}
+func (m *Random) Presence(pres xmpp.Presence) {
 }
@@ -0,0 +1,376 @@
+package plugins
+
+import (
+	"fmt"
+	"github.com/jakecoffman/cron"
+	"github.com/mattn/go-xmpp"
+	"github.com/yetist/xmppbot/robot"
+	"github.com/yetist/xmppbot/utils"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 错过提醒时间(如bot未运行)的一次性提醒，在启动后多久发出
+const missedRemindDelay = 30 * time.Second
+
+// Reminder 是用户设置的提醒，保存在状态数据库中。
+type Reminder struct {
+	ID      int
+	Owner   string    // 设置者的jid, 聊天室中无法获得真实jid时为"聊天室/昵称"
+	To      string    // 提醒发送到的jid或聊天室
+	Nick    string    // 在聊天室中提醒时提及的昵称
+	At      time.Time // 一次性提醒的时间
+	Spec    string    // 重复提醒的cron表达式
+	Text    string
+	Created time.Time
+}
+
+func (r Reminder) schedule() (s cron.Schedule, err error) {
+	if r.Spec == "" {
+		at := r.At
+		if !at.After(time.Now()) {
+			at = time.Now().Add(missedRemindDelay)
+		}
+		return robot.AtSchedule(at), nil
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("%v", e)
+		}
+	}()
+	return cron.Parse(r.Spec), nil
+}
+
+func (r Reminder) String() string {
+	when := r.At.Format("2006-01-02 15:04")
+	if r.Spec != "" {
+		when = "重复: " + r.Spec
+		if s, err := r.schedule(); err == nil {
+			when += ", 下次: " + s.Next(time.Now()).Format("2006-01-02 15:04")
+		}
+	}
+	return fmt.Sprintf("#%d [%s] %s", r.ID, when, r.Text)
+}
+
+type Remind struct {
+	Name      string
+	bot       *robot.Bot
+	lock      sync.Mutex
+	reminders map[int]Reminder
+	seq       int
+	*robot.OptionSet
+}
+
+var remindOptions = robot.Schema{
+	{Name: "chat", Type: robot.BoolOption, Default: true, Description: "是否响应好友消息中的\"提醒我\""},
+	{Name: "room", Type: robot.BoolOption, Default: true, Description: "是否响应群聊中点名bot的\"提醒我\""},
+	{Name: "max", Type: robot.IntOption, Default: 20, Description: "每个用户最多可设置的提醒数"},
+}
+
+var (
+	reRemindMe = regexp.MustCompile(`(?i)^\s*(remind\s+me\b|提醒我)`)
+	reRemindIn = regexp.MustCompile(`(?i)\bremind\s+me\b|提醒我`)
+)
+
+func init() {
+	robot.RegisterPlugin("remind", func(name string, opt map[string]interface{}) robot.PluginIface {
+		return NewRemind(name, opt)
+	}, robot.PluginMeta{Summary: "提醒模块", Options: remindOptions})
+}
+
+func NewRemind(name string, opt map[string]interface{}) *Remind {
+	return &Remind{
+		Name:      name,
+		OptionSet: robot.NewOptionSet(remindOptions, opt),
+	}
+}
+
+func (m *Remind) GetName() string {
+	return m.Name
+}
+
+func (m *Remind) GetSummary() string {
+	return "提醒模块"
+}
+
+func (m *Remind) Help() string {
+	msg := []string{
+		m.GetSummary() + ": 在指定的时间提醒您。支持命令:",
+		m.bot.GetCmdString(m.GetName()) + "    提醒模块命令" + m.bot.ShowPerm(m.GetName()),
+	}
+	return strings.Join(msg, "\n")
+}
+
+func (m *Remind) Description() string {
+	return m.Describe(m.Help(),
+		"可以直接对bot说\"提醒我10分钟后部署\"、\"明天9点提醒我开会\"或\"remind me in 20 minutes to deploy\", 在聊天室中需要点名bot.",
+		"支持的时间如: 10分钟后、半小时后、明天 9:00、下午3点半、周五 17:00、下周一上午10点、10月20日 9点、每天 9:00、每周五 17:00、每个工作日 9:30、每30分钟,",
+		"in 20 minutes、in 1h30m、tomorrow 9am、next friday 17:00、2026-10-20 15:00、every day 9:00、every Friday 17:00、every weekday 9:30、every 2 hours.",
+		"在聊天室中设置的提醒将在聊天室中提及您，bot重启后提醒仍然有效。")
+}
+
+func (m *Remind) CheckEnv() bool {
+	return true
+}
+
+func (m *Remind) Start(bot *robot.Bot) {
+	fmt.Printf("[%s] Starting...\n", m.GetName())
+	m.bot = bot
+	m.bot.SetPerm(m.GetName(), robot.AllTalk)
+	m.bot.AddCommand(m.GetName(), robot.Command{Name: m.GetName() + " add", Args: []robot.Arg{{Name: "Text", Variadic: true}},
+		Help: "添加提醒，如\"10分钟后 部署\"、\"明天9点 开会\"、\"每周五17:00 周报\"、\"in 20 minutes deploy\"", Handler: m.cmd_mod_add})
+	m.bot.AddCommand(m.GetName(), robot.Command{Name: m.GetName() + " list", Help: "列出您设置的提醒", Handler: m.cmd_mod_list})
+	m.bot.AddCommand(m.GetName(), robot.Command{Name: m.GetName() + " cancel", Args: []robot.Arg{{Name: "ID"}},
+		Help: "取消提醒", Handler: m.cmd_mod_cancel})
+	m.loadReminders()
+}
+
+func (m *Remind) Stop() {
+	fmt.Printf("[%s] Stop\n", m.GetName())
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for id := range m.reminders {
+		m.bot.GetCron().RemoveJob(m.jobName(id))
+	}
+}
+
+func (m *Remind) Restart() {
+	m.Load(m.bot.GetPluginOption(m.GetName()))
+}
+
+func (m *Remind) Chat(msg xmpp.Chat) {
+	if len(msg.Text) == 0 || !msg.Stamp.IsZero() || m.bot.SentThis(msg) {
+		return
+	}
+	if m.bot.RunCommand(m.GetName(), msg) {
+		return
+	}
+	if msg.Type == "chat" {
+		if m.Bool("chat") && isRemind(msg.Text) {
+			m.add(msg, msg.Text)
+		}
+	} else if msg.Type == "groupchat" {
+		if !m.Bool("room") || m.bot.BlockRemote(msg) {
+			return
+		}
+		if ok, text := m.bot.Called(msg); ok {
+			text = strings.TrimLeft(strings.TrimSpace(text), ",，:： ")
+			if isRemind(text) {
+				m.add(msg, text)
+			}
+		}
+	}
+}
+
+func (m *Remind) Presence(pres xmpp.Presence) {
+}
+
+// 是否为设置提醒的消息: 以"提醒我"开头，或"明天9点提醒我开会"这样可以解析的消息
+func isRemind(text string) bool {
+	if reRemindMe.MatchString(text) {
+		return true
+	}
+	if !reRemindIn.MatchString(text) {
+		return false
+	}
+	_, _, err := parseRemind(text, time.Now())
+	return err == nil
+}
+
+func (m *Remind) jobName(id int) string {
+	return m.GetName() + "-" + strconv.Itoa(id)
+}
+
+// 提醒的设置者及发送目标，在聊天室中设置的提醒发到聊天室并提及昵称。
+// 聊天室成员以真实jid作为设置者，修改昵称后仍可管理自己的提醒
+func (m *Remind) owner(msg xmpp.Chat) (owner, to, nick string) {
+	if m.bot.IsRoomID(msg.Remote) {
+		roomid, nick := utils.SplitJID(msg.Remote)
+		owner = msg.Remote
+		if o, ok := m.bot.GetOccupant(roomid, nick); ok && o.JID != "" {
+			owner, _ = utils.SplitJID(o.JID)
+		}
+		if msg.Type == "groupchat" {
+			return owner, roomid, nick
+		}
+		return owner, msg.Remote, ""
+	}
+	jid, _ := utils.SplitJID(msg.Remote)
+	return jid, jid, ""
+}
+
+// 回复设置者，在聊天室中提及昵称
+func (m *Remind) reply(msg xmpp.Chat, text string) {
+	if msg.Type == "groupchat" {
+		_, nick := utils.SplitJID(msg.Remote)
+		m.bot.ReplyPub(msg, nick+": "+text)
+	} else {
+		m.bot.ReplyAuto(msg, text)
+	}
+}
+
+func (m *Remind) add(msg xmpp.Chat, text string) {
+	when, what, err := parseRemind(text, time.Now())
+	if err != nil {
+		m.reply(msg, "无法设置提醒: "+err.Error()+"\n查看帮助请发送: "+m.bot.GetCmdString(m.GetName())+" add --help")
+		return
+	}
+	owner, to, nick := m.owner(msg)
+	if n := len(m.ownReminders(owner)); n >= m.Int("max") {
+		m.reply(msg, fmt.Sprintf("您已设置了%d个提醒，请先取消一些。", n))
+		return
+	}
+	r := Reminder{Owner: owner, To: to, Nick: nick, At: when.At, Spec: when.Spec, Text: what, Created: time.Now()}
+	m.lock.Lock()
+	m.seq++
+	r.ID = m.seq
+	m.lock.Unlock()
+	if err := m.scheduleReminder(r); err != nil {
+		m.reply(msg, "无法设置提醒: "+err.Error())
+		return
+	}
+	m.lock.Lock()
+	m.reminders[r.ID] = r
+	m.lock.Unlock()
+	m.saveReminders()
+	m.reply(msg, "好的，已添加提醒 "+r.String())
+}
+
+func (m *Remind) scheduleReminder(r Reminder) error {
+	s, err := r.schedule()
+	if err != nil {
+		return err
+	}
+	c := m.bot.GetCron()
+	c.RemoveJob(m.jobName(r.ID))
+	c.Schedule(s, cron.FuncJob(func() { m.fire(r.ID) }), m.jobName(r.ID))
+	return nil
+}
+
+// 发出提醒，一次性提醒发出后删除
+func (m *Remind) fire(id int) {
+	m.lock.Lock()
+	r, ok := m.reminders[id]
+	m.lock.Unlock()
+	if !ok {
+		return
+	}
+	text := "提醒: " + r.Text
+	if r.Spec == "" && time.Since(r.At) > time.Minute {
+		text = fmt.Sprintf("提醒(原定于%s): %s", r.At.Format("2006-01-02 15:04"), r.Text)
+	}
+	if r.Nick != "" {
+		m.bot.SendPub(r.To, m.currentNick(r)+": "+text)
+	} else {
+		m.bot.SendAuto(r.To, text)
+	}
+	if r.Spec == "" {
+		m.remove(id)
+	}
+}
+
+// 设置者在聊天室中的当前昵称，设置者已修改昵称时提及新的昵称
+func (m *Remind) currentNick(r Reminder) string {
+	if strings.Contains(r.Owner, "/") {
+		return r.Nick
+	}
+	for _, o := range m.bot.GetOccupants(r.To) {
+		if jid, _ := utils.SplitJID(o.JID); jid == r.Owner {
+			return o.Nick
+		}
+	}
+	return r.Nick
+}
+
+func (m *Remind) remove(id int) {
+	m.bot.GetCron().RemoveJob(m.jobName(id))
+	m.lock.Lock()
+	delete(m.reminders, id)
+	m.lock.Unlock()
+	m.saveReminders()
+}
+
+// 返回owner设置的提醒，按ID排序
+func (m *Remind) ownReminders(owner string) []Reminder {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var list []Reminder
+	for _, r := range m.reminders {
+		if owner == "" || r.Owner == owner {
+			list = append(list, r)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+func (m *Remind) loadReminders() {
+	store := m.bot.GetStore()
+	var list []Reminder
+	if _, err := store.Get(m.GetName(), "reminders", &list); err != nil {
+		fmt.Printf("[%s] Load reminders error: %v\n", m.GetName(), err)
+	}
+	m.lock.Lock()
+	store.Get(m.GetName(), "seq", &m.seq)
+	m.reminders = map[int]Reminder{}
+	for _, r := range list {
+		m.reminders[r.ID] = r
+		if r.ID > m.seq {
+			m.seq = r.ID
+		}
+	}
+	m.lock.Unlock()
+	for _, r := range list {
+		if err := m.scheduleReminder(r); err != nil {
+			fmt.Printf("[%s] Load reminder #%d error: %v\n", m.GetName(), r.ID, err)
+		}
+	}
+}
+
+func (m *Remind) saveReminders() {
+	list := m.ownReminders("")
+	m.lock.Lock()
+	seq := m.seq
+	m.lock.Unlock()
+	store := m.bot.GetStore()
+	if err := store.Put(m.GetName(), "reminders", list); err != nil {
+		fmt.Printf("[%s] Save reminders error: %v\n", m.GetName(), err)
+	}
+	store.Put(m.GetName(), "seq", seq)
+}
+
+func (m *Remind) cmd_mod_add(msg xmpp.Chat, args *robot.Args) {
+	m.add(msg, args.String("Text"))
+}
+
+func (m *Remind) cmd_mod_list(msg xmpp.Chat, args *robot.Args) {
+	owner, _, _ := m.owner(msg)
+	list := m.ownReminders(owner)
+	if len(list) == 0 {
+		m.bot.ReplyAuto(msg, "您没有设置提醒。")
+		return
+	}
+	txt := []string{"==您设置的提醒=="}
+	for _, r := range list {
+		txt = append(txt, r.String())
+	}
+	m.bot.ReplyAuto(msg, strings.Join(txt, "\n"))
+}
+
+func (m *Remind) cmd_mod_cancel(msg xmpp.Chat, args *robot.Args) {
+	id, err := strconv.Atoi(strings.TrimPrefix(args.String("ID"), "#"))
+	m.lock.Lock()
+	r, ok := m.reminders[id]
+	m.lock.Unlock()
+	owner, _, _ := m.owner(msg)
+	if err != nil || !ok || (r.Owner != owner && !m.bot.IsAdminID(owner)) {
+		m.reply(msg, "没有此提醒: "+args.String("ID"))
+		return
+	}
+	m.remove(id)
+	m.reply(msg, "已取消提醒 "+r.String())
+}
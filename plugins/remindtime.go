@@ -0,0 +1,365 @@
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 提醒时间，At为一次性提醒的时间，Spec为重复提醒的cron表达式
+type remindWhen struct {
+	At   time.Time
+	Spec string
+}
+
+// 未指定时刻时的默认提醒时间
+const defaultRemindHour = 9
+
+const (
+	zhNum  = `\d+|[零一二两三四五六七八九十]+`
+	enUnit = `seconds?|secs?|s|minutes?|mins?|m|hours?|hrs?|h|days?|d|weeks?|w`
+	zhUnit = `秒钟?|分钟?|小时|钟头|天|周|星期|礼拜`
+)
+
+var (
+	reRemindPrefix = regexp.MustCompile(`(?i)^(remind\s+me\s*|提醒我\s*|提醒\s*)`)
+	reRemindLead   = regexp.MustCompile(`(?i)^(to\s+|that\s+|about\s+|提醒我|提醒|叫我|[,，:：;；、])\s*`)
+
+	reEvery   = regexp.MustCompile(`(?i)^(every\s+|每\s*)`)
+	reDaily   = regexp.MustCompile(`(?i)^daily\b\s*`)
+	reEvDay   = regexp.MustCompile(`(?i)^(day\b|天|日)\s*`)
+	reEvWork  = regexp.MustCompile(`(?i)^(weekdays?\b|个?工作日)\s*`)
+	reEvEn    = regexp.MustCompile(`(?i)^(\d+)?\s*(` + enUnit + `)\b\s*`)
+	reEvZh    = regexp.MustCompile(`^(` + zhNum + `)?\s*个?(` + zhUnit + `)\s*`)
+	reEnAfter = regexp.MustCompile(`(?i)^in\s+(\d+|an?|half\s+an?)\s*(` + enUnit + `)\b\s*`)
+	reEnDur   = regexp.MustCompile(`(?i)^in\s+((\d+[hms])+)\b\s*`)
+	reZhAfter = regexp.MustCompile(`^(` + zhNum + `|半)\s*(个半|个)?\s*(` + zhUnit + `)\s*(以后|之后|后)\s*`)
+	reEnDay   = regexp.MustCompile(`(?i)^(today|tomorrow)\b\s*`)
+	reZhDay   = regexp.MustCompile(`^(今天|今晚|明天|明早|明晚|后天|大后天)\s*`)
+	reEnWeek  = regexp.MustCompile(`(?i)^(on\s+)?(next\s+)?(monday|mon|tuesday|tues|tue|wednesday|wed|thursday|thurs|thu|friday|fri|saturday|sat|sunday|sun)\b\s*`)
+	reZhWeek  = regexp.MustCompile(`^(下个?)?(周|星期|礼拜)([一二三四五六日天])\s*`)
+	reDate    = regexp.MustCompile(`^(?:on\s+)?(\d{4})[-/.](\d{1,2})[-/.](\d{1,2})\s*`)
+	reZhDate  = regexp.MustCompile(`^(?:(\d{4})年)?(\d{1,2})月(\d{1,2})[日号]\s*`)
+	reEnClock = regexp.MustCompile(`(?i)^(?:at\s+)?(\d{1,2})(?:[:：](\d{2}))?\s*(am|pm)\b\s*`)
+	reHHMM    = regexp.MustCompile(`(?i)^(?:at\s+)?(\d{1,2})[:：](\d{2})\s*`)
+	reAtHour  = regexp.MustCompile(`(?i)^at\s+(\d{1,2})\b\s*`)
+	reZhClock = regexp.MustCompile(`^(早上|早晨|上午|中午|下午|傍晚|晚上|夜里|凌晨)?\s*(` + zhNum + `)\s*[点时]\s*(半|一刻|三刻|\d{1,2}\s*分?|[零一二两三四五六七八九十]+\s*分)?\s*`)
+	reZhHHMM  = regexp.MustCompile(`^(早上|早晨|上午|中午|下午|傍晚|晚上|夜里|凌晨)\s*(\d{1,2})[:：](\d{2})\s*`)
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+	"日": time.Sunday, "天": time.Sunday, "一": time.Monday, "二": time.Tuesday, "三": time.Wednesday,
+	"四": time.Thursday, "五": time.Friday, "六": time.Saturday,
+}
+
+// 解析阿拉伯数字或一百以内的中文数字
+func parseNumber(s string) (int, bool) {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n, true
+	}
+	digits := map[rune]int{'零': 0, '一': 1, '二': 2, '两': 2, '三': 3, '四': 4, '五': 5, '六': 6, '七': 7, '八': 8, '九': 9}
+	n, cur := 0, 0
+	for _, r := range s {
+		if r == '十' {
+			if cur == 0 {
+				cur = 1
+			}
+			n += cur * 10
+			cur = 0
+			continue
+		}
+		d, ok := digits[r]
+		if !ok {
+			return 0, false
+		}
+		cur = d
+	}
+	return n + cur, s != ""
+}
+
+// 时间单位对应的时长
+func unitDuration(unit string) time.Duration {
+	unit = strings.ToLower(unit)
+	switch {
+	case strings.HasPrefix(unit, "s"), strings.HasPrefix(unit, "秒"):
+		return time.Second
+	case strings.HasPrefix(unit, "m"), strings.HasPrefix(unit, "分"):
+		return time.Minute
+	case strings.HasPrefix(unit, "h"), strings.HasPrefix(unit, "小时"), strings.HasPrefix(unit, "钟头"):
+		return time.Hour
+	case strings.HasPrefix(unit, "d"), unit == "天":
+		return 24 * time.Hour
+	}
+	return 7 * 24 * time.Hour
+}
+
+// 解析时刻，如"9:30", "3pm", "at 5", "下午3点半", "晚上8点20分"
+func parseClock(s string) (h, min int, rest string, ok bool) {
+	if m := reEnClock.FindStringSubmatch(s); m != nil {
+		h, _ = strconv.Atoi(m[1])
+		min, _ = strconv.Atoi(m[2])
+		if pm := strings.ToLower(m[3]) == "pm"; pm && h < 12 {
+			h += 12
+		} else if !pm && h == 12 {
+			h = 0
+		}
+		return h, min, s[len(m[0]):], h < 24 && min < 60
+	}
+	if m := reZhHHMM.FindStringSubmatch(s); m != nil {
+		h, _ = strconv.Atoi(m[2])
+		min, _ = strconv.Atoi(m[3])
+		h = adjustPeriod(m[1], h)
+		return h, min, s[len(m[0]):], h < 24 && min < 60
+	}
+	if m := reHHMM.FindStringSubmatch(s); m != nil {
+		h, _ = strconv.Atoi(m[1])
+		min, _ = strconv.Atoi(m[2])
+		return h, min, s[len(m[0]):], h < 24 && min < 60
+	}
+	if m := reAtHour.FindStringSubmatch(s); m != nil {
+		h, _ = strconv.Atoi(m[1])
+		return h, 0, s[len(m[0]):], h < 24
+	}
+	if m := reZhClock.FindStringSubmatch(s); m != nil {
+		if h, ok = parseNumber(m[2]); !ok {
+			return
+		}
+		switch part := strings.TrimSuffix(strings.TrimSpace(m[3]), "分"); part {
+		case "":
+		case "半":
+			min = 30
+		case "一刻":
+			min = 15
+		case "三刻":
+			min = 45
+		default:
+			if min, ok = parseNumber(strings.TrimSpace(part)); !ok {
+				return
+			}
+		}
+		h = adjustPeriod(m[1], h)
+		return h, min, s[len(m[0]):], h < 24 && min < 60
+	}
+	return 0, 0, s, false
+}
+
+// 按"下午"、"晚上"等调整小时
+func adjustPeriod(period string, h int) int {
+	switch period {
+	case "下午", "傍晚", "晚上", "夜里":
+		if h < 12 {
+			h += 12
+		}
+	case "中午":
+		if h < 11 {
+			h += 12
+		}
+	case "凌晨", "早上", "早晨", "上午":
+		if h == 12 {
+			h = 0
+		}
+	}
+	return h
+}
+
+// 解析日期，返回当天零点，week为true时表示"周五"这样的星期，已过时应推迟一周
+func parseDay(s string, now time.Time) (day time.Time, rest string, week, ok bool) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if m := reEnDay.FindStringSubmatch(s); m != nil {
+		if strings.ToLower(m[1]) == "tomorrow" {
+			today = today.AddDate(0, 0, 1)
+		}
+		return today, s[len(m[0]):], false, true
+	}
+	if m := reZhDay.FindStringSubmatch(s); m != nil {
+		rest = s[len(m[0]):]
+		switch m[1] {
+		case "明天", "明早", "明晚":
+			today = today.AddDate(0, 0, 1)
+		case "后天":
+			today = today.AddDate(0, 0, 2)
+		case "大后天":
+			today = today.AddDate(0, 0, 3)
+		}
+		// "今晚8点"、"明早9点"中的"晚"、"早"
+		switch m[1] {
+		case "今晚", "明晚":
+			rest = "晚上" + rest
+		case "明早":
+			rest = "早上" + rest
+		}
+		return today, rest, false, true
+	}
+	if m := reEnWeek.FindStringSubmatch(s); m != nil {
+		wd := weekdays[strings.ToLower(m[3])[:3]]
+		n := (int(wd) - int(now.Weekday()) + 7) % 7
+		if m[2] != "" && n == 0 {
+			n = 7
+		}
+		return today.AddDate(0, 0, n), s[len(m[0]):], m[2] == "", true
+	}
+	if m := reZhWeek.FindStringSubmatch(s); m != nil {
+		wd := weekdays[m[3]]
+		if m[1] != "" {
+			// 下周X: 下周一开始的一周中的星期X
+			monday := today.AddDate(0, 0, 7-(int(now.Weekday())+6)%7)
+			return monday.AddDate(0, 0, (int(wd)+6)%7), s[len(m[0]):], false, true
+		}
+		n := (int(wd) - int(now.Weekday()) + 7) % 7
+		return today.AddDate(0, 0, n), s[len(m[0]):], true, true
+	}
+	if m := reDate.FindStringSubmatch(s); m != nil {
+		y, _ := strconv.Atoi(m[1])
+		mon, _ := strconv.Atoi(m[2])
+		d, _ := strconv.Atoi(m[3])
+		return time.Date(y, time.Month(mon), d, 0, 0, 0, 0, now.Location()), s[len(m[0]):], false, true
+	}
+	if m := reZhDate.FindStringSubmatch(s); m != nil {
+		y := now.Year()
+		if m[1] != "" {
+			y, _ = strconv.Atoi(m[1])
+		}
+		mon, _ := strconv.Atoi(m[2])
+		d, _ := strconv.Atoi(m[3])
+		day = time.Date(y, time.Month(mon), d, 0, 0, 0, 0, now.Location())
+		if m[1] == "" && day.Before(today) {
+			day = day.AddDate(1, 0, 0)
+		}
+		return day, s[len(m[0]):], false, true
+	}
+	return today, s, false, false
+}
+
+// 重复周期的字符串形式，去掉为零的秒及分，如"30m"、"2h"、"1h30m"
+func everyString(d time.Duration) string {
+	every := strings.TrimSuffix(d.String(), "m0s")
+	if every != d.String() {
+		every += "m"
+	}
+	if strings.HasSuffix(every, "h0m") {
+		every = strings.TrimSuffix(every, "0m")
+	}
+	return every
+}
+
+// 解析重复提醒，text为"every"或"每"之后的内容
+func parseEvery(s string) (spec, rest string, err error) {
+	dow := ""
+	if m := reEvDay.FindString(s); m != "" {
+		dow, s = "*", s[len(m):]
+	} else if m := reEvWork.FindString(s); m != "" {
+		dow, s = "1-5", s[len(m):]
+	} else if m := reEnWeek.FindStringSubmatch(s); m != nil && m[1] == "" && m[2] == "" {
+		dow, s = strconv.Itoa(int(weekdays[strings.ToLower(m[3])[:3]])), s[len(m[0]):]
+	} else if m := reZhWeek.FindStringSubmatch(strings.TrimPrefix(s, "个")); m != nil && m[1] == "" {
+		dow, s = strconv.Itoa(int(weekdays[m[3]])), strings.TrimPrefix(s, "个")[len(m[0]):]
+	}
+	if dow == "" {
+		m := reEvEn.FindStringSubmatch(s)
+		if m == nil {
+			m = reEvZh.FindStringSubmatch(s)
+		}
+		if m == nil {
+			return "", s, errors.New("无法识别重复周期")
+		}
+		n := 1
+		if m[1] != "" {
+			if n, _ = parseNumber(m[1]); n <= 0 {
+				return "", s, errors.New("无法识别重复周期")
+			}
+		}
+		d := time.Duration(n) * unitDuration(m[2])
+		if d < time.Minute {
+			return "", s, errors.New("重复周期不能小于1分钟")
+		}
+		return "@every " + everyString(d), s[len(m[0]):], nil
+	}
+	h, min := defaultRemindHour, 0
+	if hh, mm, r, ok := parseClock(s); ok {
+		h, min, s = hh, mm, r
+	}
+	return fmt.Sprintf("0 %d %d * * %s", min, h, dow), s, nil
+}
+
+// 解析提醒，返回提醒时间及提醒内容。支持:
+//
+//	10分钟后 部署 / 半小时后 / 两天后
+//	明天 9:00 开会 / 下午3点半 / 周五 17:00 / 下周一上午10点 / 10月20日 9点
+//	每天 9:00 / 每周五 17:00 / 每个工作日 9:30 / 每30分钟
+//	in 20 minutes to deploy / in 1h30m / tomorrow 9am / next friday 17:00 / 2026-10-20 15:00
+//	every day 9:00 / every Friday 17:00 / every weekday 9:30 / every 2 hours
+func parseRemind(text string, now time.Time) (when remindWhen, what string, err error) {
+	s := strings.TrimSpace(text)
+	s = strings.TrimSpace(s[len(reRemindPrefix.FindString(s)):])
+
+	if m := reDaily.FindString(s); m != "" {
+		s = "every day " + s[len(m):]
+	}
+	if m := reEvery.FindString(s); m != "" {
+		if when.Spec, s, err = parseEvery(s[len(m):]); err != nil {
+			return
+		}
+	} else if m := reEnAfter.FindStringSubmatch(s); m != nil {
+		// "in an hour"、"in half an hour"
+		d := unitDuration(m[2])
+		if n, ok := parseNumber(m[1]); ok {
+			d *= time.Duration(n)
+		} else if strings.HasPrefix(strings.ToLower(m[1]), "half") {
+			d /= 2
+		}
+		when.At, s = now.Add(d), s[len(m[0]):]
+	} else if m := reEnDur.FindStringSubmatch(s); m != nil {
+		d, _ := time.ParseDuration(m[1])
+		when.At, s = now.Add(d), s[len(m[0]):]
+	} else if m := reZhAfter.FindStringSubmatch(s); m != nil {
+		d := unitDuration(m[3])
+		if m[1] == "半" {
+			d /= 2
+		} else {
+			n, _ := parseNumber(m[1])
+			d *= time.Duration(n)
+			if m[2] == "个半" {
+				d += unitDuration(m[3]) / 2
+			}
+		}
+		when.At, s = now.Add(d), s[len(m[0]):]
+	} else {
+		day, rest, week, hasDay := parseDay(s, now)
+		h, min, rest, hasClock := parseClock(rest)
+		if !hasDay && !hasClock {
+			return when, "", errors.New("无法识别提醒时间")
+		}
+		if !hasClock {
+			h = defaultRemindHour
+		}
+		when.At, s = day.Add(time.Duration(h)*time.Hour+time.Duration(min)*time.Minute), rest
+		if !when.At.After(now) {
+			switch {
+			case week:
+				when.At = when.At.AddDate(0, 0, 7)
+			case !hasDay:
+				when.At = when.At.AddDate(0, 0, 1)
+			default:
+				return when, "", errors.New("提醒时间已过: " + when.At.Format("2006-01-02 15:04"))
+			}
+		}
+	}
+
+	for {
+		s = strings.TrimSpace(s)
+		m := reRemindLead.FindString(s)
+		if m == "" {
+			break
+		}
+		s = s[len(m):]
+	}
+	if s == "" {
+		return when, "", errors.New("缺少提醒内容")
+	}
+	return when, s, nil
+}
@@ -0,0 +1,84 @@
+package plugins
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRemind(t *testing.T) {
+	// 2026-10-14 是星期三
+	now := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+	at := func(month, day, hour, min int) time.Time {
+		return time.Date(2026, time.Month(month), day, hour, min, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		text string
+		at   time.Time
+		spec string
+		what string
+	}{
+		{"10分钟后 部署", at(10, 14, 10, 10), "", "部署"},
+		{"半小时后 喝水", at(10, 14, 10, 30), "", "喝水"},
+		{"两天后 交报告", at(10, 16, 10, 0), "", "交报告"},
+		{"明天 9:00 开会", at(10, 15, 9, 0), "", "开会"},
+		{"下午3点半 开会", at(10, 14, 15, 30), "", "开会"},
+		{"周五 17:00 周报", at(10, 16, 17, 0), "", "周报"},
+		{"下周一上午10点 例会", at(10, 19, 10, 0), "", "例会"},
+		{"10月20日 9点 体检", at(10, 20, 9, 0), "", "体检"},
+		{"每天 9:00 打卡", time.Time{}, "0 0 9 * * *", "打卡"},
+		{"每周五 17:00 周报", time.Time{}, "0 0 17 * * 5", "周报"},
+		{"每个工作日 9:30 站会", time.Time{}, "0 30 9 * * 1-5", "站会"},
+		{"每30分钟 喝水", time.Time{}, "@every 30m", "喝水"},
+		{"in 20 minutes to deploy", at(10, 14, 10, 20), "", "deploy"},
+		{"in 1h30m stretch", at(10, 14, 11, 30), "", "stretch"},
+		{"tomorrow 9am standup", at(10, 15, 9, 0), "", "standup"},
+		{"next friday 17:00 report", at(10, 16, 17, 0), "", "report"},
+		{"2026-10-20 15:00 meeting", at(10, 20, 15, 0), "", "meeting"},
+		{"every day 9:00 standup", time.Time{}, "0 0 9 * * *", "standup"},
+		{"every Friday 17:00 report", time.Time{}, "0 0 17 * * 5", "report"},
+		{"every weekday 9:30 standup", time.Time{}, "0 30 9 * * 1-5", "standup"},
+		{"every 2 hours stretch", time.Time{}, "@every 2h", "stretch"},
+		{"提醒我10分钟后部署", at(10, 14, 10, 10), "", "部署"},
+		{"明天9点提醒我开会", at(10, 15, 9, 0), "", "开会"},
+		{"remind me in 20 minutes to deploy", at(10, 14, 10, 20), "", "deploy"},
+	}
+	for _, tt := range tests {
+		when, what, err := parseRemind(tt.text, now)
+		if err != nil {
+			t.Errorf("parseRemind(%q) error: %v", tt.text, err)
+			continue
+		}
+		if !when.At.Equal(tt.at) || when.Spec != tt.spec || what != tt.what {
+			t.Errorf("parseRemind(%q) = %v %q %q, want %v %q %q", tt.text, when.At, when.Spec, what, tt.at, tt.spec, tt.what)
+		}
+		if strings.HasPrefix(when.Spec, "@every ") {
+			if _, err := time.ParseDuration(strings.TrimPrefix(when.Spec, "@every ")); err != nil {
+				t.Errorf("parseRemind(%q) spec %q: %v", tt.text, when.Spec, err)
+			}
+		}
+	}
+}
+
+func TestEveryString(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{time.Minute, "1m"},
+		{10 * time.Minute, "10m"},
+		{30 * time.Minute, "30m"},
+		{90 * time.Minute, "1h30m"},
+		{2 * time.Hour, "2h"},
+		{20 * time.Hour, "20h"},
+		{90 * time.Second, "1m30s"},
+	}
+	for _, tt := range tests {
+		if got := everyString(tt.d); got != tt.want {
+			t.Errorf("everyString(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+		if _, err := time.ParseDuration(everyString(tt.d)); err != nil {
+			t.Errorf("everyString(%v): %v", tt.d, err)
+		}
+	}
+}
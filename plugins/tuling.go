@@ -12,22 +12,32 @@ import (
 )
 
 type Tuling struct {
-	Name   string
-	URL    string
-	Key    string
-	bot    *robot.Bot
-	Option map[string]bool
+	Name string
+	URL  string
+	Key  string
+	bot  *robot.Bot
+	*robot.OptionSet
+}
+
+var tulingOptions = robot.Schema{
+	{Name: "chat", Type: robot.BoolOption, Default: true, Description: "是否响应好友消息"},
+	{Name: "room", Type: robot.BoolOption, Default: true, Description: "是否响应群聊呼叫消息"},
+	{Name: "key", Type: robot.StringOption, Required: true, Description: "图灵机器人API key", Hidden: true},
+}
+
+func init() {
+	robot.RegisterPlugin("tuling", func(name string, opt map[string]interface{}) robot.PluginIface {
+		return NewTuling(name, opt)
+	}, robot.PluginMeta{Summary: "图灵机器人模块", Options: tulingOptions})
 }
 
 func NewTuling(name string, opt map[string]interface{}) *Tuling {
+	options := robot.NewOptionSet(tulingOptions, opt)
 	return &Tuling{
-		Name: name,
-		URL:  "http://www.tuling123.com/openapi/api",
-		Key:  opt["key"].(string),
-		Option: map[string]bool{
-			"chat": true,
-			"room": true,
-		},
+		Name:      name,
+		URL:       "http://www.tuling123.com/openapi/api",
+		Key:       options.String("key"),
+		OptionSet: options,
 	}
 }
 
@@ -47,15 +57,7 @@ func (m *Tuling) Help() string {
 }
 
 func (m *Tuling) Description() string {
-	msg := []string{m.Help(),
-		"本模块可配置属性:",
-	}
-	options := m.GetOptions()
-	keys := utils.SortMapKeys(options)
-	for _, v := range keys {
-		msg = append(msg, fmt.Sprintf("%-20s : %s", v, options[v]))
-	}
-	return strings.Join(msg, "\n")
+	return m.Describe(m.Help())
 }
 
 func (m *Tuling) CheckEnv() bool {
@@ -85,18 +87,18 @@ func (m *Tuling) Chat(msg xmpp.Chat) {
 	}
 
 	if msg.Type == "chat" {
-		if m.Option["chat"] {
-			m.bot.ReplyAuto(msg, m.GetAnswer(msg.Text, utils.GetMd5(msg.Remote)))
+		if m.Bool("chat") {
+			m.bot.ReplyAutoMsg(msg, m.GetAnswer(msg.Text, utils.GetMd5(msg.Remote)))
 		}
 	} else if msg.Type == "groupchat" {
-		if m.Option["room"] {
+		if m.Bool("room") {
 			//忽略bot自己发送的消息
 			if m.bot.SentThis(msg) || m.bot.BlockRemote(msg) {
 				return
 			}
 			if ok, message := m.bot.Called(msg); ok {
 				roomid, _ := utils.SplitJID(msg.Remote)
-				m.bot.SendPub(roomid, m.GetAnswer(message, utils.GetMd5(msg.Remote)))
+				m.bot.SendPubMsg(roomid, m.GetAnswer(message, utils.GetMd5(msg.Remote)))
 			}
 		}
 	}
@@ -105,29 +107,11 @@ func (m *Tuling) Chat(msg xmpp.Chat) {
 func (m *Tuling) Presence(pres xmpp.Presence) {
 }
 
-func (m *Tuling) GetOptions() map[string]string {
-	opts := map[string]string{}
-	for k, v := range m.Option {
-		if k == "chat" {
-			opts[k] = utils.BoolToString(v) + "  #是否响应好友消息"
-		} else if k == "room" {
-			opts[k] = utils.BoolToString(v) + "  #是否响应群聊呼叫消息"
-		}
-	}
-	return opts
-}
-
-func (m *Tuling) SetOption(key, val string) {
-	if _, ok := m.Option[key]; ok {
-		m.Option[key] = utils.StringToBool(val)
-	}
-}
-
-func (m *Tuling) Request(words, uid string) (text string, err error) {
+func (m *Tuling) Request(words, uid string) (text *robot.Message, err error) {
 
 	resp, err := http.Get(fmt.Sprintf("%s?key=%s&userid=%s&loc=%s&info=%s", m.URL, m.Key, uid, "北京上地", words))
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	body, err := ioutil.ReadAll(resp.Body)
@@ -136,41 +120,47 @@ func (m *Tuling) Request(words, uid string) (text string, err error) {
 	var json *simplejson.Json
 	json, err = simplejson.NewJson(body)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	// 去掉回答中的"图灵"
+	str := func(v interface{}) string {
+		s, _ := v.(string)
+		return strings.Replace(s, "图灵", "", -1)
 	}
 
+	text = robot.NewMessage()
 	code := json.Get("code").MustInt()
 	if code == 100000 {
-		text = json.Get("text").MustString()
+		text.Text(str(json.Get("text").MustString()))
 	} else if code == 200000 {
-		text = fmt.Sprintf("<p>%s, 点击查看<a href='%s'>详情</a><br/></p>", json.Get("text").MustString(), json.Get("url").MustString())
+		text.Text(str(json.Get("text").MustString())+", 点击查看").Link("详情", json.Get("url").MustString())
 	} else if code == 302000 {
-		var l []string
+		var l []*robot.Message
 		list := json.Get("list").MustArray()
 		for _, v := range list {
 			item := v.(map[string]interface{})
-			l = append(l, fmt.Sprintf("%s:<a href='%s'>%s</a><br/>", item["source"].(string), item["detailurl"].(string), item["article"].(string)))
+			l = append(l, robot.NewMessage().Text(str(item["source"])+":").Link(str(item["article"]), str(item["detailurl"])))
 		}
-		text = fmt.Sprintf("<p>%s<br/>%s<br/></p>", json.Get("text").MustString(), strings.Join(l, "\n"))
+		text.Text(str(json.Get("text").MustString())).List(l...)
 	} else if code == 308000 {
 		fmt.Printf("get menu info:%s\n", json.Get("text").MustString())
-		var l []string
+		var l []*robot.Message
 		list := json.Get("list").MustArray()
 		for _, v := range list {
 			item := v.(map[string]interface{})
-			l = append(l, fmt.Sprintf("<a href='%s'>%s</a>，食材:%s<br/>", item["detailurl"].(string), item["name"].(string), item["info"].(string)))
+			l = append(l, robot.NewMessage().Link(str(item["name"]), str(item["detailurl"])).Text("，食材:"+str(item["info"])))
 		}
-		text = fmt.Sprintf("<p>%s<br/>%s<br/></p>", json.Get("text").MustString(), strings.Join(l, "\n"))
+		text.Text(str(json.Get("text").MustString())).List(l...)
 	}
 	return text, nil
 }
 
-func (m *Tuling) GetAnswer(text, uid string) string {
+func (m *Tuling) GetAnswer(text, uid string) *robot.Message {
 	txt := strings.TrimSpace(text)
 
 	if text, err := m.Request(txt, uid); err != nil {
-		return "我知道了"
+		return robot.NewMessage().Text("我知道了")
 	} else {
-		return strings.Replace(text, "图灵", "", -1)
+		return text
 	}
 }
@@ -7,27 +7,34 @@ import (
 	"github.com/yetist/xmppbot/utils"
 	"net/http"
 	"regexp"
-	"strconv"
 	"strings"
 	"time"
 )
 
 type Url struct {
-	Name   string
-	bot    *robot.Bot
-	Option map[string]interface{}
+	Name string
+	bot  *robot.Bot
+	*robot.OptionSet
+}
+
+var urlOptions = robot.Schema{
+	{Name: "chat", Type: robot.BoolOption, Default: true, Description: "是否响应好友消息"},
+	{Name: "room", Type: robot.BoolOption, Default: true, Description: "是否响应群聊消息"},
+	{Name: "timeout", Type: robot.IntOption, Default: 5, Description: "访问链接超时时间"},
+	{Name: "width", Type: robot.IntOption, Default: 100, Description: "预览图片宽度"},
+	{Name: "height", Type: robot.IntOption, Default: 100, Description: "预览图片高度"},
+}
+
+func init() {
+	robot.RegisterPlugin("url", func(name string, opt map[string]interface{}) robot.PluginIface {
+		return NewUrl(name, opt)
+	}, robot.PluginMeta{Summary: "URL链接辅助模块", Options: urlOptions})
 }
 
 func NewUrl(name string, opt map[string]interface{}) *Url {
 	return &Url{
-		Name: name,
-		Option: map[string]interface{}{
-			"chat":    opt["chat"].(bool),
-			"room":    opt["room"].(bool),
-			"timeout": opt["timeout"].(int64),
-			"width":   100,
-			"height":  100,
-		},
+		Name:      name,
+		OptionSet: robot.NewOptionSet(urlOptions, opt),
 	}
 }
 
@@ -47,16 +54,8 @@ func (m *Url) Help() string {
 }
 
 func (m *Url) Description() string {
-	msg := []string{m.Help(),
-		"当用户在聊天过程中输入url时，机器人将自动去打开此url，并显示网页标题(html类型)或者显示一个缩略图(image类型).",
-		"本模块可配置属性:",
-	}
-	options := m.GetOptions()
-	keys := utils.SortMapKeys(options)
-	for _, v := range keys {
-		msg = append(msg, fmt.Sprintf("%-20s : %s", v, options[v]))
-	}
-	return strings.Join(msg, "\n")
+	return m.Describe(m.Help(),
+		"当用户在聊天过程中输入url时，机器人将自动去打开此url，并显示网页标题(html类型)或者显示一个缩略图(image类型).")
 }
 
 func (m *Url) CheckEnv() bool {
@@ -73,12 +72,7 @@ func (m *Url) Stop() {
 }
 
 func (m *Url) Restart() {
-	opt := m.bot.GetPluginOption(m.GetName())
-	m.Option["chat"] = opt["chat"].(bool)
-	m.Option["room"] = opt["room"].(bool)
-	m.Option["timeout"] = opt["timeout"].(int64)
-	m.Option["width"] = 100
-	m.Option["height"] = 100
+	m.Load(m.bot.GetPluginOption(m.GetName()))
 }
 
 func (m *Url) Chat(msg xmpp.Chat) {
@@ -87,100 +81,59 @@ func (m *Url) Chat(msg xmpp.Chat) {
 	}
 
 	if msg.Type == "chat" {
-		if m.Option["chat"].(bool) {
+		if m.Bool("chat") {
 			if m.bot.SentThis(msg) {
 				return
 			}
-			text := m.GetHelper(msg.Text)
-			if text != "" {
-				m.bot.ReplyAuto(msg, fmt.Sprintf("<p>%s</p>", text))
+			if text := m.GetHelper(msg.Text); text != nil {
+				m.bot.ReplyAutoMsg(msg, text)
 			}
 		}
 	} else if msg.Type == "groupchat" {
-		if m.Option["room"].(bool) {
+		if m.Bool("room") {
 			//忽略bot自己发送的消息
 			if m.bot.SentThis(msg) || m.bot.BlockRemote(msg) {
 				return
 			}
-			text := m.GetHelper(msg.Text)
-			if text != "" {
+			if text := m.GetHelper(msg.Text); text != nil {
 				roomid, nick := utils.SplitJID(msg.Remote)
-				m.bot.SendPub(roomid, fmt.Sprintf("<p>%s %s</p>", nick, text))
+				m.bot.SendPubMsg(roomid, robot.NewMessage().Text(nick+" ").Append(text))
 			}
 		}
 	}
 }
 
-func (m *Url) GetHelper(text string) string {
+func (m *Url) GetHelper(text string) *robot.Message {
 	if strings.Contains(text, "http://") || strings.Contains(text, "https://") {
 		for k, url := range GetUrls(text) {
 			if url != "" {
-				timeout := time.Duration(m.Option["timeout"].(int64))
+				timeout := time.Duration(m.Int("timeout"))
 				res, body, err := utils.HttpOpen(url, timeout, "")
 				if err != nil || res.StatusCode != http.StatusOK {
-					return fmt.Sprintf("对不起，无法打开此<a href='%s'>链接</a>", url)
+					return robot.NewMessage().Text("对不起，无法打开此").Link("链接", url)
 				}
 				if strings.HasPrefix(res.Header.Get("Content-Type"), "text/html") {
 					title := utils.GetUTF8HtmlTitle(string(body))
 					if title == "" {
-						return fmt.Sprintf("报歉，无法得到<a href='%s'>链接</a>标题", url)
+						return robot.NewMessage().Text("报歉，无法得到").Link("链接", url).Text("标题")
 					} else {
-						return fmt.Sprintf("发链接了，标题是[<a href='%s'>%s</a>]", url, title)
+						return robot.NewMessage().Text("发链接了，标题是[").Link(title, url).Text("]")
 					}
 				} else if strings.HasPrefix(res.Header.Get("Content-Type"), "image/") {
-					img := utils.GetBase64Image(body, m.Option["width"].(int), m.Option["height"].(int))
-					return fmt.Sprintf("发<a href='%s'>图片</a>了:<br/><img alt='点击看大图' src='%s'/>", url, img)
+					img := utils.GetBase64Image(body, m.Int("width"), m.Int("height"))
+					return robot.NewMessage().Text("发").Link("图片", url).Text("了:").Line().Image(img, "点击看大图")
 				} else {
 					println(k, url, "发了其它类型文件")
 				}
 			}
 		}
 	}
-	return ""
+	return nil
 }
 
 func (m *Url) Presence(pres xmpp.Presence) {
 }
 
-func (m *Url) GetOptions() map[string]string {
-	opts := map[string]string{}
-	for k, v := range m.Option {
-		if k == "chat" {
-			opts[k] = utils.BoolToString(v.(bool)) + "  #是否响应好友消息"
-		} else if k == "room" {
-			opts[k] = utils.BoolToString(v.(bool)) + "  #是否响应群聊消息"
-		} else if k == "timeout" {
-			opts[k] = strconv.FormatInt(v.(int64), 10) + "  #访问链接超时时间"
-		} else if k == "width" {
-			opts[k] = strconv.Itoa(v.(int)) + "  #预览图片宽度"
-		} else if k == "height" {
-			opts[k] = strconv.Itoa(v.(int)) + "  #预览图片高度"
-		}
-	}
-	return opts
-
-}
-
-func (m *Url) SetOption(key, val string) {
-	if _, ok := m.Option[key]; ok {
-		if key == "chat" || key == "room" {
-			m.Option[key] = utils.StringToBool(val)
-		} else if key == "timeout" {
-			if i, e := strconv.ParseInt(val, 10, 0); e == nil {
-				m.Option[key] = i
-			}
-		} else if key == "width" {
-			if i, e := strconv.Atoi(val); e == nil {
-				m.Option[key] = i
-			}
-		} else if key == "height" {
-			if i, e := strconv.Atoi(val); e == nil {
-				m.Option[key] = i
-			}
-		}
-	}
-}
-
 func GetUrls(source string) []string {
 	pattern := `https?://[\w\-./%?=&]+[\w\-./%?=&]*`
 	reg := regexp.MustCompile(pattern)
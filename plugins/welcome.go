@@ -0,0 +1,393 @@
+package plugins
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/mattn/go-xmpp"
+	"github.com/yetist/xmppbot/robot"
+	"github.com/yetist/xmppbot/utils"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// 成员进入后多久发送欢迎消息，此时已能查询到成员的真实jid, 成员很快离开时不发送
+const welcomeDelay = 3 * time.Second
+
+// 聊天室中的成员
+type welcomeOccupant struct {
+	nick  string
+	who   string      // 成员的标识(jid或昵称)，欢迎时确定
+	timer *time.Timer // 等待发送的欢迎消息
+}
+
+// 欢迎消息模板中可用的变量
+type welcomeData struct {
+	Nick  string
+	Room  string
+	Rules string
+}
+
+// 单独设置的属性
+var welcomeKeys = []string{"text", "rules", "private"}
+
+type Welcome struct {
+	Name    string
+	bot     *robot.Bot
+	lock    sync.Mutex
+	rooms   map[string]map[string]string           // 各聊天室单独设置的属性，"off"为"true"时不发送
+	greeted map[string]map[string]time.Time        // 各聊天室已欢迎过的成员(jid或昵称)及最后离开的时间
+	present map[string]map[string]*welcomeOccupant // 各聊天室当前的成员
+	ready   map[string]bool                        // bot已进入聊天室，此后收到的presence为新成员进入
+	*robot.OptionSet
+}
+
+var welcomeOptions = robot.Schema{
+	{Name: "text", Type: robot.StringOption, Default: "欢迎 {{.Nick}} 来到 {{.Room}}!{{if .Rules}} 请先阅读聊天室规则: {{.Rules}}{{end}}",
+		Description: "欢迎消息模板，可使用{{.Nick}}、{{.Room}}和{{.Rules}}"},
+	{Name: "rules", Type: robot.StringOption, Description: "聊天室规则的链接"},
+	{Name: "private", Type: robot.BoolOption, Description: "是否通过私聊发送欢迎消息"},
+	{Name: "again", Type: robot.IntOption, Default: 0, Description: "成员离开多少天后再次进入时重新欢迎，0表示只欢迎一次"},
+}
+
+func init() {
+	robot.RegisterPlugin("welcome", func(name string, opt map[string]interface{}) robot.PluginIface {
+		return NewWelcome(name, opt)
+	}, robot.PluginMeta{Summary: "欢迎模块", Options: welcomeOptions})
+}
+
+func NewWelcome(name string, opt map[string]interface{}) *Welcome {
+	return &Welcome{
+		Name:      name,
+		rooms:     map[string]map[string]string{},
+		greeted:   map[string]map[string]time.Time{},
+		present:   map[string]map[string]*welcomeOccupant{},
+		ready:     map[string]bool{},
+		OptionSet: robot.NewOptionSet(welcomeOptions, opt),
+	}
+}
+
+func (m *Welcome) GetName() string {
+	return m.Name
+}
+
+func (m *Welcome) GetSummary() string {
+	return "欢迎模块"
+}
+
+func (m *Welcome) Help() string {
+	msg := []string{
+		m.GetSummary() + ": 向新进入聊天室的成员发送欢迎消息。支持命令:",
+		m.bot.GetCmdString(m.GetName()) + "    欢迎模块命令" + m.bot.ShowPerm(m.GetName()),
+	}
+	return strings.Join(msg, "\n")
+}
+
+func (m *Welcome) Description() string {
+	return m.Describe(m.Help(),
+		"成员第一次进入聊天室时发送欢迎消息，已欢迎过的成员再次进入时不再发送(见again属性)。",
+		"可以通过命令为每个聊天室单独设置text、rules和private属性，或关闭欢迎消息。")
+}
+
+func (m *Welcome) CheckEnv() bool {
+	return true
+}
+
+func (m *Welcome) Start(bot *robot.Bot) {
+	fmt.Printf("[%s] Starting...\n", m.GetName())
+	m.bot = bot
+	m.bot.SetPerm(m.GetName(), robot.AllTalk|robot.AdminPerm)
+	rid := robot.Arg{Name: "Rid", Type: robot.JIDArg}
+	m.bot.AddCommand(m.GetName(), robot.Command{Name: m.GetName() + " show", Args: []robot.Arg{rid},
+		Help: "查看聊天室的欢迎消息设置", Handler: m.cmd_mod_show})
+	m.bot.AddCommand(m.GetName(), robot.Command{Name: m.GetName() + " set", Args: []robot.Arg{rid, {Name: "Key"}, {Name: "Value", Variadic: true}},
+		Help:  "设置聊天室的欢迎消息并开启，Key为" + strings.Join(welcomeKeys, ", ") + ", 如\"set room@conference.example.com text 欢迎{{.Nick}}\"",
+		Audit: true, Handler: m.cmd_mod_set})
+	m.bot.AddCommand(m.GetName(), robot.Command{Name: m.GetName() + " off", Args: []robot.Arg{rid},
+		Help: "关闭聊天室的欢迎消息", Audit: true, Handler: m.cmd_mod_off})
+	m.lock.Lock()
+	store := m.bot.GetStore()
+	if _, err := store.Get(m.GetName(), "rooms", &m.rooms); err != nil {
+		fmt.Printf("[%s] Load rooms error: %v\n", m.GetName(), err)
+	}
+	if m.rooms == nil {
+		m.rooms = map[string]map[string]string{}
+	}
+	m.loadGreeted()
+	// 模块重启时，已进入的聊天室中的成员不是新成员
+	for _, room := range m.bot.GetRooms() {
+		if !room.IsJoined() {
+			continue
+		}
+		m.ready[room.JID] = true
+		m.present[room.JID] = map[string]*welcomeOccupant{}
+		for _, o := range room.Occupants() {
+			m.present[room.JID][o.Nick] = &welcomeOccupant{nick: o.Nick, who: m.who(room.JID, o.Nick)}
+		}
+	}
+	m.lock.Unlock()
+}
+
+func (m *Welcome) Stop() {
+	fmt.Printf("[%s] Stop\n", m.GetName())
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for roomid := range m.present {
+		m.stopTimers(roomid)
+	}
+	m.present = map[string]map[string]*welcomeOccupant{}
+	m.ready = map[string]bool{}
+}
+
+func (m *Welcome) Restart() {
+	m.Load(m.bot.GetPluginOption(m.GetName()))
+}
+
+func (m *Welcome) Chat(msg xmpp.Chat) {
+	if len(msg.Text) == 0 || !msg.Stamp.IsZero() {
+		return
+	}
+	m.bot.RunCommand(m.GetName(), msg)
+}
+
+// 进入聊天室时服务器先发来已有成员的presence, 最后是bot自己的presence,
+// 此后收到的新昵称才是新进入的成员。go-xmpp的Presence不包含修改昵称的状态码(303),
+// 修改昵称的成员如同离开后重新进入，欢迎记录以真实jid为标识，因此不会再次欢迎。
+func (m *Welcome) Presence(pres xmpp.Presence) {
+	roomid, nick := utils.SplitJID(pres.From)
+	room := m.bot.GetRoom(roomid)
+	if room == nil || nick == "" || (pres.Type != "" && pres.Type != "unavailable") {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if room.IsMyNick(nick) {
+		if pres.Type == "unavailable" {
+			if room.IsNickChange(nick) {
+				// bot修改昵称，并没有离开
+				return
+			}
+			// bot离开或被踢出，重新进入时已有成员不是新成员
+			m.stopTimers(roomid)
+			delete(m.present, roomid)
+			delete(m.ready, roomid)
+		} else {
+			m.ready[roomid] = true
+		}
+		return
+	}
+	if m.present[roomid] == nil {
+		m.present[roomid] = map[string]*welcomeOccupant{}
+	}
+	nicks := m.present[roomid]
+	if pres.Type == "unavailable" {
+		if o := nicks[nick]; o != nil {
+			if o.timer != nil {
+				o.timer.Stop()
+			}
+			delete(nicks, nick)
+			m.left(roomid, o.who)
+		}
+		return
+	}
+	if _, ok := nicks[nick]; ok {
+		// 状态变化
+		return
+	}
+	o := &welcomeOccupant{nick: nick}
+	nicks[nick] = o
+	if m.ready[roomid] && m.enabled(roomid) {
+		o.timer = time.AfterFunc(welcomeDelay, func() { m.greet(roomid, o) })
+	}
+}
+
+// 停止聊天室中等待发送的欢迎消息，需持有m.lock
+func (m *Welcome) stopTimers(roomid string) {
+	for _, o := range m.present[roomid] {
+		if o.timer != nil {
+			o.timer.Stop()
+		}
+	}
+}
+
+// 记录已欢迎过的成员离开的时间，again以此计算离开了多久，需持有m.lock
+func (m *Welcome) left(roomid, who string) {
+	if _, ok := m.greeted[roomid][who]; !ok || m.Int("again") <= 0 {
+		return
+	}
+	m.greeted[roomid][who] = time.Now()
+	m.saveGreeted(roomid, who)
+}
+
+// 聊天室的属性，未单独设置时使用模块属性，需持有m.lock
+func (m *Welcome) get(roomid, key string) string {
+	if v, ok := m.rooms[roomid][key]; ok {
+		return v
+	}
+	if key == "private" {
+		return utils.BoolToString(m.Bool(key))
+	}
+	return m.String(key)
+}
+
+func (m *Welcome) enabled(roomid string) bool {
+	return m.rooms[roomid]["off"] != "true"
+}
+
+// 成员的标识，能查询到真实jid时使用jid, 否则使用昵称
+func (m *Welcome) who(roomid, nick string) string {
+	if o, ok := m.bot.GetOccupant(roomid, nick); ok && o.JID != "" {
+		jid, _ := utils.SplitJID(o.JID)
+		return strings.ToLower(jid)
+	}
+	return nick
+}
+
+func (m *Welcome) greet(roomid string, o *welcomeOccupant) {
+	m.lock.Lock()
+	if m.present[roomid][o.nick] != o || o.timer == nil {
+		// 已离开
+		m.lock.Unlock()
+		return
+	}
+	o.timer = nil
+	nick := o.nick
+	o.who = m.who(roomid, nick)
+	last, greeted := m.greeted[roomid][o.who]
+	again := time.Duration(m.Int("again")) * 24 * time.Hour
+	if greeted && (again <= 0 || time.Since(last) < again) {
+		m.lock.Unlock()
+		return
+	}
+	if m.greeted[roomid] == nil {
+		m.greeted[roomid] = map[string]time.Time{}
+	}
+	m.greeted[roomid][o.who] = time.Now()
+	m.saveGreeted(roomid, o.who)
+	text, rules, private := m.get(roomid, "text"), m.get(roomid, "rules"), utils.StringToBool(m.get(roomid, "private"))
+	m.lock.Unlock()
+
+	msg, err := renderWelcome(text, welcomeData{Nick: nick, Room: roomid, Rules: rules})
+	if err != nil {
+		fmt.Printf("[%s] Render welcome of %s error: %v\n", m.GetName(), roomid, err)
+		return
+	}
+	if private {
+		m.bot.SendAuto(roomid+"/"+nick, msg)
+	} else {
+		m.bot.SendPub(roomid, msg)
+	}
+}
+
+func renderWelcome(text string, data welcomeData) (string, error) {
+	tmpl, err := template.New("welcome").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// 已欢迎过的成员按"聊天室/成员"分别保存，每次只更新一个成员
+func (m *Welcome) loadGreeted() {
+	m.greeted = map[string]map[string]time.Time{}
+	store := m.bot.GetStore()
+	keys, err := store.Keys(m.GetName() + ".greeted")
+	if err != nil {
+		fmt.Printf("[%s] Load greeted error: %v\n", m.GetName(), err)
+		return
+	}
+	for _, k := range keys {
+		var t time.Time
+		roomid, who := utils.SplitJID(k)
+		if ok, _ := store.Get(m.GetName()+".greeted", k, &t); !ok || who == "" {
+			continue
+		}
+		if m.greeted[roomid] == nil {
+			m.greeted[roomid] = map[string]time.Time{}
+		}
+		m.greeted[roomid][who] = t
+	}
+}
+
+// 需持有m.lock
+func (m *Welcome) saveGreeted(roomid, who string) {
+	if err := m.bot.GetStore().Put(m.GetName()+".greeted", roomid+"/"+who, m.greeted[roomid][who]); err != nil {
+		fmt.Printf("[%s] Save greeted error: %v\n", m.GetName(), err)
+	}
+}
+
+func (m *Welcome) saveRooms() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if err := m.bot.GetStore().Put(m.GetName(), "rooms", m.rooms); err != nil {
+		fmt.Printf("[%s] Save rooms error: %v\n", m.GetName(), err)
+	}
+}
+
+func (m *Welcome) cmd_mod_show(msg xmpp.Chat, args *robot.Args) {
+	rid := args.String("Rid")
+	m.lock.Lock()
+	lines := []string{"==" + rid + " 的欢迎消息=="}
+	if m.enabled(rid) {
+		lines = append(lines, "状态: 开启")
+	} else {
+		lines = append(lines, "状态: 关闭")
+	}
+	for _, k := range welcomeKeys {
+		line := k + ": " + m.get(rid, k)
+		if _, ok := m.rooms[rid][k]; ok {
+			line += " (单独设置)"
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, fmt.Sprintf("已欢迎过%d人", len(m.greeted[rid])))
+	text, rules := m.get(rid, "text"), m.get(rid, "rules")
+	m.lock.Unlock()
+	if text, err := renderWelcome(text, welcomeData{Nick: "Nick", Room: rid, Rules: rules}); err == nil {
+		lines = append(lines, "示例: "+text)
+	}
+	m.bot.ReplyAuto(msg, strings.Join(lines, "\n"))
+}
+
+func (m *Welcome) cmd_mod_set(msg xmpp.Chat, args *robot.Args) {
+	rid, key, val := args.String("Rid"), args.String("Key"), args.String("Value")
+	switch key {
+	case "text":
+		if _, err := renderWelcome(val, welcomeData{}); err != nil {
+			m.bot.ReplyAuto(msg, "模板错误: "+err.Error())
+			return
+		}
+	case "rules":
+	case "private":
+		val = utils.BoolToString(utils.StringToBool(val))
+	default:
+		m.bot.ReplyAuto(msg, "Key应为"+strings.Join(welcomeKeys, ", ")+"之一")
+		return
+	}
+	m.lock.Lock()
+	if m.rooms[rid] == nil {
+		m.rooms[rid] = map[string]string{}
+	}
+	m.rooms[rid][key] = val
+	delete(m.rooms[rid], "off")
+	m.lock.Unlock()
+	m.saveRooms()
+	m.bot.ReplyAuto(msg, "已设置"+rid+"的"+key+"为"+val+", 欢迎消息已开启")
+}
+
+func (m *Welcome) cmd_mod_off(msg xmpp.Chat, args *robot.Args) {
+	rid := args.String("Rid")
+	m.lock.Lock()
+	if m.rooms[rid] == nil {
+		m.rooms[rid] = map[string]string{}
+	}
+	m.rooms[rid]["off"] = "true"
+	m.lock.Unlock()
+	m.saveRooms()
+	m.bot.ReplyAuto(msg, "已关闭"+rid+"的欢迎消息")
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/yetist/xmppbot/utils"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -21,27 +22,37 @@ type Admin struct {
 	Name      string
 	bot       *Bot
 	cfg       config.Config
-	Option    map[string]interface{}
 	loginTime time.Time
 	Rooms     []*Room
 	Friends   []string
 	admins    []string
-	crons     map[string]CronEntry
+	tasks     map[int]CronTask
+	taskSeq   int
+	cronLock  sync.Mutex
 	perms     map[string]int
+	permset   map[string]int // 通过命令设置的权限，优先于模块的默认权限
+	roles     []RoleRule
+	grants    []CmdGrant
+	rolesLock sync.RWMutex
+	roomsLock sync.RWMutex
+	lock      sync.RWMutex // 保护cfg, Friends, admins, perms及permset, 它们在各模块的goroutine中被读取
+	*OptionSet
 }
 
-type CronEntry struct {
-	spec string
-	to   string
-	text string
+var adminOptions = Schema{
+	{Name: "cmd_prefix", Type: StringOption, Default: "--", Description: "命令前缀"},
+	{Name: "auto-subscribe", Type: BoolOption, Description: "是否自动完成互加好友"},
 }
 
 func NewAdmin(name string) *Admin {
 	return &Admin{
-		Name:  name,
-		crons: map[string]CronEntry{},
+		Name:      name,
+		OptionSet: NewOptionSet(adminOptions, nil),
+		tasks:     map[int]CronTask{},
+		permset:   map[string]int{},
 		perms: map[string]int{
 			"help":   AllTalk,
+			"more":   AllTalk,
 			"admin":  ChatTalk | AdminPerm,
 			"bot":    ChatTalk | AdminPerm,
 			"cron":   ChatTalk | AdminPerm,
@@ -51,39 +62,87 @@ func NewAdmin(name string) *Admin {
 	}
 }
 
+func (m *Admin) perm(name string) int {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if perm, ok := m.permset[name]; ok {
+		return perm
+	}
+	return m.perms[name]
+}
+
 func (m *Admin) HasPerm(name string, msg xmpp.Chat) bool {
+	return m.HasCmdPerm(name, 0, msg)
+}
+
+// 检查命令权限，name为"room"或"room.send"的形式，通过命令设置的权限优先，
+// 其次为命令声明的权限perm, perm为0时使用顶级命令的权限。
+func (m *Admin) HasCmdPerm(name string, perm int, msg xmpp.Chat) bool {
+	m.lock.RLock()
+	p, ok := m.permset[name]
+	m.lock.RUnlock()
+	if ok {
+		perm = p
+	} else if perm == 0 {
+		perm = m.perm(strings.Split(name, ".")[0])
+	}
 	var talkcheck bool
 	if msg.Type == "chat" {
-		talkcheck = m.perms[name]&ChatTalk != 0
+		talkcheck = perm&ChatTalk != 0
 	} else if msg.Type == "groupchat" {
-		talkcheck = m.perms[name]&RoomTalk != 0
+		talkcheck = perm&RoomTalk != 0
 	}
 	permcheck := true
-	if m.perms[name]&AdminPerm != 0 {
-		permcheck = m.IsAdminID(msg.Remote)
-	}
-	if !permcheck {
-		m.bot.ReplyAuto(msg, "本命令仅限管理员使用。")
+	_, room, _ := m.sender(msg)
+	if need := m.cmdRole(name, perm, room); m.RoleOf(msg) < need {
+		permcheck = false
+		if need == RoleAdmin {
+			m.bot.ReplyAuto(msg, "本命令仅限管理员使用。")
+		} else {
+			m.bot.ReplyAuto(msg, "本命令仅限"+need.String()+"及以上角色使用。")
+		}
 	}
 	return talkcheck && permcheck
 }
 
 func (m *Admin) ShowPerm(name string) string {
 	var perms []string
-	if m.perms[name]&ChatTalk != 0 {
+	if m.perm(name)&ChatTalk != 0 {
 		perms = append(perms, "chat")
 	}
-	if m.perms[name]&RoomTalk != 0 {
+	if m.perm(name)&RoomTalk != 0 {
 		perms = append(perms, "room")
 	}
-	if m.perms[name]&AdminPerm != 0 {
+	if m.perm(name)&AdminPerm != 0 {
 		perms = append(perms, "admin")
 	}
 	return "(" + strings.Join(perms, ",") + ")"
 }
 
 func (m *Admin) SetPerm(name string, perm int) {
+	m.lock.Lock()
 	m.perms[name] = perm
+	m.lock.Unlock()
+}
+
+// 返回管理员列表的副本
+func (m *Admin) getAdmins() []string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return append([]string{}, m.admins...)
+}
+
+func (m *Admin) setAdmins(admins []string) {
+	m.lock.Lock()
+	m.admins = admins
+	m.lock.Unlock()
+	m.saveAdmins()
+}
+
+func (m *Admin) getConfig() config.Config {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.cfg
 }
 
 // BotInterface
@@ -99,6 +158,7 @@ func (m *Admin) Help() string {
 	text := []string{
 		m.GetSummary() + ": 提供了基础的机器人管理命令。",
 		m.GetCmdString("help") + "    查看帮助命令详情" + m.ShowPerm("help"),
+		m.GetCmdString("more") + "    查看较长回复的下一页" + m.ShowPerm("more"),
 		m.GetCmdString("admin") + "   查看管理员命令详情" + m.ShowPerm("admin"),
 		m.GetCmdString("bot") + "     查看机器人命令详情" + m.ShowPerm("bot"),
 		m.GetCmdString("cron") + "    查看计划任务命令详情" + m.ShowPerm("cron"),
@@ -109,81 +169,251 @@ func (m *Admin) Help() string {
 }
 
 func (m *Admin) Description() string {
-	msg := []string{m.Help(),
-		"本模块可配置属性:",
+	return m.Describe(m.Help())
+}
+
+func (m *Admin) CheckEnv() bool {
+	return true
+}
+
+func (m *Admin) Start(bot *Bot) {
+	fmt.Printf("[%s] Starting...\n", m.GetName())
+	m.loginTime = time.Now()
+	m.bot = bot
+	m.bot.Roster()
+	cfg := m.bot.GetConfig()
+	m.lock.Lock()
+	m.cfg = cfg
+	m.admins = append([]string{}, cfg.Setup.Admin...)
+	m.lock.Unlock()
+	m.OptionSet = NewOptionSet(adminOptions, map[string]interface{}{
+		"cmd_prefix":     cfg.Setup.CmdPrefix,
+		"auto-subscribe": cfg.Setup.AutoSubscribe,
+	})
+	m.Rooms = nil
+	for _, i := range cfg.Setup.Rooms {
+		room := roomFromConfig(i)
+		m.loadBlocks(room)
+		m.joinRoom(room)
+		m.addRoom(room)
+	}
+	m.loadState()
+	m.addCommands()
+	m.bot.AddHandler(m.Name, "/audit", m.AuditPage, "audit")
+}
+
+func roomFromConfig(i map[string]interface{}) *Room {
+	jid, _ := i["jid"].(string)
+	nickname, _ := i["nickname"].(string)
+	password, _ := i["password"].(string)
+	return NewRoom(jid, nickname, password)
+}
+
+func (m *Admin) getRoom(jid string) *Room {
+	for _, v := range m.GetRooms() {
+		if v.JID == jid {
+			return v
+		}
 	}
-	options := m.GetOptions()
-	keys := utils.SortMapKeys(options)
-	for _, v := range keys {
-		msg = append(msg, fmt.Sprintf("%-20s : %s", v, options[v]))
+	return nil
+}
+
+// Reload 按配置文件的变化更新管理员、命令前缀及聊天室，old为上次载入的配置文件内容。
+func (m *Admin) Reload(old, cfg config.Config) (changes []string) {
+	m.lock.Lock()
+	m.cfg = m.bot.GetConfig()
+
+	// 配置文件中的管理员，加上通过命令添加的管理员
+	admins := append([]string{}, cfg.Setup.Admin...)
+	for _, v := range m.admins {
+		if !utils.ListContains(old.Setup.Admin, v) && !utils.ListContains(admins, v) {
+			admins = append(admins, v)
+		}
+	}
+	if strings.Join(admins, ",") != strings.Join(m.admins, ",") {
+		changes = append(changes, "管理员: "+strings.Join(admins, ", "))
+	}
+	m.admins = admins
+	m.lock.Unlock()
+
+	if cfg.Setup.CmdPrefix != old.Setup.CmdPrefix {
+		m.Set("cmd_prefix", cfg.Setup.CmdPrefix)
+		m.bot.GetStore().Delete("options", m.Name+".cmd_prefix")
+		changes = append(changes, "命令前缀: "+cfg.Setup.CmdPrefix)
+	}
+	if cfg.Setup.AutoSubscribe != old.Setup.AutoSubscribe {
+		m.Set("auto-subscribe", utils.BoolToString(cfg.Setup.AutoSubscribe))
+		m.bot.GetStore().Delete("options", m.Name+".auto-subscribe")
+		changes = append(changes, "自动加好友: "+utils.BoolToString(cfg.Setup.AutoSubscribe))
+	}
+
+	// 离开从配置文件中删除的聊天室，通过命令进入的聊天室不受影响
+	oldRooms := map[string]*Room{}
+	for _, i := range old.Setup.Rooms {
+		room := roomFromConfig(i)
+		oldRooms[room.JID] = room
+	}
+	newRooms := map[string]*Room{}
+	for _, i := range cfg.Setup.Rooms {
+		room := roomFromConfig(i)
+		newRooms[room.JID] = room
+	}
+	for jid := range oldRooms {
+		if _, ok := newRooms[jid]; !ok && m.getRoom(jid) != nil {
+			m.leaveRoom(jid)
+			changes = append(changes, "离开聊天室"+jid)
+		}
+	}
+	for _, i := range cfg.Setup.Rooms {
+		room := roomFromConfig(i)
+		prev, ok := oldRooms[room.JID]
+		if ok && prev.Nickname == room.Nickname && prev.Password == room.Password {
+			continue
+		}
+		if cur := m.getRoom(room.JID); cur == nil {
+			m.loadBlocks(room)
+			m.joinRoom(room)
+			m.addRoom(room)
+			changes = append(changes, "进入聊天室"+room.JID)
+		} else if cur.GetPassword() != room.Password {
+			m.bot.LeaveMUC(cur.JID)
+			cur.SetNick(room.Nickname)
+			cur.SetPassword(room.Password)
+			m.joinRoom(cur)
+			changes = append(changes, "重新进入聊天室"+room.JID)
+		} else if cur.GetNick() != room.Nickname {
+			m.ChangeNick(cur, room.Nickname, nil)
+			changes = append(changes, "修改在聊天室"+room.JID+"中的昵称为"+room.Nickname)
+		}
+	}
+	return
+}
+
+func (m *Admin) leaveRoom(jid string) bool {
+	if room := m.getRoom(jid); room != nil {
+		room.stopJoin()
+	}
+	if m.removeRoom(jid) {
+		m.bot.LeaveMUC(jid)
+		return true
 	}
-	return strings.Join(msg, "\n")
+	return false
 }
 
-func (m *Admin) GetOptions() map[string]string {
-	opts := map[string]string{}
-	for k, v := range m.Option {
-		switch k {
-		case "cmd_prefix":
-			opts[k] = v.(string) + "  #命令前缀"
-		case "auto-subscribe":
-			opts[k] = utils.BoolToString(v.(bool)) + "  #是否自动完成互加好友"
+// 聊天室列表以写时复制的方式修改，GetRooms返回的列表可在处理消息的其它goroutine中安全遍历
+func (m *Admin) addRoom(room *Room) {
+	m.roomsLock.Lock()
+	m.Rooms = append(append([]*Room{}, m.Rooms...), room)
+	m.roomsLock.Unlock()
+}
+
+func (m *Admin) removeRoom(jid string) bool {
+	m.roomsLock.Lock()
+	defer m.roomsLock.Unlock()
+	for k, room := range m.Rooms {
+		if room.JID == jid {
+			rooms := append([]*Room{}, m.Rooms[:k]...)
+			m.Rooms = append(rooms, m.Rooms[k+1:]...)
+			return true
 		}
 	}
-	return opts
+	return false
 }
 
-func (m *Admin) SetOption(key, val string) {
-	if _, ok := m.Option[key]; ok {
-		if key == "auto-subscribe" {
-			m.Option[key] = utils.StringToBool(val)
-		} else {
-			m.Option[key] = val
+// 从状态数据库中恢复临时管理员、命令权限及计划任务
+func (m *Admin) loadState() {
+	store := m.bot.GetStore()
+	var admins []string
+	if ok, err := store.Get(m.Name, "admins", &admins); ok {
+		for _, v := range admins {
+			if !m.IsAdminID(v) {
+				m.lock.Lock()
+				m.admins = append(m.admins, v)
+				m.lock.Unlock()
+			}
 		}
+	} else if err != nil {
+		fmt.Printf("[%s] Load admins error: %v\n", m.Name, err)
+	}
+
+	permset := map[string]int{}
+	if ok, err := store.Get(m.Name, "perms", &permset); ok {
+		m.lock.Lock()
+		m.permset = permset
+		m.lock.Unlock()
+	} else if err != nil {
+		fmt.Printf("[%s] Load perms error: %v\n", m.Name, err)
 	}
+
+	var roles []RoleRule
+	if ok, err := store.Get(m.Name, "roles", &roles); ok {
+		m.roles = roles
+	} else if err != nil {
+		fmt.Printf("[%s] Load roles error: %v\n", m.Name, err)
+	}
+
+	var grants []CmdGrant
+	if ok, err := store.Get(m.Name, "grants", &grants); ok {
+		m.grants = grants
+	} else if err != nil {
+		fmt.Printf("[%s] Load grants error: %v\n", m.Name, err)
+	}
+
+	m.loadTasks()
 }
 
-func (m *Admin) CheckEnv() bool {
-	return true
+func (m *Admin) saveState(key string, v interface{}) {
+	if err := m.bot.GetStore().Put(m.Name, key, v); err != nil {
+		fmt.Printf("[%s] Save %s error: %v\n", m.Name, key, err)
+	}
 }
 
-func (m *Admin) Start(bot *Bot) {
-	fmt.Printf("[%s] Starting...\n", m.GetName())
-	m.loginTime = time.Now()
-	m.bot = bot
-	m.bot.Roster()
-	m.cfg = m.bot.GetConfig()
-	m.admins = m.cfg.Setup.Admin
-	m.Option = map[string]interface{}{
-		"cmd_prefix":     m.cfg.Setup.CmdPrefix,
-		"auto-subscribe": m.cfg.Setup.AutoSubscribe,
-	}
-	for _, i := range m.cfg.Setup.Rooms {
-		password := ""
-		if i["password"] != nil {
-			password = i["password"].(string)
-		}
-		room := NewRoom(i["jid"].(string), i["nickname"].(string), password)
-		if len(room.Password) > 0 {
-			m.bot.JoinProtectedMUC(room.JID, room.Nickname, room.Password)
-		} else {
-			m.bot.JoinMUC(room.JID, room.Nickname)
+// 仅保存通过命令添加的管理员，配置文件中的管理员不需要保存
+func (m *Admin) saveAdmins() {
+	admins := []string{}
+	for _, v := range m.getAdmins() {
+		if !m.IsSysAdminID(v) {
+			admins = append(admins, v)
 		}
-		m.Rooms = append(m.Rooms, room)
 	}
+	m.saveState("admins", admins)
+}
+
+func (m *Admin) loadBlocks(room *Room) {
+	blocks := map[string][]string{}
+	if ok, _ := m.bot.GetStore().Get(m.Name, "blocks", &blocks); ok {
+		room.setBlocks(blocks[room.JID])
+	}
+}
+
+// SaveBlocks 保存各聊天室中忽略其消息的昵称。
+func (m *Admin) SaveBlocks() {
+	blocks := map[string][]string{}
+	if _, err := m.bot.GetStore().Get(m.Name, "blocks", &blocks); err != nil {
+		fmt.Printf("[%s] Load blocks error: %v\n", m.Name, err)
+	}
+	for _, v := range m.GetRooms() {
+		blocks[v.JID] = v.Blocks()
+	}
+	m.saveState("blocks", blocks)
 }
 
 func (m *Admin) Stop() {
-	for _, room := range m.Rooms {
+	for _, room := range m.GetRooms() {
+		room.stopJoin()
 		m.bot.LeaveMUC(room.JID)
 		fmt.Printf("[%s] Leave from %s\n", m.Name, room.JID)
 	}
 }
 
+// 重新进入所有聊天室
 func (m *Admin) Restart() {
 	m.Stop()
-	m.bot.SetStatus(m.cfg.Setup.Status, m.cfg.Setup.StatusMessage)
-	m.Start(m.bot)
+	cfg := m.getConfig()
+	m.bot.SetStatus(cfg.Setup.Status, cfg.Setup.StatusMessage)
+	for _, room := range m.GetRooms() {
+		m.joinRoom(room)
+	}
 }
 
 func (m *Admin) Chat(msg xmpp.Chat) {
@@ -191,45 +421,26 @@ func (m *Admin) Chat(msg xmpp.Chat) {
 		for _, v := range msg.Roster {
 			fmt.Printf("%#v\n", v)
 			if !m.IsFriendID(v.Remote) {
+				m.lock.Lock()
 				m.Friends = append(m.Friends, v.Remote)
+				m.lock.Unlock()
 			}
 			m.bot.SetRobert(v.Remote)
 		}
 	}
 
-	if len(msg.Text) == 0 || !msg.Stamp.IsZero() {
-		return
-	}
-
-	// 仅处理好友消息
-	if strings.HasPrefix(msg.Text, m.GetCmdString("help")) && m.HasPerm("help", msg) {
-		cmd := strings.TrimSpace(msg.Text[len(m.GetCmdString("help")):])
-		m.HelpCommand(cmd, msg)
-	} else if strings.HasPrefix(msg.Text, m.GetCmdString("room")) && m.HasPerm("room", msg) {
-		cmd := strings.TrimSpace(msg.Text[len(m.GetCmdString("room")):])
-		m.RoomCommand(cmd, msg)
-	} else if strings.HasPrefix(msg.Text, m.GetCmdString("cron")) && m.HasPerm("cron", msg) {
-		cmd := strings.TrimSpace(msg.Text[len(m.GetCmdString("cron")):])
-		m.CronCommand(cmd, msg)
-	} else if strings.HasPrefix(msg.Text, m.GetCmdString("bot")) && m.HasPerm("bot", msg) {
-		cmd := strings.TrimSpace(msg.Text[len(m.GetCmdString("bot")):])
-		m.BotCommand(cmd, msg)
-	} else if strings.HasPrefix(msg.Text, m.GetCmdString("admin")) && m.HasPerm("admin", msg) {
-		cmd := strings.TrimSpace(msg.Text[len(m.GetCmdString("admin")):])
-		m.AdminCommand(cmd, msg)
-	} else if strings.HasPrefix(msg.Text, m.GetCmdString("plugin")) && m.HasPerm("plugin", msg) {
-		cmd := strings.TrimSpace(msg.Text[len(m.GetCmdString("plugin")):])
-		m.PluginCommand(cmd, msg)
-	}
+	m.bot.RunCommand(m.Name, msg)
 }
 
 func (m *Admin) Presence(pres xmpp.Presence) {
-	if m.cfg.Setup.Debug {
+	if m.getConfig().Setup.Debug {
 		fmt.Printf("[%s] Presence:%#v\n", m.Name, pres)
 	}
+	m.updateJoinState(pres)
+	m.updateOccupants(pres)
 	//处理订阅消息
 	if pres.Type == "subscribe" {
-		if m.Option["auto-subscribe"].(bool) {
+		if m.Bool("auto-subscribe") {
 			m.bot.ApproveSubscription(pres.From)
 			m.bot.RequestSubscription(pres.From)
 		} else {
@@ -240,11 +451,15 @@ func (m *Admin) Presence(pres xmpp.Presence) {
 
 // AdminInterface
 func (m *Admin) GetRooms() []*Room {
+	m.roomsLock.RLock()
+	defer m.roomsLock.RUnlock()
 	return m.Rooms
 }
 
 func (m *Admin) IsAdminID(jid string) bool {
 	u, _ := utils.SplitJID(jid)
+	m.lock.RLock()
+	defer m.lock.RUnlock()
 	for _, admin := range m.admins {
 		if u == admin {
 			return true
@@ -255,6 +470,8 @@ func (m *Admin) IsAdminID(jid string) bool {
 
 func (m *Admin) IsSysAdminID(jid string) bool {
 	u, _ := utils.SplitJID(jid)
+	m.lock.RLock()
+	defer m.lock.RUnlock()
 	for _, admin := range m.cfg.Setup.Admin {
 		if u == admin {
 			return true
@@ -265,6 +482,8 @@ func (m *Admin) IsSysAdminID(jid string) bool {
 
 func (m *Admin) IsFriendID(jid string) bool {
 	u, _ := utils.SplitJID(jid)
+	m.lock.RLock()
+	defer m.lock.RUnlock()
 	for _, friend := range m.Friends {
 		if u == friend {
 			return true
@@ -279,7 +498,7 @@ func (m *Admin) LoginTime() time.Time {
 
 // jid 是已进入的聊天室吗？
 func (m *Admin) IsRoomID(jid string) bool {
-	for _, v := range m.Rooms {
+	for _, v := range m.GetRooms() {
 		if v.JID == jid {
 			return true
 		}
@@ -288,387 +507,255 @@ func (m *Admin) IsRoomID(jid string) bool {
 }
 
 func (m *Admin) IsCmd(text string) bool {
-	return strings.HasPrefix(strings.TrimSpace(text), m.Option["cmd_prefix"].(string))
+	return strings.HasPrefix(strings.TrimSpace(text), m.String("cmd_prefix"))
 }
 func (m *Admin) GetCmdString(cmd string) string {
-	return m.Option["cmd_prefix"].(string) + cmd
-}
-
-/* help 命令 */
-func (m *Admin) HelpCommand(cmd string, msg xmpp.Chat) {
-	if cmd == "" {
-		m.cmd_help_all(cmd, msg)
-	} else if len(cmd) > 0 {
-		m.cmd_help_plugin(cmd, msg)
-	} else {
-		m.bot.ReplyAuto(msg, "不支持的命令: "+cmd)
-	}
-}
-
-func (m *Admin) cmd_help_all(cmd string, msg xmpp.Chat) {
-	help_msg := []string{"==所有模块帮助=="}
-	for _, v := range m.bot.GetPlugins() {
-		help_msg = append(help_msg, "=="+v.GetName()+"模块==")
-		help_msg = append(help_msg, v.Help())
+	return m.String("cmd_prefix") + cmd
+}
+
+// 注册管理员模块的命令
+func (m *Admin) addCommands() {
+	rid := Arg{Name: "Rid"}
+	reason := Arg{Name: "Reason", Optional: true, Variadic: true}
+	mod := AllTalk | AdminPerm // 聊天室管理命令仅限管理员
+	for _, cmd := range []Command{
+		{Name: "help", Args: []Arg{{Name: "Plugin", Optional: true, Variadic: true}}, Help: "查看所有模块或指定模块的帮助", Handler: m.help},
+		{Name: "more", Help: "查看较长回复中未发送的部分", Handler: m.more},
+
+		{Name: "room send", Args: []Arg{rid, {Name: "Message", Variadic: true}}, Help: "让机器人在聊天室中发送消息", Audit: true, Handler: m.room_send},
+		{Name: "room nick", Args: []Arg{rid, {Name: "NickName"}}, Help: "修改机器人在聊天室的昵称", Audit: true, Handler: m.room_nick},
+		{Name: "room invite", Args: []Arg{{Name: "jid", Type: JIDArg}, rid, {Name: "Reason", Optional: true, Variadic: true}}, Help: "邀请好友进入聊天室", Audit: true, Handler: m.room_invite},
+		{Name: "room list-blocks", Args: []Arg{rid}, Help: "查看聊天室屏蔽列表", Handler: m.room_list_blocks},
+		{Name: "room block", Args: []Arg{rid, {Name: "Who"}}, Help: "屏蔽Who，对Who发送的消息不响应", Audit: true, Handler: m.room_block},
+		{Name: "room unblock", Args: []Arg{rid, {Name: "Who"}}, Help: "重新对Who发送的消息进行响应", Audit: true, Handler: m.room_unblock},
+		{Name: "room kick", Args: []Arg{rid, {Name: "Nick"}, reason}, Help: "将Nick踢出聊天室", Perm: mod, Audit: true, Handler: m.room_kick},
+		{Name: "room ban", Args: []Arg{rid, {Name: "Who"}, reason}, Help: "禁止Who(昵称或jid)进入聊天室", Perm: mod, Audit: true, Handler: m.room_ban},
+		{Name: "room unban", Args: []Arg{rid, {Name: "jid", Type: JIDArg}}, Help: "解除对jid的禁止", Perm: mod, Audit: true, Handler: m.room_unban},
+		{Name: "room voice", Args: []Arg{rid, {Name: "Nick"}, reason}, Help: "允许Nick在聊天室中发言", Perm: mod, Audit: true, Handler: m.room_voice},
+		{Name: "room devoice", Args: []Arg{rid, {Name: "Nick"}, reason}, Help: "禁止Nick在聊天室中发言", Perm: mod, Audit: true, Handler: m.room_devoice},
+		{Name: "room topic", Args: []Arg{rid, {Name: "Topic", Variadic: true}}, Help: "修改聊天室主题", Perm: mod, Audit: true, Handler: m.room_topic},
+		{Name: "room affiliation", Args: []Arg{rid, {Name: "Who"}, {Name: "Affiliation"}, reason},
+			Help: "设置Who(昵称或jid)的从属关系: owner, admin, member, none或outcast", Perm: mod, Audit: true, Handler: m.room_affiliation},
+		{Name: "room list", Help: "列出机器人当前所在的聊天室", Handler: m.room_list},
+		{Name: "room who", Args: []Arg{rid}, Help: "列出聊天室中的成员，管理员可以看到成员的真实jid", Handler: m.room_who},
+		{Name: "room join", Args: []Arg{{Name: "Rid", Type: JIDArg}, {Name: "Nick"}, {Name: "Password", Optional: true, Secret: true}}, Help: "加入聊天室", Audit: true, Handler: m.room_join},
+		{Name: "room leave", Args: []Arg{{Name: "Rid", Type: JIDArg}}, Help: "离开聊天室", Audit: true, Handler: m.room_leave},
+
+		{Name: "cron list", Help: "列出所有的计划任务详情", Handler: m.cron_list},
+		{Name: "cron add", Args: []Arg{{Name: "Spec"}, {Name: "jid", Type: JIDArg}, {Name: "Message", Variadic: true}},
+			Help:  "添加计划任务，Spec需用引号括起来，可以是\"Seconds Minutes Hours DayofMonth Month DayofWeek\"、@daily、@every 1h30m或一次性的\"at 2006-01-02 15:04\", 可以用\"TZ=Asia/Shanghai 0 30 9 * * *\"指定时区",
+			Audit: true, Handler: m.cron_add},
+		{Name: "cron add-cmd", Args: []Arg{{Name: "Spec"}, {Name: "jid", Type: JIDArg}, {Name: "Command", Variadic: true}},
+			Help:  "添加执行命令的计划任务，如\"--cron add-cmd '0 0 9 * * *' room@conference.example.com --about ip\", 命令以添加者的身份执行，结果发送到jid, 执行失败时通知添加者",
+			Audit: true, Handler: m.cron_add_cmd},
+		{Name: "cron del", Args: []Arg{{Name: "ID"}}, Help: "删除计划任务", Audit: true, Handler: m.cron_del},
+		{Name: "cron pause", Args: []Arg{{Name: "ID"}}, Help: "暂停计划任务", Audit: true, Handler: m.cron_pause},
+		{Name: "cron resume", Args: []Arg{{Name: "ID"}}, Help: "恢复暂停的计划任务", Audit: true, Handler: m.cron_resume},
+		{Name: "cron edit", Args: []Arg{{Name: "ID"}, {Name: "field"}, {Name: "value", Variadic: true}},
+			Help: "修改计划任务，field为spec, tz, to或text, tz为local时使用本地时区", Audit: true, Handler: m.cron_edit},
+		{Name: "cron run-now", Args: []Arg{{Name: "ID"}}, Help: "立即执行计划任务", Audit: true, Handler: m.cron_run_now},
+		{Name: "cron next", Args: []Arg{{Name: "ID|Spec"}, {Name: "n", Type: IntArg, Optional: true}}, Help: "预览任务或Spec接下来n次的执行时间", Handler: m.cron_next},
+
+		{Name: "bot restart", Help: "重新载入配置文件，初始化各模块", Audit: true, Handler: m.bot_restart},
+		{Name: "bot perm", Args: []Arg{{Name: "cmd"}, {Name: "value"}}, Help: "设置命令权限，value为chat,room,admin的组合，cmd可以是子命令，如room.send", Audit: true, Handler: m.bot_perm},
+		{Name: "bot status", Args: []Arg{{Name: "status"}, {Name: "message", Optional: true, Variadic: true}}, Help: "设置机器人在线状态", Audit: true, Handler: m.bot_status},
+		{Name: "bot send", Args: []Arg{{Name: "jid", Type: JIDArg}, {Name: "message", Variadic: true}}, Help: "给好友发送消息", Audit: true, Handler: m.bot_send},
+		{Name: "bot save-config", Args: []Arg{{Name: "confirm", Optional: true}}, Help: "预览/保存运行时修改到配置文件", Audit: true, Handler: m.bot_save_config},
+		{Name: "bot stats", Help: "查看各模块消息队列、处理统计及发送队列统计", Handler: m.bot_stats},
+		{Name: "bot friends", Help: "列出好友帐号", Handler: m.bot_friends},
+		{Name: "bot subscribe", Args: []Arg{{Name: "jid", Type: JIDArg}}, Help: "新增好友帐号", Audit: true, Handler: m.bot_subscribe},
+		{Name: "bot unsubscribe", Args: []Arg{{Name: "jid", Type: JIDArg}}, Help: "删除好友帐号", Audit: true, Handler: m.bot_unsubscribe},
+
+		{Name: "admin list", Help: "列出管理员帐号", Handler: m.admin_list},
+		{Name: "admin add", Args: []Arg{{Name: "jid", Type: JIDArg}}, Help: "新增管理员帐号", Audit: true, Handler: m.admin_add},
+		{Name: "admin del", Args: []Arg{{Name: "jid", Type: JIDArg}}, Help: "删除管理员帐号", Audit: true, Handler: m.admin_del},
+		{Name: "admin audit", Args: []Arg{{Name: "n", Type: IntArg, Optional: true}}, Help: "查看最近n条审计日志", Handler: m.admin_audit},
+		{Name: "admin role list", Help: "列出角色及命令权限设置", Handler: m.role_list},
+		{Name: "admin role set", Args: []Arg{{Name: "Subject"}, {Name: "Role"}, {Name: "Rid", Optional: true}},
+			Help: "授予角色，Subject为jid、@域名、affiliation:owner或role:moderator的形式，Role为owner,admin,operator,member,guest, 指定Rid时仅在该聊天室中有效", Audit: true, Handler: m.role_set},
+		{Name: "admin role del", Args: []Arg{{Name: "Subject"}, {Name: "Rid", Optional: true}}, Help: "删除授予的角色", Audit: true, Handler: m.role_del},
+		{Name: "admin role grant", Args: []Arg{{Name: "cmd"}, {Name: "Role"}, {Name: "Rid", Optional: true}},
+			Help: "设置命令所需的最低角色，cmd为顶级命令或子命令，如room.send", Audit: true, Handler: m.role_grant},
+		{Name: "admin role revoke", Args: []Arg{{Name: "cmd"}, {Name: "Rid", Optional: true}}, Help: "删除命令的角色设置", Audit: true, Handler: m.role_revoke},
+		{Name: "admin role show", Args: []Arg{{Name: "Who"}, {Name: "Rid", Optional: true}}, Help: "查看用户的角色，指定Rid时Who也可以是聊天室中的昵称", Handler: m.role_show},
+
+		{Name: "plugin all", Help: "列出所有的模块", Handler: m.plugin_all},
+		{Name: "plugin list", Help: "列出当前启用的模块", Handler: m.plugin_list},
+		{Name: "plugin disable", Args: []Arg{{Name: "Plugin"}}, Help: "禁用模块", Audit: true, Handler: m.plugin_disable},
+		{Name: "plugin enable", Args: []Arg{{Name: "Plugin"}}, Help: "启用模块", Audit: true, Handler: m.plugin_enable},
+		{Name: "plugin get", Args: []Arg{{Name: "Plugin", Optional: true}}, Help: "列出模块属性", Handler: m.plugin_get},
+		{Name: "plugin set", Args: []Arg{{Name: "Plugin.field"}, {Name: "value", Variadic: true}}, Help: "设置模块属性", Audit: true, Handler: m.plugin_set},
+	} {
+		m.bot.AddCommand(m.Name, cmd)
 	}
-	m.bot.ReplyAuto(msg, strings.Join(help_msg, "\n"))
 }
 
-func (m *Admin) cmd_help_plugin(cmd string, msg xmpp.Chat) {
+/* help 命令 */
+func (m *Admin) help(msg xmpp.Chat, args *Args) {
 	var helps []string
-	names := strings.Split(cmd, " ")
-	for _, name := range names {
-		if v := m.bot.GetPluginByName(name); v != nil {
-			helps = append(helps, "=="+v.GetName()+"帮助==")
-			helps = append(helps, v.Help())
+	if !args.Has("Plugin") {
+		helps = append(helps, "==所有模块帮助==")
+		for _, v := range m.bot.GetPlugins() {
+			helps = append(helps, "=="+v.GetName()+"模块==", v.Help())
+		}
+	} else {
+		for _, name := range args.List("Plugin") {
+			if v := m.bot.GetPluginByName(name); v != nil {
+				helps = append(helps, "=="+v.GetName()+"帮助==", v.Help())
+			}
 		}
 	}
 	m.bot.ReplyAuto(msg, strings.Join(helps, "\n"))
 }
 
-/* room 命令 */
-func (m *Admin) RoomCommand(cmd string, msg xmpp.Chat) {
-	if cmd == "" || cmd == "help" {
-		m.room_help(cmd, msg)
-	} else if strings.HasPrefix(cmd, "send ") {
-		m.room_send(cmd, msg)
-	} else if strings.HasPrefix(cmd, "nick ") {
-		m.room_nick(cmd, msg)
-	} else if strings.HasPrefix(cmd, "invite ") {
-		m.room_invite(cmd, msg)
-	} else if strings.HasPrefix(cmd, "list-blocks ") {
-		m.room_list_blocks(cmd, msg)
-	} else if strings.HasPrefix(cmd, "block ") {
-		m.room_block(cmd, msg)
-	} else if strings.HasPrefix(cmd, "unblock ") {
-		m.room_unblock(cmd, msg)
-	} else if cmd == "list" {
-		m.room_list(cmd, msg)
-	} else if strings.HasPrefix(cmd, "join ") {
-		m.room_join(cmd, msg)
-	} else if strings.HasPrefix(cmd, "leave ") {
-		m.room_leave(cmd, msg)
-	} else {
-		m.bot.ReplyAuto(msg, "不支持的命令: "+cmd)
+/* room 命令，Rid为all时表示所有的聊天室 */
+func (m *Admin) findRooms(msg xmpp.Chat, args *Args) []*Room {
+	rid := args.String("Rid")
+	if rid == "all" {
+		return m.GetRooms()
+	}
+	if room := m.getRoom(rid); room != nil {
+		return []*Room{room}
 	}
+	m.reply(msg, args, "Bot未进入此聊天室")
+	return nil
 }
 
-func (m *Admin) room_help(cmd string, msg xmpp.Chat) {
-
-	help_msg := []string{"==聊天室命令==",
-		m.GetCmdString("room") + " help                         显示本信息",
-		m.GetCmdString("room") + " send <Rid|all> <Message>     让机器人在聊天室中发送消息msg",
-		m.GetCmdString("room") + " nick <Rid|all> <NickName>    修改机器人在聊天室的昵称为NickName",
-		m.GetCmdString("room") + " invite <jid> <Rid> [Reason]  邀请某人进入聊天室", "",
-
-		m.GetCmdString("room") + " list-blocks <Rid|all>    查看聊天室屏蔽列表",
-		m.GetCmdString("room") + " block <Rid|all> <Who>    屏蔽who，对who发送的消息不响应",
-		m.GetCmdString("room") + " unblock <Rid|all> <Who>  重新对who发送的消息进行响应", "",
-
-		m.GetCmdString("room") + " list                          列出机器人当前所在的聊天室",
-		m.GetCmdString("room") + " join <Rid> <Nick> [Password]  加入聊天室",
-		m.GetCmdString("room") + " leave <Rid>                   离开聊天室", "",
-		"注: Rid 请使用聊天室jid, all表示所有的聊天室。",
-	}
-	m.bot.ReplyAuto(msg, strings.Join(help_msg, "\n"))
+// 回复命令执行结果，同时作为审计日志中的结果
+func (m *Admin) reply(msg xmpp.Chat, args *Args, text string) {
+	args.SetResult(text)
+	m.bot.ReplyAuto(msg, text)
 }
 
-func (m *Admin) room_send(cmd string, msg xmpp.Chat) {
-	//"send <jid|all> <msg>":       "让机器人在聊天室中发送消息msg",
-	tokens := strings.SplitN(cmd, " ", 3)
-	if len(tokens) != 3 {
-		return
-	}
-	if tokens[1] == "all" {
-		for _, v := range m.Rooms {
-			m.bot.SendPub(v.JID, tokens[2])
-		}
-	} else {
-		for _, v := range m.Rooms {
-			if v.JID == tokens[1] {
-				m.bot.SendPub(tokens[1], tokens[2])
-				return
-			}
-		}
+func (m *Admin) room_send(msg xmpp.Chat, args *Args) {
+	for _, v := range m.findRooms(msg, args) {
+		m.bot.SendPub(v.JID, args.String("Message"))
 	}
 }
 
 // 修改bot在聊天室中的昵称．
-func (m *Admin) room_nick(cmd string, msg xmpp.Chat) {
-	tokens := strings.SplitN(cmd, " ", 3)
-	if len(tokens) != 3 {
-		return
-	}
-	if tokens[1] == "all" {
-		for _, v := range m.Rooms {
-			m.bot.SetRoomNick(v, tokens[2])
-		}
-	} else {
-		for _, v := range m.Rooms {
-			if v.JID == tokens[1] {
-				m.bot.SetRoomNick(v, tokens[2])
-				return
-			}
-		}
-	}
+func (m *Admin) room_nick(msg xmpp.Chat, args *Args) {
+	nick := args.String("NickName")
+	m.moderate(msg, args, "修改昵称为"+nick, func(room *Room) error {
+		ch := make(chan error, 1)
+		m.ChangeNick(room, nick, func(err error) { ch <- err })
+		return <-ch
+	})
 }
 
-func (m *Admin) room_invite(cmd string, msg xmpp.Chat) {
-	//invite <jid> <roomid> [reason] 修改机器人在聊天室的昵称为NickName", "",
-	var jid, roomid, reason string
-	if tokens := strings.SplitN(cmd, " ", 4); len(tokens) == 4 {
-		jid = tokens[1]
-		roomid = tokens[2]
-		reason = tokens[3]
-	} else if tokens := strings.SplitN(cmd, " ", 3); len(tokens) == 3 {
-		jid = tokens[1]
-		roomid = tokens[2]
-	} else {
-		return
-	}
+func (m *Admin) room_invite(msg xmpp.Chat, args *Args) {
+	jid := args.String("jid")
 	if !m.IsFriendID(jid) {
+		m.reply(msg, args, jid+"不是好友，无法邀请")
 		return
 	}
-	m.bot.InviteToMUC(jid, roomid, reason)
+	m.bot.InviteToMUC(jid, args.String("Rid"), args.String("Reason"))
 }
 
-func (m *Admin) room_list_blocks(cmd string, msg xmpp.Chat) {
-	//"list-blocks <jid|all>":     "屏蔽who，对who发送的消息不响应",
-	tokens := strings.SplitN(cmd, " ", 2)
-	if len(tokens) != 2 {
-		return
-	}
+func (m *Admin) room_list_blocks(msg xmpp.Chat, args *Args) {
 	var blocks []string
-	if tokens[1] == "all" {
-		for _, v := range m.Rooms {
-			blocks = append(blocks, v.ListBlocks())
-		}
-	} else {
-		for _, v := range m.Rooms {
-			if v.JID == tokens[1] {
-				blocks = append(blocks, v.ListBlocks())
-			} else {
-				m.bot.ReplyAuto(msg, "Bot未进入此聊天室")
-			}
-		}
+	for _, v := range m.findRooms(msg, args) {
+		blocks = append(blocks, v.ListBlocks())
+	}
+	if len(blocks) > 0 {
+		m.bot.ReplyAuto(msg, strings.Join(blocks, "\n"))
 	}
-	m.bot.ReplyAuto(msg, strings.Join(blocks, "\n"))
 }
 
-func (m *Admin) room_block(cmd string, msg xmpp.Chat) {
-	//"block <jid|all> <who>":     "屏蔽who，对who发送的消息不响应",
-	tokens := strings.SplitN(cmd, " ", 3)
-	if len(tokens) != 3 {
-		return
-	}
-	if tokens[1] == "all" {
-		for _, v := range m.Rooms {
-			m.bot.SendPub(v.JID, "/me 忽略了 "+tokens[2]+" 的消息")
-			v.BlockOne(tokens[2])
-		}
-	} else {
-		for _, v := range m.Rooms {
-			if v.JID == tokens[1] {
-				m.bot.SendPub(v.JID, "/me 忽略了 "+tokens[2]+" 的消息")
-				v.BlockOne(tokens[2])
-			} else {
-				m.bot.ReplyAuto(msg, "Bot未进入此聊天室")
-			}
-		}
+func (m *Admin) room_block(msg xmpp.Chat, args *Args) {
+	who := args.String("Who")
+	for _, v := range m.findRooms(msg, args) {
+		m.bot.SendPub(v.JID, "/me 忽略了 "+who+" 的消息")
+		v.BlockOne(who)
 	}
+	m.SaveBlocks()
 }
 
-func (m *Admin) room_unblock(cmd string, msg xmpp.Chat) {
-	//"unblock <jid|all> <who>":   "重新对who发送的消息进行响应",
-	tokens := strings.SplitN(cmd, " ", 3)
-	if len(tokens) != 3 {
-		return
-	}
-	if tokens[1] == "all" {
-		for _, v := range m.Rooms {
-			m.bot.SendPub(v.JID, "/me 开始关注 "+tokens[2]+" 的消息")
-			v.UnBlockOne(tokens[2])
-		}
-	} else {
-		for _, v := range m.Rooms {
-			if v.JID == tokens[1] {
-				m.bot.SendPub(v.JID, "/me 开始关注 "+tokens[2]+" 的消息")
-				v.UnBlockOne(tokens[2])
-			} else {
-				m.bot.ReplyAuto(msg, "Bot未进入此聊天室")
-			}
-		}
+func (m *Admin) room_unblock(msg xmpp.Chat, args *Args) {
+	who := args.String("Who")
+	for _, v := range m.findRooms(msg, args) {
+		m.bot.SendPub(v.JID, "/me 开始关注 "+who+" 的消息")
+		v.UnBlockOne(who)
 	}
+	m.SaveBlocks()
 }
 
-func (m *Admin) room_list(cmd string, msg xmpp.Chat) {
-	//list
+func (m *Admin) room_list(msg xmpp.Chat, args *Args) {
 	var opt_list []string
-	for k, v := range m.Rooms {
-		opt_list = append(opt_list, fmt.Sprintf("%2d: %s as %s", k+1, v.JID, v.Nickname))
+	for k, v := range m.GetRooms() {
+		opt_list = append(opt_list, fmt.Sprintf("%2d: %s as %s, %s", k+1, v.JID, v.GetNick(), v.StateString()))
 	}
 	txt := "==聊天室列表==\n" + strings.Join(opt_list, "\n")
 	m.bot.ReplyAuto(msg, txt)
 }
 
-func (m *Admin) room_join(cmd string, msg xmpp.Chat) {
-	//join <jid> <nickname> [password]"
-	tokens := strings.SplitN(cmd, " ", 4)
-	if len(tokens) == 4 {
-		room := NewRoom(tokens[1], tokens[2], tokens[3])
-		m.bot.JoinProtectedMUC(room.JID, room.Nickname, room.Password)
-		m.Rooms = append(m.Rooms, room)
-		m.bot.ReplyAuto(msg, "已经进入聊天室"+room.JID)
-	} else if len(tokens) == 3 {
-		room := NewRoom(tokens[1], tokens[2], "")
-		m.bot.JoinMUC(room.JID, room.Nickname)
-		m.Rooms = append(m.Rooms, room)
-		m.bot.ReplyAuto(msg, "已经进入聊天室"+room.JID)
-	}
-}
-
-func (m *Admin) room_leave(cmd string, msg xmpp.Chat) {
-	//leave <jid>
-	tokens := strings.SplitN(cmd, " ", 2)
-	if len(tokens) == 2 && strings.Contains(tokens[1], "@") {
-		roomid := -1
-		for k, room := range m.Rooms {
-			if room.JID == tokens[1] {
-				m.bot.LeaveMUC(room.JID)
-				roomid = k
+func (m *Admin) room_who(msg xmpp.Chat, args *Args) {
+	jid, _, _ := m.sender(msg)
+	showJID := m.IsAdminID(jid)
+	var lines []string
+	for _, room := range m.findRooms(msg, args) {
+		list := room.Occupants()
+		lines = append(lines, fmt.Sprintf("==%s 的成员(%d)==", room.JID, len(list)))
+		for _, o := range list {
+			line := o.Nick
+			if showJID && o.JID != "" {
+				line += " <" + o.JID + ">"
 			}
-			fmt.Printf("[%s] Join to %s as %s\n", m.Name, room.JID, room.Nickname)
-		}
-		if roomid != -1 {
-			m.Rooms = append(m.Rooms[:roomid], m.Rooms[roomid+1:]...)
-			m.bot.ReplyAuto(msg, "已经退出群聊"+tokens[1])
+			if o.Affiliation != "" || o.Role != "" {
+				line += fmt.Sprintf(" [%s/%s]", o.Affiliation, o.Role)
+			}
+			if o.Show != "" {
+				line += " " + o.Show
+			}
+			if o.Status != "" {
+				line += ": " + o.Status
+			}
+			lines = append(lines, line+", 进入于 "+o.Joined.Format("01-02 15:04"))
 		}
-	} else {
-		m.bot.ReplyAuto(msg, "命令参数或聊天室id不正确")
-	}
-}
-
-/* cron 命令处理 */
-func (m *Admin) CronCommand(cmd string, msg xmpp.Chat) {
-	if cmd == "" || cmd == "help" {
-		m.cron_help(cmd, msg)
-	} else if cmd == "list" {
-		m.cron_list(cmd, msg)
-	} else if strings.HasPrefix(cmd, "add ") {
-		m.cron_add(cmd, msg)
-	} else if strings.HasPrefix(cmd, "del ") {
-		m.cron_del(cmd, msg)
-	} else {
-		m.bot.ReplyAuto(msg, "不支持的命令: "+cmd)
 	}
-}
-
-func (m *Admin) cron_help(cmd string, msg xmpp.Chat) {
-	help_msg := []string{"==计划任务命令==",
-		m.GetCmdString("cron") + " help                      显示本信息",
-		m.GetCmdString("cron") + " list                      列出所有的计划任务详情",
-		m.GetCmdString("cron") + " add <Spec> <jid> <msg>    添加计划任务",
-		"  Spec: Seconds Minutes Hours DayofMonth Month DayofWeek",
-		m.GetCmdString("cron") + " del <taskid>              删除计划任务",
+	if len(lines) > 0 {
+		m.bot.ReplyAuto(msg, strings.Join(lines, "\n"))
 	}
-	m.bot.ReplyAuto(msg, strings.Join(help_msg, "\n"))
 }
 
-func (m *Admin) cron_list(cmd string, msg xmpp.Chat) {
-	names := []string{"==所有计划任务列表=="}
-	for k, c := range m.crons {
-		names = append(names, fmt.Sprintf("TaskID: %s , [%s] => [%s] : %s", k, c.spec, c.to, c.text))
+func (m *Admin) room_join(msg xmpp.Chat, args *Args) {
+	if m.getRoom(args.String("Rid")) != nil {
+		m.reply(msg, args, "已经在聊天室"+args.String("Rid")+"中")
+		return
 	}
-	m.bot.ReplyAuto(msg, strings.Join(names, "\n"))
+	room := NewRoom(args.String("Rid"), args.String("Nick"), args.String("Password"))
+	m.loadBlocks(room)
+	m.joinRoom(room)
+	m.addRoom(room)
+	m.reply(msg, args, "正在进入聊天室"+room.JID+", 可通过"+m.GetCmdString("room list")+"查看是否成功")
 }
 
-func (m *Admin) cron_add(cmd string, msg xmpp.Chat) {
-	//add <spec6> <jid> <msg>
-	tokens := strings.SplitN(cmd, " ", 9)
-	if len(tokens) != 9 {
-		m.bot.ReplyAuto(msg, "添加新任务失败，请检查消息格式是否正确．")
-	}
-	cron := m.bot.GetCron()
-	to := tokens[7]
-	message := tokens[8]
-	spec := strings.Join(tokens[1:7], " ")
-	id := utils.GetMd5(cmd)
-	if m.IsRoomID(to) {
-		cron.AddFunc(spec, func() { m.bot.SendPub(to, message) }, id)
-		m.crons[id] = CronEntry{spec: spec, to: to, text: message}
+func (m *Admin) room_leave(msg xmpp.Chat, args *Args) {
+	if m.leaveRoom(args.String("Rid")) {
+		fmt.Printf("[%s] Leave from %s\n", m.Name, args.String("Rid"))
+		m.reply(msg, args, "已经退出群聊"+args.String("Rid"))
 	} else {
-		cron.AddFunc(spec, func() { m.bot.SendAuto(to, message) }, id)
-		m.crons[id] = CronEntry{spec: spec, to: to, text: message}
-	}
-}
-
-func (m *Admin) cron_del(cmd string, msg xmpp.Chat) {
-	tokens := strings.SplitN(cmd, " ", 2)
-	key := tokens[1]
-	cron := m.bot.GetCron()
-	for k, _ := range m.crons {
-		if key == k {
-			cron.RemoveJob(key)
-			delete(m.crons, key)
-			return
-		}
+		m.reply(msg, args, "Bot未进入此聊天室")
 	}
 }
 
 /* bot 命令 */
-func (m *Admin) BotCommand(cmd string, msg xmpp.Chat) {
-	if cmd == "" || cmd == "help" {
-		m.bot_help(cmd, msg)
-	} else if cmd == "restart" {
-		m.bot_restart(cmd, msg)
-	} else if strings.HasPrefix(cmd, "perm ") {
-		m.bot_perm(cmd, msg)
-	} else if strings.HasPrefix(cmd, "status ") {
-		m.bot_status(cmd, msg)
-	} else if strings.HasPrefix(cmd, "send ") {
-		m.bot_send(cmd, msg)
-	} else if cmd == "friends" {
-		m.bot_friends(cmd, msg)
-	} else if strings.HasPrefix(cmd, "subscribe ") {
-		m.bot_subscribe(cmd, msg)
-	} else if strings.HasPrefix(cmd, "unsubscribe ") {
-		m.bot_unsubscribe(cmd, msg)
+func (m *Admin) bot_restart(msg xmpp.Chat, args *Args) {
+	changes, err := m.bot.ReloadConfig()
+	if err != nil {
+		m.reply(msg, args, "重新载入配置文件失败:\n"+err.Error())
+	} else if len(changes) == 0 {
+		m.reply(msg, args, "配置文件没有变化。")
 	} else {
-		m.bot.ReplyAuto(msg, "不支持的命令: "+cmd)
+		m.reply(msg, args, "==已重新载入配置文件==\n"+strings.Join(changes, "\n"))
 	}
 }
 
-func (m *Admin) bot_help(cmd string, msg xmpp.Chat) {
-	help_msg := []string{"==管理员命令==",
-		m.GetCmdString("bot") + " help                      显示本信息",
-		m.GetCmdString("bot") + " restart                   重新载入配置文件，初始化各模块",
-		m.GetCmdString("bot") + " perm <cmd> <value>        设置命令权限",
-		m.GetCmdString("bot") + " status <status> [message] 设置机器人在线状态",
-		m.GetCmdString("bot") + " send <jid> [message]      给好友发送消息", "",
-
-		m.GetCmdString("bot") + " friends           列出好友帐号",
-		m.GetCmdString("bot") + " subscribe <jid>   新增好友帐号",
-		m.GetCmdString("bot") + " unsubscribe <jid> 删除好友帐号",
-	}
-	m.bot.ReplyAuto(msg, strings.Join(help_msg, "\n"))
-}
-
-func (m *Admin) bot_restart(cmd string, msg xmpp.Chat) {
-	m.Restart() //重启内置插件
-	m.bot.Restart()
-}
-
-//perm <cmd> <value>        设置命令权限",
-func (m *Admin) bot_perm(cmd string, msg xmpp.Chat) {
-	tokens := strings.SplitN(cmd, " ", 3)
-	if len(tokens) != 3 {
-		return
-	}
+func (m *Admin) bot_perm(msg xmpp.Chat, args *Args) {
 	var perm int
 	var err error
-	if perm, err = strconv.Atoi(tokens[2]); err != nil {
-		ps := strings.Split(tokens[2], ",")
-		for _, v := range ps {
+	if perm, err = strconv.Atoi(args.String("value")); err != nil {
+		for _, v := range strings.Split(args.String("value"), ",") {
 			switch strings.ToLower(v) {
 			case "chat":
 				perm |= ChatTalk
@@ -680,191 +767,196 @@ func (m *Admin) bot_perm(cmd string, msg xmpp.Chat) {
 		}
 	}
 	if !(perm >= 1 && perm <= 7) {
+		m.reply(msg, args, "权限设置错误，有效的权限为chat,room,admin的组合。")
 		return
 	}
-	m.SetPerm(tokens[1], perm)
-}
-
-func (m *Admin) bot_send(cmd string, msg xmpp.Chat) {
-	//"send <jid> <msg>":       "让机器人给好友发送消息msg",
-	tokens := strings.SplitN(cmd, " ", 3)
-	if len(tokens) != 3 {
-		return
-	}
-	if m.IsFriendID(tokens[1]) {
-		m.bot.SendAuto(tokens[1], tokens[2])
+	m.lock.Lock()
+	m.permset[args.String("cmd")] = perm
+	permset := map[string]int{}
+	for k, v := range m.permset {
+		permset[k] = v
 	}
+	m.lock.Unlock()
+	m.saveState("perms", permset)
+	m.reply(msg, args, args.String("cmd")+"的权限已设置为"+m.ShowPerm(args.String("cmd")))
 }
 
-func (m *Admin) bot_status(cmd string, msg xmpp.Chat) {
-	// cmd is "status chat 正在聊天中..."
-	var info = ""
-	tokens := strings.SplitN(cmd, " ", 3)
-	if len(tokens) == 3 {
-		info = tokens[2]
-	}
-	if utils.IsValidStatus(tokens[1]) {
-		m.bot.SetStatus(tokens[1], info)
+func (m *Admin) bot_send(msg xmpp.Chat, args *Args) {
+	if m.IsFriendID(args.String("jid")) {
+		m.bot.SendAuto(args.String("jid"), args.String("message"))
 	} else {
-		m.bot.ReplyAuto(msg, "设置状态失败，有效的状态为: away, chat, dnd, xa.")
+		m.reply(msg, args, args.String("jid")+"不是好友，无法发送消息")
 	}
 }
 
-func (m *Admin) bot_friends(cmd string, msg xmpp.Chat) {
-	txt := "==好友列表==\n" + strings.Join(m.Friends, "\n")
-	m.bot.ReplyAuto(msg, txt)
-}
-
-func (m *Admin) bot_subscribe(cmd string, msg xmpp.Chat) {
-	tokens := strings.SplitN(cmd, " ", 2)
-	if len(tokens) == 2 && strings.Contains(tokens[1], "@") {
-		if !m.IsFriendID(tokens[1]) {
-			m.bot.RequestSubscription(tokens[1])
-		} else {
-			m.bot.ReplyAuto(msg, tokens[1]+"已经是好友，不需要多次增加！")
-		}
+func (m *Admin) bot_status(msg xmpp.Chat, args *Args) {
+	if utils.IsValidStatus(args.String("status")) {
+		m.bot.SetStatus(args.String("status"), args.String("message"))
+	} else {
+		m.reply(msg, args, "设置状态失败，有效的状态为: away, chat, dnd, xa.")
 	}
 }
 
-func (m *Admin) bot_unsubscribe(cmd string, msg xmpp.Chat) {
-	tokens := strings.SplitN(cmd, " ", 2)
-	if len(tokens) == 2 && strings.Contains(tokens[1], "@") {
-		if !m.IsFriendID(tokens[1]) {
-			m.bot.ReplyAuto(msg, tokens[1]+"不是好友，不需要删除！")
-			return
-		}
-		jid, _ := utils.SplitJID(msg.Remote)
-		if tokens[1] == jid {
-			m.bot.ReplyAuto(msg, tokens[1]+"是你的id, 不支持这个操作！")
-			return
-		}
-
-		if m.IsSysAdminID(tokens[1]) {
-			m.bot.ReplyAuto(msg, "不允许删除超级管理员帐号 "+tokens[1]+"！")
-			return
-		}
-		m.Friends = utils.ListDelete(m.Friends, tokens[1])
-		m.bot.RevokeSubscription(tokens[1])
-		if m.IsAdminID(tokens[1]) {
-			m.admins = utils.ListDelete(m.admins, tokens[1])
-			m.bot.ReplyAuto(msg, "将管理员帐号 "+tokens[1]+" 从好友中删除！")
+func (m *Admin) bot_save_config(msg xmpp.Chat, args *Args) {
+	cfg, err := m.bot.RuntimeConfig()
+	if cfg.AppPath == "" {
+		m.reply(msg, args, "没有载入配置文件，无法保存。")
+		return
+	} else if err != nil {
+		m.reply(msg, args, "读取配置文件失败: "+err.Error())
+		return
+	}
+	if args.String("confirm") == "confirm" {
+		if err := config.Save(cfg); err != nil {
+			m.reply(msg, args, "保存配置文件失败: "+err.Error())
 		} else {
-			m.bot.ReplyAuto(msg, "将帐号 "+tokens[1]+" 从好友中删除！")
+			m.reply(msg, args, "已保存配置文件 "+cfg.AppPath)
 		}
+		return
 	}
-}
 
-/* admin 命令 */
-func (m *Admin) AdminCommand(cmd string, msg xmpp.Chat) {
-	if cmd == "" || cmd == "help" {
-		m.admin_help(cmd, msg)
-	} else if cmd == "list" {
-		m.admin_list(cmd, msg)
-	} else if strings.HasPrefix(cmd, "add ") {
-		m.admin_add(cmd, msg)
-	} else if strings.HasPrefix(cmd, "del ") {
-		m.admin_del(cmd, msg)
-	} else {
-		m.bot.ReplyAuto(msg, "不支持的命令: "+cmd)
+	// 与文件当前内容按相同格式输出后比较，避免注释、格式不同带来的差异
+	var old string
+	if orig, err := config.LoadFile(cfg.AppPath); err == nil {
+		old, _ = config.Render(orig)
+	}
+	text, err := config.Render(cfg)
+	if err != nil {
+		m.reply(msg, args, "生成配置失败: "+err.Error())
+		return
 	}
+	diff := utils.DiffLines(strings.Split(old, "\n"), strings.Split(text, "\n"))
+	if len(diff) == 0 {
+		m.reply(msg, args, "配置没有变化，不需要保存。")
+		return
+	}
+	txt := []string{"==将要写入 " + cfg.AppPath + " 的修改=="}
+	txt = append(txt, diff...)
+	txt = append(txt, "注意: 保存后配置文件中的注释将丢失。",
+		"确认保存请发送: "+m.GetCmdString("bot")+" save-config confirm")
+	m.reply(msg, args, strings.Join(txt, "\n"))
 }
 
-func (m *Admin) admin_help(cmd string, msg xmpp.Chat) {
-	help_msg := []string{"==管理员命令==",
-		m.GetCmdString("admin") + " list       列出管理员帐号",
-		m.GetCmdString("admin") + " add <jid>  新增管理员帐号",
-		m.GetCmdString("admin") + " del <jid>  删除管理员帐号", "",
+func (m *Admin) bot_stats(msg xmpp.Chat, args *Args) {
+	txt := []string{"==模块统计=="}
+	for _, v := range m.bot.GetPlugins() {
+		q := m.bot.GetQueueStats(v.GetName())
+		s := m.bot.GetPluginStats(v.GetName())
+		var avg time.Duration
+		if s.Calls > 0 {
+			avg = s.Total / time.Duration(s.Calls)
+		}
+		txt = append(txt, fmt.Sprintf("%-10s 队列: %d/%d 已处理: %d 丢弃: %d 出错: %d 平均耗时: %v 最长耗时: %v",
+			v.GetName(), q.Depth, q.Size, q.Processed, q.Dropped, s.Errors, avg, s.Max))
 	}
-	m.bot.ReplyAuto(msg, strings.Join(help_msg, "\n"))
+	o := m.bot.GetOutboxStats()
+	txt = append(txt, "==发送队列==", fmt.Sprintf("等待: %d 已发送: %d 丢弃: %d 延迟: %d 合并: %d 最长等待: %v",
+		o.Depth, o.Sent, o.Dropped, o.Delayed, o.Coalesced, o.MaxDelay))
+	m.bot.ReplyAuto(msg, strings.Join(txt, "\n"))
 }
 
-func (m *Admin) admin_list(cmd string, msg xmpp.Chat) {
-	txt := "==管理员列表==\n" + strings.Join(m.admins, "\n")
+func (m *Admin) bot_friends(msg xmpp.Chat, args *Args) {
+	m.lock.RLock()
+	txt := "==好友列表==\n" + strings.Join(m.Friends, "\n")
+	m.lock.RUnlock()
 	m.bot.ReplyAuto(msg, txt)
 }
 
-func (m *Admin) admin_add(cmd string, msg xmpp.Chat) {
-	tokens := strings.SplitN(cmd, " ", 2)
-	if len(tokens) == 2 && strings.Contains(tokens[1], "@") {
-		if m.IsAdminID(tokens[1]) {
-			m.bot.ReplyAuto(msg, tokens[1]+" 已是管理员用户，不需再次增加！")
-		} else {
-			if !m.IsFriendID(tokens[1]) {
-				m.bot.RequestSubscription(tokens[1])
-			}
-			m.admins = append(m.admins, tokens[1])
-			m.bot.ReplyAuto(msg, "您已添加 "+tokens[1]+"为管理员!")
-			jid, _ := utils.SplitJID(msg.Remote)
-			m.bot.SendAuto(tokens[1], jid+" 添加您为临时管理员!")
-		}
+func (m *Admin) bot_subscribe(msg xmpp.Chat, args *Args) {
+	jid := args.String("jid")
+	if !m.IsFriendID(jid) {
+		m.bot.RequestSubscription(jid)
+	} else {
+		m.reply(msg, args, jid+"已经是好友，不需要多次增加！")
 	}
 }
 
-func (m *Admin) admin_del(cmd string, msg xmpp.Chat) {
-	tokens := strings.SplitN(cmd, " ", 2)
+func (m *Admin) bot_unsubscribe(msg xmpp.Chat, args *Args) {
+	who := args.String("jid")
+	if !m.IsFriendID(who) {
+		m.reply(msg, args, who+"不是好友，不需要删除！")
+		return
+	}
 	jid, _ := utils.SplitJID(msg.Remote)
-	if m.IsAdminID(tokens[1]) && tokens[1] != jid {
-		m.admins = utils.ListDelete(m.admins, tokens[1])
-		m.bot.SendAuto(tokens[1], jid+" 临时取消了您的管理员身份!")
+	if who == jid {
+		m.reply(msg, args, who+"是你的id, 不支持这个操作！")
+		return
+	}
+
+	if m.IsSysAdminID(who) {
+		m.reply(msg, args, "不允许删除超级管理员帐号 "+who+"！")
+		return
+	}
+	m.lock.Lock()
+	m.Friends = utils.ListDelete(m.Friends, who)
+	m.lock.Unlock()
+	m.bot.RevokeSubscription(who)
+	if m.IsAdminID(who) {
+		m.setAdmins(utils.ListDelete(m.getAdmins(), who))
+		m.reply(msg, args, "将管理员帐号 "+who+" 从好友中删除！")
 	} else {
-		m.bot.ReplyAuto(msg, "不能取消 "+tokens[1]+" 的管理员身份!")
+		m.reply(msg, args, "将帐号 "+who+" 从好友中删除！")
 	}
 }
 
-/* plugin 命令 */
-func (m *Admin) PluginCommand(cmd string, msg xmpp.Chat) {
-	if !m.IsAdminID(msg.Remote) {
-		m.bot.ReplyAuto(msg, "请确认您是管理员，并且通过好友消息发送了此命令。")
+/* admin 命令 */
+func (m *Admin) admin_list(msg xmpp.Chat, args *Args) {
+	txt := "==管理员列表==\n" + strings.Join(m.getAdmins(), "\n")
+	m.bot.ReplyAuto(msg, txt)
+}
+
+func (m *Admin) admin_add(msg xmpp.Chat, args *Args) {
+	who := args.String("jid")
+	if m.IsAdminID(who) {
+		m.reply(msg, args, who+" 已是管理员用户，不需再次增加！")
 		return
 	}
-	if cmd == "" || cmd == "help" {
-		m.plugin_help(cmd, msg)
-	} else if cmd == "all" {
-		m.plugin_all(cmd, msg)
-	} else if cmd == "list" {
-		m.plugin_list(cmd, msg)
-	} else if strings.HasPrefix(cmd, "disable ") {
-		m.plugin_disable(cmd, msg)
-	} else if strings.HasPrefix(cmd, "enable ") {
-		m.plugin_enable(cmd, msg)
-	} else if strings.HasPrefix(cmd, "get") {
-		m.plugin_get(cmd, msg)
-	} else if strings.HasPrefix(cmd, "set ") {
-		m.plugin_set(cmd, msg)
-	} else {
-		m.bot.ReplyAuto(msg, "不支持的命令: "+cmd)
+	if !m.IsFriendID(who) {
+		m.bot.RequestSubscription(who)
 	}
+	m.setAdmins(append(m.getAdmins(), who))
+	m.reply(msg, args, "您已添加 "+who+"为管理员!")
+	jid, _ := utils.SplitJID(msg.Remote)
+	m.bot.SendAuto(who, jid+" 添加您为临时管理员!")
 }
 
-func (m *Admin) plugin_help(cmd string, msg xmpp.Chat) {
-	help_msg := []string{"==插件命令==",
-		m.GetCmdString("plugin") + " help                   显示本信息",
-		m.GetCmdString("plugin") + " all                    列出所有的模块",
-		m.GetCmdString("plugin") + " list                   列出当前启用的模块",
-		m.GetCmdString("plugin") + " disable <Plugin>       禁用模块",
-		m.GetCmdString("plugin") + " enable <Plugin>        启用模块",
-		m.GetCmdString("plugin") + " get [Plugin]           列出模块属性",
-		m.GetCmdString("plugin") + " set <field> <value>    设置模块属性",
+func (m *Admin) admin_del(msg xmpp.Chat, args *Args) {
+	who := args.String("jid")
+	jid, _ := utils.SplitJID(msg.Remote)
+	if m.IsAdminID(who) && !m.IsSysAdminID(who) && who != jid {
+		m.setAdmins(utils.ListDelete(m.getAdmins(), who))
+		m.bot.SendAuto(who, jid+" 临时取消了您的管理员身份!")
+	} else {
+		m.reply(msg, args, "不能取消 "+who+" 的管理员身份!")
 	}
-	m.bot.ReplyAuto(msg, strings.Join(help_msg, "\n"))
 }
 
-func (m *Admin) plugin_all(cmd string, msg xmpp.Chat) {
+/* plugin 命令 */
+func (m *Admin) plugin_all(msg xmpp.Chat, args *Args) {
 	names := []string{"==所有插件列表=="}
 
 	names = append(names, m.Name+"[内置]")
 
-	for name, v := range m.cfg.Plugin {
-		if v["enable"].(bool) {
-			names = append(names, name+"[启用]")
+	cfg := m.bot.GetConfig()
+	for _, name := range RegisteredPlugins() {
+		meta, _ := GetPluginMeta(name)
+		if v, ok := cfg.Plugin[name]; !ok {
+			names = append(names, name+"[未配置] "+meta.Summary)
+		} else if v["enable"] == true {
+			names = append(names, name+"[启用] "+meta.Summary)
 		} else {
-			names = append(names, name+"[禁用]")
+			names = append(names, name+"[禁用] "+meta.Summary)
+		}
+	}
+	for name := range cfg.Plugin {
+		if _, ok := GetPluginMeta(name); !ok {
+			names = append(names, name+"[未编译]")
 		}
 	}
 	m.bot.ReplyAuto(msg, strings.Join(names, "\n"))
 }
 
-func (m *Admin) plugin_list(cmd string, msg xmpp.Chat) {
+func (m *Admin) plugin_list(msg xmpp.Chat, args *Args) {
 	names := []string{"==运行中插件列表=="}
 
 	for _, v := range m.bot.GetPlugins() {
@@ -873,30 +965,36 @@ func (m *Admin) plugin_list(cmd string, msg xmpp.Chat) {
 	m.bot.ReplyAuto(msg, strings.Join(names, "\n"))
 }
 
-func (m *Admin) plugin_disable(cmd string, msg xmpp.Chat) {
-	tokens := strings.SplitN(cmd, " ", 2)
-	if tokens[1] == m.Name {
-		m.bot.ReplyAuto(msg, m.Name+"是内置模块，不允许禁用")
+func (m *Admin) plugin_disable(msg xmpp.Chat, args *Args) {
+	name := args.String("Plugin")
+	if name == m.Name {
+		m.reply(msg, args, m.Name+"是内置模块，不允许禁用")
 	} else {
-		m.bot.RemovePlugin(tokens[1])
+		m.bot.DisablePlugin(name)
+		m.reply(msg, args, "已禁用模块"+name)
 	}
 }
 
-func (m *Admin) plugin_enable(cmd string, msg xmpp.Chat) {
-	tokens := strings.SplitN(cmd, " ", 2)
-	m.bot.AddPlugin(tokens[1])
+func (m *Admin) plugin_enable(msg xmpp.Chat, args *Args) {
+	if err := m.bot.EnablePlugin(args.String("Plugin")); err != nil {
+		m.reply(msg, args, "启用模块失败: "+err.Error())
+	} else {
+		m.reply(msg, args, "已启用模块"+args.String("Plugin"))
+	}
 }
 
-func (m *Admin) plugin_get(cmd string, msg xmpp.Chat) {
-	tokens := strings.SplitN(cmd, " ", 2)
+func (m *Admin) plugin_get(msg xmpp.Chat, args *Args) {
 	options := map[string]string{}
 	var opt_list []string
-	if len(tokens) == 2 {
-		if mod := m.bot.GetPluginByName(tokens[1]); mod != nil {
-			opt_list = append(opt_list, "=="+mod.GetName()+"模块属性==")
-			for k, v := range mod.GetOptions() {
-				options[mod.GetName()+"."+k] = v
-			}
+	if args.Has("Plugin") {
+		mod := m.bot.GetPluginByName(args.String("Plugin"))
+		if mod == nil {
+			m.bot.ReplyAuto(msg, "模块"+args.String("Plugin")+"没有运行")
+			return
+		}
+		opt_list = append(opt_list, "=="+mod.GetName()+"模块属性==")
+		for k, v := range mod.GetOptions() {
+			options[mod.GetName()+"."+k] = v
 		}
 	} else {
 		opt_list = append(opt_list, "==所有模块属性==")
@@ -914,14 +1012,20 @@ func (m *Admin) plugin_get(cmd string, msg xmpp.Chat) {
 	m.bot.ReplyAuto(msg, strings.Join(opt_list, "\n"))
 }
 
-func (m *Admin) plugin_set(cmd string, msg xmpp.Chat) {
-	tokens := strings.SplitN(cmd, " ", 3)
-	if len(tokens) == 3 {
-		modkey := strings.SplitN(tokens[1], ".", 2)
-		for _, mod := range m.bot.GetPlugins() {
-			if modkey[0] == mod.GetName() {
-				mod.SetOption(modkey[1], tokens[2])
-			}
-		}
+func (m *Admin) plugin_set(msg xmpp.Chat, args *Args) {
+	modkey := strings.SplitN(args.String("Plugin.field"), ".", 2)
+	if len(modkey) != 2 {
+		m.reply(msg, args, "属性名称应为 模块名.属性名")
+		return
+	}
+	mod := m.bot.GetPluginByName(modkey[0])
+	if mod == nil {
+		m.reply(msg, args, "模块"+modkey[0]+"没有运行")
+		return
+	}
+	if err := m.bot.SetPluginOption(mod, modkey[1], args.String("value")); err != nil {
+		m.reply(msg, args, "设置"+args.String("Plugin.field")+"失败: "+err.Error())
+		return
 	}
+	m.reply(msg, args, "已设置"+args.String("Plugin.field")+"为"+args.String("value"))
 }
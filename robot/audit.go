@@ -0,0 +1,131 @@
+package robot
+
+import (
+	"fmt"
+	"github.com/mattn/go-xmpp"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --admin audit 默认显示的条数
+const DefaultAuditCount = 20
+
+// 一次最多读取的审计日志条数
+const MaxAuditCount = 500
+
+// AuditEvent 记录一次特权命令的执行。
+type AuditEvent struct {
+	Id      int64
+	Actor   string // 执行者jid, 聊天室中为"真实jid (聊天室/昵称)"或"聊天室/昵称"
+	Command string // 如"room.leave"
+	Args    string
+	Result  string
+	Created time.Time `xorm:"created index"`
+}
+
+// AuditLog 保存审计事件，由Bot使用，模块不能直接写入。MemoryStore和DBStore都实现了AuditLog.
+type AuditLog interface {
+	// AddAudit 保存审计事件，Audits返回最近的n条，按时间倒序。
+	AddAudit(e AuditEvent) error
+	Audits(n int) ([]AuditEvent, error)
+}
+
+// 状态存储实现了AuditLog时审计事件保存在同一个数据库中，否则仅保存在内存中
+func auditLogOf(store Store) AuditLog {
+	if l, ok := store.(AuditLog); ok {
+		return l
+	}
+	return NewMemoryStore()
+}
+
+const auditTmpl = `<html><head><meta charset="utf-8"><title>Audit</title></head><body>
+<table border="1" cellspacing="0" cellpadding="4">
+<tr><th>Time</th><th>Actor</th><th>Command</th><th>Args</th><th>Result</th></tr>
+{{range .}}<tr><td>{{.Created.Format "2006-01-02 15:04:05"}}</td><td>{{.Actor}}</td><td>{{.Command}}</td><td>{{.Args}}</td><td>{{.Result}}</td></tr>
+{{end}}</table></body></html>
+`
+
+// 记录审计事件，保存失败时仅输出日志
+func (b *Bot) audit(msg xmpp.Chat, cmd Command, args, result string) {
+	e := AuditEvent{
+		Actor:   b.admin.Actor(msg),
+		Command: cmd.permName(),
+		Args:    args,
+		Result:  result,
+		Created: time.Now(),
+	}
+	if err := b.audits.AddAudit(e); err != nil {
+		fmt.Printf("[audit] %s %s %s: %s, save error: %v\n", e.Actor, e.Command, e.Args, e.Result, err)
+	}
+}
+
+// GetAudits 返回最近的n条审计事件，按时间倒序，n最大为MaxAuditCount.
+func (b *Bot) GetAudits(n int) ([]AuditEvent, error) {
+	if n < 1 {
+		return []AuditEvent{}, nil
+	}
+	if n > MaxAuditCount {
+		n = MaxAuditCount
+	}
+	return b.audits.Audits(n)
+}
+
+// Actor 返回审计日志中的执行者
+func (m *Admin) Actor(msg xmpp.Chat) string {
+	jid, room, _ := m.sender(msg)
+	if room != "" && jid != "" {
+		return jid + " (" + msg.Remote + ")"
+	}
+	return msg.Remote
+}
+
+/* admin audit 命令 */
+func (m *Admin) admin_audit(msg xmpp.Chat, args *Args) {
+	n := DefaultAuditCount
+	if args.Has("n") {
+		n = args.Int("n")
+	}
+	if n < 1 || n > MaxAuditCount {
+		m.bot.ReplyAuto(msg, fmt.Sprintf("n应为1到%d之间的整数\n用法: %sadmin audit [n]", MaxAuditCount, m.bot.GetCmdString("")))
+		return
+	}
+	events, err := m.bot.GetAudits(n)
+	if err != nil {
+		m.bot.ReplyAuto(msg, "读取审计日志失败: "+err.Error())
+		return
+	}
+	txt := []string{"==最近" + strconv.Itoa(len(events)) + "条审计日志=="}
+	for _, e := range events {
+		txt = append(txt, fmt.Sprintf("%s %s %s %s => %s",
+			e.Created.Format("2006-01-02 15:04:05"), e.Actor, e.Command, e.Args, e.Result))
+	}
+	m.bot.ReplyAuto(msg, strings.Join(txt, "\n"))
+}
+
+// 审计日志页面，需要配置setup.web_user及setup.web_password, 通过http认证访问。
+func (m *Admin) AuditPage(w http.ResponseWriter, r *http.Request) {
+	cfg := m.bot.GetConfig()
+	if cfg.Setup.WebUser == "" || cfg.Setup.WebPassword == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if user, pass, ok := r.BasicAuth(); !ok || user != cfg.Setup.WebUser || pass != cfg.Setup.WebPassword {
+		w.Header().Set("WWW-Authenticate", `Basic realm="xmppbot"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	n := 100
+	if v, err := strconv.Atoi(r.FormValue("n")); err == nil && v > 0 && v <= MaxAuditCount {
+		n = v
+	}
+	events, err := m.bot.GetAudits(n)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	t, _ := template.New("audit").Parse(auditTmpl)
+	t.Execute(w, events)
+}
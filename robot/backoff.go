@@ -0,0 +1,61 @@
+package robot
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff 计算断线重连的等待时间，每次失败后等待时间按Factor增长，最长不超过Max，
+// 并加入Jitter比例的随机抖动，避免多个客户端同时重连。
+type Backoff struct {
+	Min     time.Duration
+	Max     time.Duration
+	Factor  float64
+	Jitter  float64
+	attempt int
+}
+
+func NewBackoff(min, max time.Duration, factor, jitter float64) *Backoff {
+	if min <= 0 {
+		min = 5 * time.Second
+	}
+	if max < min {
+		max = 5 * time.Minute
+		if max < min {
+			max = min
+		}
+	}
+	if factor < 1 {
+		factor = 2
+	}
+	if jitter < 0 || jitter > 1 {
+		jitter = 0.2
+	}
+	return &Backoff{Min: min, Max: max, Factor: factor, Jitter: jitter}
+}
+
+// Next 返回下一次重连前需要等待的时间。
+func (b *Backoff) Next() time.Duration {
+	d := float64(b.Min)
+	for i := 0; i < b.attempt && d < float64(b.Max); i++ {
+		d *= b.Factor
+	}
+	if d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	b.attempt++
+	if b.Jitter > 0 {
+		d += d * b.Jitter * (rand.Float64()*2 - 1)
+	}
+	return time.Duration(d)
+}
+
+// Attempt 返回连续失败的次数。
+func (b *Backoff) Attempt() int {
+	return b.attempt
+}
+
+// Reset 在连接成功后调用，重新从Min开始计算。
+func (b *Backoff) Reset() {
+	b.attempt = 0
+}
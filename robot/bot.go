@@ -2,76 +2,125 @@ package robot
 
 import (
 	"fmt"
+	"github.com/fsnotify/fsnotify"
 	"github.com/jakecoffman/cron"
 	"github.com/mattn/go-xmpp"
 	"github.com/yetist/xmppbot/config"
 	"github.com/yetist/xmppbot/utils"
-	"golang.org/x/net/html"
+	"html"
 	"log"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 )
 
 type Bot struct {
-	client       *xmpp.Client
-	cron         *cron.Cron
-	web          *WebServer
-	plugins      []PluginIface
-	admin        AdminIface
-	cfg          config.Config
-	createPlugin NewFunc
-}
-
-func NewBot(client *xmpp.Client, cfg config.Config, f NewFunc) *Bot {
+	lock       sync.RWMutex
+	reloadLock sync.Mutex
+	busy       sync.RWMutex // 分发消息时持有读锁，Shutdown通过写锁等待分发完毕
+	stopping   bool
+	statsLock  sync.Mutex
+	stats      map[string]*PluginStats
+	client     Transport
+	cron       *cron.Cron
+	web        *WebServer
+	watcher    *fsnotify.Watcher
+	plugins    []PluginIface
+	queues     map[string]*pluginQueue
+	commands   map[string][]Command // 各模块注册的命令
+	iqLock     sync.Mutex
+	iqSeq      int
+	iqWaiters  map[string]*iqWaiter // 等待回应的iq请求
+	subjects   map[string]chan error // 等待修改主题的回应的聊天室，由iqLock保护
+	out        *outbox
+	execLock   sync.Mutex
+	captures   map[string][]string // ExecCommand执行中的命令的回复
+	pagerLock  sync.Mutex
+	pages      map[string]*pages // 等待通过--more查看的回复
+	admin      AdminIface
+	store      Store
+	audits     AuditLog
+	cfg        config.Config
+	loaded     config.Config // 最近一次载入的配置文件内容，重新载入时用于比较
+}
+
+func NewBot(client Transport, cfg config.Config) *Bot {
 	b := &Bot{
-		client:       client,
-		cron:         cron.New(),
-		cfg:          cfg,
-		web:          NewWebServer(cfg.Setup.WebHost, cfg.Setup.WebPort),
-		createPlugin: f,
+		client: client,
+		cron:   cron.New(),
+		cfg:    cfg,
+		loaded: copyConfig(cfg),
+		web:    NewWebServer(cfg.Setup.WebHost, cfg.Setup.WebPort),
 	}
-
-	// 自动启用内置插件
-	admin := NewAdmin("admin")
-	b.admin = admin
-	b.plugins = append(b.plugins, admin)
-
-	for name, v := range cfg.Plugin {
-		if v["enable"].(bool) { //模块是否被启用
-			plugin := f(name, v)
-			if plugin != nil && plugin.CheckEnv() { //模块运行环境是否满足
-				b.plugins = append(b.plugins, plugin)
-			}
-		}
+	b.out = newOutbox(b)
+	store, err := OpenStore(cfg.Setup.StateDB)
+	if err != nil {
+		log.Printf("open state db %s error: %v, state will not be saved", cfg.Setup.StateDB, err)
+		store = NewMemoryStore()
 	}
+	b.store = store
+	b.audits = auditLogOf(store)
+	b.Init()
 	return b
 }
 
 func (b *Bot) GetConfig() config.Config {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
 	return b.cfg
 }
 
+// 返回当前运行的模块列表副本
 func (b *Bot) GetPlugins() []PluginIface {
-	return b.plugins
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	return append([]PluginIface{}, b.plugins...)
+}
+
+// 运行时状态存储
+func (b *Bot) GetStore() Store {
+	return b.store
+}
+
+// 替换状态存储，需要在Start之前调用。
+func (b *Bot) SetStore(store Store) {
+	b.store = store
+	b.audits = auditLogOf(store)
 }
 
 func (b *Bot) GetPluginOption(name string) map[string]interface{} {
-	return b.cfg.Plugin[name]
+	return b.GetConfig().Plugin[name]
 }
 
-type NewFunc func(name string, opt map[string]interface{}) PluginIface
+// 替换模块name的配置，opt为nil时删除，需持有b.lock.
+// 模块配置以写时复制的方式修改，GetConfig返回的配置可在其它goroutine中安全读取
+func (b *Bot) setPluginConfig(name string, opt map[string]interface{}) {
+	plugins := map[string]map[string]interface{}{}
+	for k, v := range b.cfg.Plugin {
+		plugins[k] = v
+	}
+	if opt == nil {
+		delete(plugins, name)
+	} else {
+		plugins[name] = opt
+	}
+	b.cfg.Plugin = plugins
+}
 
 // Interface(), 初始化并加载所有模块
-func (b *Bot) Init(f NewFunc) {
+func (b *Bot) Init() {
 	// 自动启用内置插件
 	admin := NewAdmin("admin")
 	b.admin = admin
 	b.plugins = append(b.plugins, admin)
 
 	for name, v := range b.cfg.Plugin {
-		if v["enable"].(bool) { //模块是否被启用
-			plugin := f(name, v)
-			if plugin != nil && plugin.CheckEnv() { //模块运行环境是否满足
+		if v["enable"] == true { //模块是否被启用
+			plugin, err := CreatePlugin(name, v)
+			if err != nil {
+				log.Printf("%v, ignore it", err)
+			} else if plugin.CheckEnv() { //模块运行环境是否满足
 				b.plugins = append(b.plugins, plugin)
 			}
 		}
@@ -80,80 +129,185 @@ func (b *Bot) Init(f NewFunc) {
 
 func (b *Bot) Start() {
 	for _, v := range b.plugins {
-		v.Start(b)
+		b.startPlugin(v)
 	}
 
 	// 每分钟运行ping
-	b.cron.AddFunc("0 0/1 * * * ?", func() { b.client.PingC2S(b.cfg.Account.Username, b.cfg.Account.Server) }, "xmpp ping")
+	b.cron.AddFunc("0 0/1 * * * ?", func() { b.conn().PingC2S(b.cfg.Account.Username, b.cfg.Account.Server) }, "xmpp ping")
 	b.cron.Start()
+	go b.web.Start()
+	if b.cfg.Setup.WatchConfig {
+		b.watchConfig()
+	}
 }
 
-func (b *Bot) Run(quit chan<- bool) {
-	go func() {
-		for {
-			chat, err := b.client.Recv()
-			if err != nil {
-				log.Print("bot get error:", err)
-				quit <- true
-			}
-			switch v := chat.(type) {
-			case xmpp.Chat:
-				b.Chat(v)
-			case xmpp.Presence:
-				b.Presence(v)
-			}
+// 当前使用的连接
+func (b *Bot) conn() Transport {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	return b.client
+}
+
+// 接收消息并放入各模块的队列，直到连接出错。
+func (b *Bot) Run() error {
+	client := b.conn()
+	for {
+		chat, err := client.Recv()
+		if err != nil {
+			return err
 		}
-	}()
-	b.web.Start()
+		b.busy.RLock()
+		if msg, ok := chat.(xmpp.Chat); ok {
+			b.handleSubject(msg)
+		}
+		if iq, ok := chat.(xmpp.IQ); ok {
+			b.handleIQ(iq)
+		} else if !b.isStopping() {
+			b.dispatch(chat)
+		}
+		b.busy.RUnlock()
+	}
 }
 
-// Interface(), 模块收到消息时的处理
+// 断线重连后，替换连接并恢复会话状态: 重新发送在线状态、请求好友列表、进入聊天室。
+func (b *Bot) Reconnect(client Transport) {
+	b.lock.Lock()
+	b.client = client
+	b.lock.Unlock()
+
+	b.SetStatus(b.cfg.Setup.Status, b.cfg.Setup.StatusMessage)
+	b.Roster()
+	b.admin.JoinRooms()
+}
+
+// Interface(), 模块收到消息时的处理，不经过队列直接调用各模块
 func (b *Bot) Chat(chat xmpp.Chat) {
-	for _, v := range b.plugins {
-		v.Chat(chat)
+	for _, v := range b.GetPlugins() {
+		b.callPlugin(v, func() { v.Chat(chat) })
 	}
 }
 
-// Interface(), 模块收到Presence消息时的处理
+// Interface(), 模块收到Presence消息时的处理，不经过队列直接调用各模块
 func (b *Bot) Presence(presence xmpp.Presence) {
-	for _, v := range b.plugins {
-		v.Presence(presence)
+	for _, v := range b.GetPlugins() {
+		b.callPlugin(v, func() { v.Presence(presence) })
 	}
 }
 
 // Interface(), 模块卸载时的处理函数
 func (b *Bot) Stop() {
-	for _, v := range b.plugins {
-		v.Stop()
+	if err := b.Shutdown(b.ShutdownTimeout()); err != nil {
+		log.Print(err)
+	}
+}
+
+// 按setup.shutdown_timeout返回等待各模块退出的最长时间
+func (b *Bot) ShutdownTimeout() time.Duration {
+	if t := b.GetConfig().Setup.ShutdownTimeout; t > 0 {
+		return time.Duration(t) * time.Second
 	}
-	b.client.Close()
-	b.cron.Stop()
-	b.web.Stop()
+	return DefaultShutdownTimeout
 }
 
-// Interface(), 重新载入并初始化各模块
+// Interface(), 重新载入配置文件并更新各模块
 func (b *Bot) Restart() {
-	var disable_plugins []string
-
-	// 对正在运行中的插件，调用Restart接口重启
-	for name, _ := range b.cfg.Plugin {
-		for _, v := range b.plugins {
-			if name == v.GetName() {
-				v.Restart()
-				continue
+	b.reloadAndLog("restart")
+}
+
+// 启动模块，并恢复通过聊天命令设置过的模块属性
+func (b *Bot) startPlugin(plugin PluginIface) {
+	plugin.Start(b)
+	b.loadPluginOptions(plugin)
+	b.startQueue(plugin)
+}
+
+// 恢复通过聊天命令设置过的模块属性
+func (b *Bot) loadPluginOptions(plugin PluginIface) {
+	keys, err := b.store.Keys("options")
+	if err != nil {
+		return
+	}
+	prefix := plugin.GetName() + "."
+	for _, k := range keys {
+		var val string
+		if strings.HasPrefix(k, prefix) {
+			if ok, _ := b.store.Get("options", k, &val); ok {
+				if err := plugin.SetOption(k[len(prefix):], val); err != nil {
+					log.Printf("load option %s error: %v", k, err)
+				}
 			}
 		}
-		disable_plugins = append(disable_plugins, name)
 	}
-	// 对禁用的插件，重新启用
-	for _, n := range disable_plugins {
-		b.AddPlugin(n) //FIXME:
+}
+
+// 设置模块属性，并保存到状态数据库中，属性不存在或值无效时返回错误
+func (b *Bot) SetPluginOption(plugin PluginIface, key, val string) error {
+	if err := plugin.SetOption(key, val); err != nil {
+		return err
+	}
+	if err := b.store.Put("options", plugin.GetName()+"."+key, val); err != nil {
+		log.Printf("save option %s.%s error: %v", plugin.GetName(), key, err)
+	}
+
+	// 同步到配置中，以便通过save-config保存
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if _, ok := plugin.(*Admin); ok {
+		switch key {
+		case "cmd_prefix":
+			b.cfg.Setup.CmdPrefix = val
+		case "auto-subscribe":
+			b.cfg.Setup.AutoSubscribe = utils.StringToBool(val)
+		}
+	} else if opt, ok := b.cfg.Plugin[plugin.GetName()]; ok {
+		opt = copyOptions(opt)
+		opt[key] = config.ParseValue(opt[key], val)
+		b.setPluginConfig(plugin.GetName(), opt)
+	}
+	return nil
+}
+
+// 返回配置文件的内容加上运行时的修改，如通过命令进入的聊天室、启用的模块、修改的模块属性及命令前缀。
+// 命令行参数(如-password, -debug)对配置的覆盖不包含在内。
+func (b *Bot) RuntimeConfig() (config.Config, error) {
+	running := b.GetConfig()
+	cfg := running
+	if running.AppPath != "" {
+		file, err := config.LoadFile(running.AppPath)
+		if err != nil {
+			return cfg, err
+		}
+		cfg = file
+	}
+	cfg.Setup.CmdPrefix = running.Setup.CmdPrefix
+	cfg.Setup.AutoSubscribe = running.Setup.AutoSubscribe
+	cfg.Plugin = running.Plugin
+	rooms := []map[string]interface{}{}
+	for _, r := range b.admin.GetRooms() {
+		room := map[string]interface{}{}
+		for _, v := range cfg.Setup.Rooms {
+			if v["jid"] == r.JID {
+				for k, i := range v {
+					room[k] = i
+				}
+			}
+		}
+		room["jid"] = r.JID
+		room["nickname"] = r.GetNick()
+		if password := r.GetPassword(); len(password) > 0 {
+			room["password"] = password
+		} else {
+			delete(room, "password")
+		}
+		rooms = append(rooms, room)
 	}
+	cfg.Setup.Rooms = rooms
+	return cfg, nil
 }
 
 //获取模块
 func (b *Bot) GetPluginByName(name string) PluginIface {
-	for _, v := range b.plugins {
+	for _, v := range b.GetPlugins() {
 		if name == v.GetName() {
 			return v
 		}
@@ -163,67 +317,103 @@ func (b *Bot) GetPluginByName(name string) PluginIface {
 
 // 按名称卸载某个模块
 func (b *Bot) RemovePlugin(name string) {
-	id := -1
+	var plugin PluginIface
+	b.lock.Lock()
 	for k, v := range b.plugins {
 		if name == v.GetName() {
-			v.Stop()
-			id = k
+			plugin = v
+			b.plugins = append(b.plugins[:k], b.plugins[k+1:]...)
+			break
 		}
 	}
-	if id > -1 {
-		b.plugins = append(b.plugins[:id], b.plugins[id+1:]...)
+	b.lock.Unlock()
+	if plugin != nil {
+		b.stopQueue(name)
+		b.RemoveCommands(name)
+		plugin.Stop()
+	}
+}
+
+// 启用模块，并在配置中标记为启用
+func (b *Bot) EnablePlugin(name string) error {
+	b.lock.Lock()
+	opt := copyOptions(b.cfg.Plugin[name])
+	if opt == nil {
+		opt = map[string]interface{}{}
+	}
+	opt["enable"] = true
+	b.setPluginConfig(name, opt)
+	b.lock.Unlock()
+	return b.AddPlugin(name)
+}
+
+// 禁用模块，并在配置中标记为禁用
+func (b *Bot) DisablePlugin(name string) {
+	b.lock.Lock()
+	if v, ok := b.cfg.Plugin[name]; ok {
+		opt := copyOptions(v)
+		opt["enable"] = false
+		b.setPluginConfig(name, opt)
 	}
+	b.lock.Unlock()
+	b.RemovePlugin(name)
 }
 
 // 按名称加载某个模块
-func (b *Bot) AddPlugin(name string) {
-	for _, v := range b.plugins {
-		if name == v.GetName() {
-			return
-		}
+func (b *Bot) AddPlugin(name string) error {
+	if b.GetPluginByName(name) != nil {
+		return nil
 	}
-	for n, v := range b.cfg.Plugin {
-		if n == name && v["enable"].(bool) {
-			plugin := b.createPlugin(name, v)
-			if plugin != nil && plugin.CheckEnv() { //模块运行环境是否满足
-				plugin.Start(b)
-				b.plugins = append(b.plugins, plugin)
-			}
-		}
+	v, ok := b.GetConfig().Plugin[name]
+	if !ok || v["enable"] != true {
+		return fmt.Errorf("plugin %s is not enabled", name)
 	}
+	plugin, err := CreatePlugin(name, v)
+	if err != nil {
+		return err
+	}
+	if !plugin.CheckEnv() { //模块运行环境是否满足
+		return fmt.Errorf("plugin %s: environment check failed", name)
+	}
+	b.resetFailures(name)
+	b.startPlugin(plugin)
+	b.lock.Lock()
+	b.plugins = append(b.plugins, plugin)
+	b.lock.Unlock()
+	return nil
 }
 
 func (b *Bot) JoinMUC(jid, nickname string) {
-	b.client.JoinMUC(jid, nickname)
+	b.conn().JoinMUC(jid, nickname)
 }
 
 func (b *Bot) JoinProtectedMUC(jid, nickname, password string) {
-	b.client.JoinProtectedMUC(jid, nickname, password)
+	b.conn().JoinProtectedMUC(jid, nickname, password)
 }
 
 func (b *Bot) LeaveMUC(jid string) {
-	b.client.LeaveMUC(jid)
+	b.conn().LeaveMUC(jid)
 }
 
 func (b *Bot) Roster() error {
-	return b.client.Roster()
+	return b.conn().Roster()
 }
 
 func (b *Bot) ApproveSubscription(jid string) {
-	b.client.ApproveSubscription(jid)
+	b.conn().ApproveSubscription(jid)
 }
 
 func (b *Bot) RevokeSubscription(jid string) {
-	b.client.RevokeSubscription(jid)
+	b.conn().RevokeSubscription(jid)
 }
 
 func (b *Bot) RequestSubscription(jid string) {
-	b.client.RequestSubscription(jid)
+	b.conn().RequestSubscription(jid)
 }
 
 // 设置状态消息
 func (b *Bot) SetStatus(status, info string) (n int, err error) {
-	return b.client.SendOrg(fmt.Sprintf("<presence xml:lang='en'><show>%s</show><status>%s</status></presence>", status, info))
+	return b.conn().SendOrg(fmt.Sprintf("<presence xml:lang='en'><show>%s</show><status>%s</status></presence>", status, info))
 }
 
 func (b *Bot) InviteToMUC(jid, roomid, reason string) {
@@ -235,57 +425,87 @@ func (b *Bot) InviteToMUC(jid, roomid, reason string) {
 				password = v.GetPassword()
 			}
 		}
-		b.client.InviteToMUC(b.cfg.Account.Username, nick, jid, roomid, password, reason)
+		b.conn().InviteToMUC(b.cfg.Account.Username, nick, jid, roomid, password, reason)
+	}
+}
+
+// SendMessage 发送富文本消息，typ为chat或groupchat. 消息正文使用XEP-0393样式，
+// 同时附带XHTML-IM; 不含富文本的消息按纯文本发送。超过setup.reply.max_size的消息拆分后发送。
+func (b *Bot) SendMessage(to, typ string, m *Message) {
+	if b.fitsRich(m) {
+		b.send(xmpp.Chat{Remote: to, Type: typ, Text: m.Styled()}, m.HTML())
+	} else {
+		b.sendSplit(to, typ, textOf(m))
 	}
 }
 
-func (b *Bot) SendHtml(chat xmpp.Chat) {
-	text := strings.Replace(chat.Text, "&", "&amp;", -1)
-	org := fmt.Sprintf("<message to='%s' type='%s' xml:lang='en'><body>%s</body>"+
-		"<html xmlns='http://jabber.org/protocol/xhtml-im'><body xmlns='http://www.w3.org/1999/xhtml'>%s</body></html></message>",
-		html.EscapeString(chat.Remote), html.EscapeString(chat.Type), html.EscapeString(chat.Text), text)
-	b.client.SendOrg(org)
+// XHTML-IM无法拆分，超过setup.reply.max_size的富文本消息改为按XEP-0393样式的纯文本拆分发送
+func (b *Bot) fitsRich(m *Message) bool {
+	size, _ := b.replyLimits()
+	return m.IsRich() && len(m.Styled()) <= size
+}
+
+// 按纯文本发送时的消息内容
+func textOf(m *Message) string {
+	if m.IsRich() {
+		return m.Styled()
+	}
+	return m.Plain()
 }
 
 // 回复好友消息，或聊天室私聊消息
 func (b *Bot) ReplyAuto(recv xmpp.Chat, text string) {
-	if strings.Contains(text, "<a href") || strings.Contains(text, "<img") {
-		b.SendHtml(xmpp.Chat{Remote: recv.Remote, Type: "chat", Text: text})
+	b.ReplyAutoMsg(recv, NewMessage().Text(text))
+}
+
+func (b *Bot) ReplyAutoMsg(recv xmpp.Chat, m *Message) {
+	if b.capture(recv.Remote, m.Plain()) {
+		return
+	}
+	if b.fitsRich(m) {
+		b.SendMessage(recv.Remote, "chat", m)
 	} else {
-		b.client.Send(xmpp.Chat{Remote: recv.Remote, Type: "chat", Text: text})
+		b.sendPages(recv.Remote, "chat", textOf(m))
 	}
 }
 
 // 回复好友消息，或聊天室公共消息
 func (b *Bot) ReplyPub(recv xmpp.Chat, text string) {
+	b.ReplyPubMsg(recv, NewMessage().Text(text))
+}
+
+func (b *Bot) ReplyPubMsg(recv xmpp.Chat, m *Message) {
 	if recv.Type == "groupchat" {
 		roomid, _ := utils.SplitJID(recv.Remote)
-		if strings.Contains(text, "<a href") || strings.Contains(text, "<img") {
-			b.SendHtml(xmpp.Chat{Remote: roomid, Type: recv.Type, Text: text})
-		} else {
-			b.client.Send(xmpp.Chat{Remote: roomid, Type: recv.Type, Text: text})
+		if b.fitsRich(m) {
+			b.SendMessage(roomid, recv.Type, m)
+		} else if text := textOf(m); !b.replyPrivate(recv, text) {
+			b.sendPages(roomid, recv.Type, text)
 		}
 	} else {
-		b.ReplyAuto(recv, text)
+		b.ReplyAutoMsg(recv, m)
 	}
 }
 
 // 发送到好友消息，或聊天室私聊消息
 func (b *Bot) SendAuto(to, text string) {
-	if strings.Contains(text, "<a href") || strings.Contains(text, "<img") {
-		b.SendHtml(xmpp.Chat{Remote: to, Type: "chat", Text: text})
-	} else {
-		b.client.Send(xmpp.Chat{Remote: to, Type: "chat", Text: text})
+	b.SendAutoMsg(to, NewMessage().Text(text))
+}
+
+func (b *Bot) SendAutoMsg(to string, m *Message) {
+	if b.capture(to, m.Plain()) {
+		return
 	}
+	b.SendMessage(to, "chat", m)
 }
 
 // 发送聊天室公共消息
 func (b *Bot) SendPub(to, text string) {
-	if strings.Contains(text, "<a href") || strings.Contains(text, "<img") {
-		b.SendHtml(xmpp.Chat{Remote: to, Type: "groupchat", Text: text})
-	} else {
-		b.client.Send(xmpp.Chat{Remote: to, Type: "groupchat", Text: text})
-	}
+	b.SendPubMsg(to, NewMessage().Text(text))
+}
+
+func (b *Bot) SendPubMsg(to string, m *Message) {
+	b.SendMessage(to, "groupchat", m)
 }
 
 func (b *Bot) IsAdminID(jid string) bool {
@@ -311,7 +531,7 @@ func (b *Bot) SentThis(msg xmpp.Chat) bool {
 		}
 	} else if msg.Type == "groupchat" {
 		for _, v := range b.admin.GetRooms() {
-			if msg.Remote == v.JID+"/"+v.Nickname {
+			if msg.Remote == v.JID+"/"+v.GetNick() {
 				return true
 			}
 		}
@@ -319,6 +539,26 @@ func (b *Bot) SentThis(msg xmpp.Chat) bool {
 	return false
 }
 
+// BlockNick 忽略聊天室roomid中nick的消息并保存，bot不在此聊天室或nick已被忽略时返回false.
+func (b *Bot) BlockNick(roomid, nick string) bool {
+	room := b.GetRoom(roomid)
+	if room == nil || !room.BlockOne(nick) {
+		return false
+	}
+	b.admin.SaveBlocks()
+	return true
+}
+
+// UnblockNick 不再忽略聊天室roomid中nick的消息并保存，nick未被忽略时返回false.
+func (b *Bot) UnblockNick(roomid, nick string) bool {
+	room := b.GetRoom(roomid)
+	if room == nil || !room.UnBlockOne(nick) {
+		return false
+	}
+	b.admin.SaveBlocks()
+	return true
+}
+
 // 此人在聊天中被忽略了吗?
 func (b *Bot) BlockRemote(msg xmpp.Chat) bool {
 	if msg.Type == "groupchat" {
@@ -336,11 +576,12 @@ func (b *Bot) BlockRemote(msg xmpp.Chat) bool {
 func (b *Bot) Called(msg xmpp.Chat) (ok bool, text string) {
 	if msg.Type == "groupchat" {
 		for _, v := range b.admin.GetRooms() {
-			if strings.Contains(msg.Text, v.Nickname) {
-				if strings.HasPrefix(msg.Text, v.Nickname+":") {
-					return true, msg.Text[len(v.Nickname)+1:]
+			nick := v.GetNick()
+			if strings.Contains(msg.Text, nick) {
+				if strings.HasPrefix(msg.Text, nick+":") {
+					return true, msg.Text[len(nick)+1:]
 				} else {
-					return true, strings.Replace(msg.Text, v.Nickname, "", -1)
+					return true, strings.Replace(msg.Text, nick, "", -1)
 				}
 			}
 		}
@@ -348,18 +589,22 @@ func (b *Bot) Called(msg xmpp.Chat) (ok bool, text string) {
 	return false, msg.Text
 }
 
-func (b *Bot) SetRoomNick(r *Room, nick string) (n int, err error) {
+// SetRoomNick 修改bot在聊天室r中的昵称，服务器确认后生效，完成后调用done(可以为nil)。
+func (b *Bot) SetRoomNick(r *Room, nick string, done func(err error)) {
+	b.admin.ChangeNick(r, nick, done)
+}
+
+// 发送修改昵称的presence
+func (b *Bot) sendNick(r *Room, nick string) error {
 	msg := fmt.Sprintf("<presence from='%s/%s' to='%s/%s'/>",
-		b.cfg.Account.Username, b.cfg.Account.Resource, r.GetJID(), nick)
-	if n, err = b.client.SendOrg(msg); err == nil {
-		r.SetNick(nick)
-	}
-	return
+		b.cfg.Account.Username, b.cfg.Account.Resource, html.EscapeString(r.GetJID()), html.EscapeString(nick))
+	_, err := b.conn().SendOrg(msg)
+	return err
 }
 
 func (b *Bot) SetRobert(jid string) (n int, err error) {
 	msg := fmt.Sprintf("<presence from='%s/%s' to='%s'><caps:c node='http://talk.google.com/xmpp/bot/caps' ver='1.0' xmlns:caps='http://jabber.org/protocol/caps'/></presence>", b.cfg.Account.Username, b.cfg.Account.Resource, jid)
-	return b.client.SendOrg(msg)
+	return b.conn().SendOrg(msg)
 }
 
 func (b *Bot) GetCmdString(cmd string) string {
@@ -0,0 +1,412 @@
+package robot
+
+import (
+	"fmt"
+	"github.com/mattn/go-xmpp"
+	"github.com/yetist/xmppbot/config"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testAdmin = "a@example.com/r"
+	testGuest = "u@example.org/r"
+)
+
+// 测试用的模块，回复命令参数
+type echoPlugin struct {
+	Name string
+	bot  *Bot
+	*OptionSet
+}
+
+func init() {
+	RegisterPlugin("echo", func(name string, opt map[string]interface{}) PluginIface {
+		return &echoPlugin{Name: name, OptionSet: NewOptionSet(nil, opt)}
+	}, PluginMeta{Summary: "测试模块"})
+}
+
+func (m *echoPlugin) GetName() string     { return m.Name }
+func (m *echoPlugin) GetSummary() string  { return "测试模块" }
+func (m *echoPlugin) Help() string        { return m.GetSummary() }
+func (m *echoPlugin) Description() string { return m.Describe(m.Help()) }
+func (m *echoPlugin) CheckEnv() bool      { return true }
+func (m *echoPlugin) Stop()               {}
+func (m *echoPlugin) Restart()            {}
+
+func (m *echoPlugin) Start(bot *Bot) {
+	m.bot = bot
+	m.bot.SetPerm(m.Name, AllTalk)
+	m.bot.AddCommand(m.Name, Command{Name: m.Name + " say", Args: []Arg{{Name: "Text", Variadic: true}},
+		Help: "回复Text", Handler: func(msg xmpp.Chat, args *Args) {
+			m.bot.ReplyAuto(msg, args.String("Text"))
+		}})
+	m.bot.AddCommand(m.Name, Command{Name: m.Name + " lines", Args: []Arg{{Name: "n", Type: IntArg}},
+		Help: "回复n行", Handler: func(msg xmpp.Chat, args *Args) {
+			var lines []string
+			for i := 1; i <= args.Int("n"); i++ {
+				lines = append(lines, fmt.Sprintf("line %d", i))
+			}
+			m.bot.ReplyAuto(msg, strings.Join(lines, "\n"))
+		}})
+	m.bot.AddCommand(m.Name, Command{Name: m.Name + " rich", Args: []Arg{{Name: "n", Type: IntArg}},
+		Help: "回复n行富文本", Handler: func(msg xmpp.Chat, args *Args) {
+			rich := NewMessage()
+			for i := 1; i <= args.Int("n"); i++ {
+				rich.Bold(fmt.Sprintf("line %d", i)).Line()
+			}
+			m.bot.ReplyAutoMsg(msg, rich)
+		}})
+}
+
+func (m *echoPlugin) Chat(msg xmpp.Chat) {
+	m.bot.RunCommand(m.Name, msg)
+}
+
+func (m *echoPlugin) Presence(pres xmpp.Presence) {}
+
+func newTestBot(t *testing.T, setup func(cfg *config.Config)) (*Bot, *FakeTransport) {
+	var cfg config.Config
+	cfg.Setup.CmdPrefix = "--"
+	cfg.Setup.StateDB = ":memory:"
+	cfg.Setup.Admin = []string{"a@example.com"}
+	cfg.Setup.Outbox.Rate = -1
+	cfg.Setup.Outbox.DestRate = -1
+	cfg.Plugin = map[string]map[string]interface{}{"echo": {"enable": true}}
+	if setup != nil {
+		setup(&cfg)
+	}
+	fake := NewFakeTransport()
+	bot := NewBot(fake, cfg)
+	bot.Start()
+	go bot.Run()
+	t.Cleanup(func() { bot.Shutdown(time.Second) })
+	return bot, fake
+}
+
+// 发送命令，返回bot回复的消息
+func chat(t *testing.T, fake *FakeTransport, from, text string, n int) []string {
+	t.Helper()
+	fake.Reset()
+	fake.Inject(xmpp.Chat{Remote: from, Type: "chat", Text: text})
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if sent := fake.Sent(from); len(sent) >= n {
+			time.Sleep(20 * time.Millisecond)
+			return fake.Sent(from)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	sent := fake.Sent(from)
+	t.Fatalf("%s: got %d replies %q, want %d", text, len(sent), sent, n)
+	return nil
+}
+
+func TestCommandRouting(t *testing.T) {
+	_, fake := newTestBot(t, nil)
+	tests := []struct {
+		text, want string
+	}{
+		{"--echo say hello world", "hello world"},
+		{`--echo say "quoted  text"`, "quoted  text"},
+		{"--echo lines 2", "line 1\nline 2"},
+		{"--echo lines two", "参数n应为整数: two"},
+		{"--echo lines", "用法: --echo lines <n>"},
+		{"--echo nosuch", "不支持的命令: nosuch"},
+		{"--echo say --help", "--echo say <Text...>"},
+	}
+	for _, tt := range tests {
+		sent := chat(t, fake, testGuest, tt.text, 1)
+		if !strings.Contains(sent[0], tt.want) {
+			t.Errorf("%s = %q, want %q", tt.text, sent[0], tt.want)
+		}
+	}
+}
+
+func TestRolePermissions(t *testing.T) {
+	_, fake := newTestBot(t, nil)
+	expect := func(from, text, want string) {
+		t.Helper()
+		sent := chat(t, fake, from, text, 1)
+		if !strings.Contains(sent[0], want) {
+			t.Errorf("%s: %s = %q, want %q", from, text, sent[0], want)
+		}
+	}
+	expect(testGuest, "--admin list", "本命令仅限管理员使用。")
+	expect(testAdmin, "--admin list", "a@example.com")
+
+	expect(testGuest, "--echo say hi", "hi")
+	expect(testAdmin, "--admin role grant echo operator", "命令echo已设置为需要operator及以上角色")
+	expect(testGuest, "--echo say hi", "本命令仅限operator及以上角色使用。")
+	expect(testAdmin, "--admin role set @example.org operator", "已将@example.org的角色设置为operator")
+	expect(testGuest, "--echo say hi", "hi")
+
+	// operator不能使用管理员命令，通过命令添加的管理员不能授予owner角色
+	expect(testGuest, "--admin role set u@example.org owner", "本命令仅限管理员使用。")
+	expect(testAdmin, "--admin add b@example.com", "您已添加 b@example.com为管理员!")
+	expect("b@example.com/r", "--admin role set @example.org owner", "不能授予或修改owner及以上的角色")
+	expect("b@example.com/r", "--admin role set @example.org member", "已将@example.org的角色设置为member")
+}
+
+// 通过--more查看全部内容，每条消息包括提示都不超过max_size
+func readPages(t *testing.T, fake *FakeTransport, text string, size int) (all []string, pages int) {
+	t.Helper()
+	note := regexp.MustCompile(`\n\(还有\d+条，请发送--more查看\)$`)
+	chat(t, fake, testGuest, text, 1)
+	for pages = 1; pages < 100; pages++ {
+		time.Sleep(20 * time.Millisecond)
+		sent := fake.Sent(testGuest)
+		for _, v := range sent {
+			if len(v) > size {
+				t.Errorf("%s: part too long (%d): %q", text, len(v), v)
+			}
+		}
+		last := sent[len(sent)-1]
+		if !note.MatchString(last) {
+			return append(all, sent...), pages
+		}
+		all = append(all, sent[:len(sent)-1]...)
+		all = append(all, note.ReplaceAllString(last, ""))
+		chat(t, fake, testGuest, "--more", 1)
+	}
+	t.Fatalf("%s: too many pages", text)
+	return
+}
+
+func TestPager(t *testing.T) {
+	_, fake := newTestBot(t, func(cfg *config.Config) {
+		cfg.Setup.Reply.MaxSize = 64
+		cfg.Setup.Reply.MaxParts = 2
+	})
+	var lines []string
+	for i := 1; i <= 40; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	// 40行共310字节
+	all, pages := readPages(t, fake, "--echo lines 40", 64)
+	if strings.Join(all, "\n") != strings.Join(lines, "\n") || pages < 3 {
+		t.Errorf("pages(%d) = %q", pages, all)
+	}
+	if sent := chat(t, fake, testGuest, "--more", 1); sent[0] != "没有更多内容了。" {
+		t.Errorf("last page = %q", sent)
+	}
+
+	// 较长的富文本消息按XEP-0393样式的纯文本拆分
+	for i := range lines {
+		lines[i] = "*" + lines[i] + "*"
+	}
+	all, _ = readPages(t, fake, "--echo rich 40", 64)
+	if strings.Join(all, "\n") != strings.Join(lines, "\n") {
+		t.Errorf("rich pages = %q", all)
+	}
+	fake.lock.Lock()
+	orgs := len(fake.Orgs)
+	fake.lock.Unlock()
+	if orgs != 0 {
+		t.Errorf("long rich message sent as XHTML-IM")
+	}
+	chat(t, fake, testGuest, "--echo rich 2", 0)
+	time.Sleep(50 * time.Millisecond)
+	fake.lock.Lock()
+	orgs = len(fake.Orgs)
+	fake.lock.Unlock()
+	if orgs != 1 {
+		t.Errorf("short rich message not sent as XHTML-IM")
+	}
+}
+
+// 保存的配置不包含命令行参数的覆盖，离开所有聊天室后不再包含聊天室
+func TestRuntimeConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "xmppbot.toml")
+	text := "[account]\npassword = \"file\"\n\n[[setup.rooms]]\njid = \"r@c\"\nnickname = \"bot\"\n"
+	if err := ioutil.WriteFile(path, []byte(text), 0600); err != nil {
+		t.Fatal(err)
+	}
+	bot, _ := newTestBot(t, func(cfg *config.Config) {
+		cfg.AppPath = path
+		cfg.Account.Password = "flag"
+		cfg.Setup.Debug = true
+		cfg.Setup.Rooms = []map[string]interface{}{{"jid": "r@c", "nickname": "bot"}}
+	})
+	cfg, err := bot.RuntimeConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Account.Password != "file" || cfg.Setup.Debug || cfg.Setup.CmdPrefix != "--" {
+		t.Errorf("command line overrides saved: %+v", cfg)
+	}
+	if len(cfg.Setup.Rooms) != 1 || cfg.Setup.Rooms[0]["jid"] != "r@c" {
+		t.Errorf("rooms = %v", cfg.Setup.Rooms)
+	}
+
+	bot.admin.(*Admin).leaveRoom("r@c")
+	if cfg, _ = bot.RuntimeConfig(); len(cfg.Setup.Rooms) != 0 {
+		t.Errorf("rooms after leave = %v", cfg.Setup.Rooms)
+	}
+	if out, _ := config.Render(cfg); strings.Contains(out, "r@c") {
+		t.Errorf("left room saved:\n%s", out)
+	}
+}
+
+// 等待服务器回应时不阻塞Admin的worker, 收到回应后回复并记录审计日志
+func TestModerateAsync(t *testing.T) {
+	bot, fake := newTestBot(t, func(cfg *config.Config) {
+		cfg.Setup.Rooms = []map[string]interface{}{{"jid": "r@c", "nickname": "bot"}}
+	})
+	fake.Inject(xmpp.Presence{From: "r@c/bot"})
+	fake.Reset()
+	fake.Inject(xmpp.Chat{Remote: testAdmin, Type: "chat", Text: "--room kick r@c troll spam"})
+	id := waitIQ(fake, "nick='troll' role='none'", 2*time.Second)
+	if id == "" {
+		t.Fatal("kick request not sent")
+	}
+	if sent := chat(t, fake, testAdmin, "--admin list", 1); !strings.Contains(sent[0], "a@example.com") {
+		t.Errorf("admin list = %q", sent)
+	}
+	fake.Reset()
+	fake.Inject(xmpp.IQ{ID: id, From: "r@c", Type: "result"})
+	deadline := time.Now().Add(2 * time.Second)
+	for len(fake.Sent(testAdmin)) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if sent := fake.Sent(testAdmin); len(sent) != 1 || sent[0] != "r@c: 踢出troll成功" {
+		t.Fatalf("kick = %q", sent)
+	}
+	audits, err := bot.GetAudits(1)
+	if err != nil || len(audits) != 1 || audits[0].Result != "r@c: 踢出troll成功" {
+		t.Errorf("audits = %+v, %v", audits, err)
+	}
+}
+
+// 计划任务执行的异步命令完成后才发送结果
+func TestCronModerate(t *testing.T) {
+	bot, fake := newTestBot(t, func(cfg *config.Config) {
+		cfg.Setup.Rooms = []map[string]interface{}{{"jid": "r@c", "nickname": "bot"}}
+	})
+	fake.Inject(xmpp.Presence{From: "r@c/bot"})
+	sent := chat(t, fake, testAdmin, "--cron add-cmd '@every 1h' u@example.org --room kick r@c troll spam", 1)
+	if !strings.HasPrefix(sent[0], "已添加计划任务 #1") {
+		t.Fatalf("cron add-cmd = %q", sent)
+	}
+	fake.Reset()
+	go bot.admin.(*Admin).runTask(1)
+	id := waitIQ(fake, "nick='troll' role='none'", 2*time.Second)
+	if id == "" {
+		t.Fatal("kick request not sent")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if sent := fake.Sent("u@example.org"); len(sent) != 0 {
+		t.Fatalf("result sent before kick finished: %q", sent)
+	}
+	fake.Inject(xmpp.IQ{ID: id, From: "r@c", Type: "result"})
+	deadline := time.Now().Add(2 * time.Second)
+	for len(fake.Sent("u@example.org")) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if sent := fake.Sent("u@example.org"); len(sent) != 1 || sent[0] != "r@c: 踢出troll成功" {
+		t.Errorf("task result = %q", sent)
+	}
+	if sent := fake.Sent(testAdmin); len(sent) != 0 {
+		t.Errorf("sent to admin = %q", sent)
+	}
+}
+
+// 等待发出包含text的iq请求，返回请求的id, 超时返回""
+func waitIQ(fake *FakeTransport, text string, timeout time.Duration) string {
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		fake.lock.Lock()
+		for _, org := range fake.Orgs {
+			if strings.HasPrefix(org, "<iq ") && strings.Contains(org, text) {
+				fake.lock.Unlock()
+				return strings.SplitN(org[strings.Index(org, "id='")+4:], "'", 2)[0]
+			}
+		}
+		fake.lock.Unlock()
+	}
+	return ""
+}
+
+// 通过FakeTransport依次执行--room, --cron和--plugin命令，检查回复及发出的请求
+func TestAdminCommands(t *testing.T) {
+	_, fake := newTestBot(t, func(cfg *config.Config) {
+		cfg.Setup.Rooms = []map[string]interface{}{{"jid": "r@c", "nickname": "bot"}}
+	})
+	tests := []struct {
+		text string
+		want string // 回复中应包含的内容，为空时不应回复
+		call string // 应调用的Transport方法
+	}{
+		{"--room list", " 1: r@c as bot", ""},
+		{"--room block r@c troll", "", ""},
+		{"--room list-blocks r@c", "== Block of r@c ==\ntroll", ""},
+		{"--room unblock r@c troll", "", ""},
+		{"--room join x@c bot2", "正在进入聊天室x@c", "join x@c/bot2"},
+		{"--room join x@c bot2", "已经在聊天室x@c中", ""},
+		{"--room list", " 2: x@c as bot2", ""},
+		{"--room leave x@c", "已经退出群聊x@c", "leave x@c"},
+		{"--room kick", "用法: --room kick <Rid> <Nick> [Reason...]", ""},
+		{`--cron add "@every 1h" u@example.org hello`, "已添加计划任务 #1 [@every 1h] => [u@example.org] : hello", ""},
+		{"--cron pause 1", "已暂停计划任务 #1", ""},
+		{"--cron list", "#1 [@every 1h] => [u@example.org] : hello (已暂停)", ""},
+		{"--cron resume 1", "已恢复计划任务 #1", ""},
+		{"--cron edit 1 text bye", "bye", ""},
+		{"--cron edit 1 message bye", "不支持修改message", ""},
+		{"--cron del 1", "已删除计划任务 #1", ""},
+		{"--cron del 1", "没有此计划任务: 1", ""},
+		{"--plugin disable echo", "已禁用模块echo", ""},
+		{"--echo say hi", "", ""},
+		{"--plugin enable echo", "已启用模块echo", ""},
+		{"--echo say hi", "hi", ""},
+		{"--plugin set echo.nosuch 1", "设置echo.nosuch失败: unknown option nosuch", ""},
+		{"--plugin enable nosuch", "启用模块失败: plugin nosuch is not compiled in", ""},
+	}
+	for _, tt := range tests {
+		n := 1
+		if tt.want == "" {
+			n = 0
+		}
+		sent := chat(t, fake, testAdmin, tt.text, n)
+		if tt.want == "" {
+			time.Sleep(50 * time.Millisecond)
+			if sent = fake.Sent(testAdmin); len(sent) != 0 {
+				t.Errorf("%s = %q, want no reply", tt.text, sent)
+			}
+		} else if !strings.Contains(sent[0], tt.want) {
+			t.Errorf("%s = %q, want %q", tt.text, sent[0], tt.want)
+		}
+		if tt.call != "" {
+			fake.lock.Lock()
+			calls := strings.Join(fake.Calls, "\n")
+			fake.lock.Unlock()
+			if calls != tt.call {
+				t.Errorf("%s calls %q, want %q", tt.text, calls, tt.call)
+			}
+		}
+	}
+}
+
+func TestAuditCount(t *testing.T) {
+	_, fake := newTestBot(t, nil)
+	for _, text := range []string{"--admin audit 0", "--admin audit -1", "--admin audit 501"} {
+		if sent := chat(t, fake, testAdmin, text, 1); !strings.HasPrefix(sent[0], "n应为1到500之间的整数") {
+			t.Errorf("%s = %q", text, sent)
+		}
+	}
+	chat(t, fake, testAdmin, "--admin add b@example.com", 1)
+	if sent := chat(t, fake, testAdmin, "--admin audit 1", 1); !strings.Contains(sent[0], "admin.add b@example.com") {
+		t.Errorf("audit = %q", sent)
+	}
+
+	s := NewMemoryStore()
+	for i := 0; i < MaxAuditCount+10; i++ {
+		s.AddAudit(AuditEvent{Command: fmt.Sprint(i)})
+	}
+	events, _ := s.Audits(MaxAuditCount + 10)
+	if len(events) != MaxAuditCount || events[0].Id != MaxAuditCount+10 || events[len(events)-1].Command != "10" {
+		t.Errorf("memory audits: %d, first %+v", len(events), events[0])
+	}
+}
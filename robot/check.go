@@ -0,0 +1,56 @@
+package robot
+
+import (
+	"fmt"
+	"github.com/yetist/xmppbot/config"
+	"github.com/yetist/xmppbot/utils"
+	"sort"
+	"strings"
+)
+
+// CheckConfig 检查配置文件及所有模块的配置，返回发现的所有错误。
+func CheckConfig(cfg config.Config) (errs []error) {
+	if cfg.Account.Server == "" {
+		errs = append(errs, fmt.Errorf("account.server is empty"))
+	}
+	if cfg.Account.Port <= 0 || cfg.Account.Port > 65535 {
+		errs = append(errs, fmt.Errorf("account.port %d is invalid", cfg.Account.Port))
+	}
+	if cfg.Account.Username != "" && !strings.Contains(cfg.Account.Username, "@") {
+		errs = append(errs, fmt.Errorf("account.username %s is not a jid", cfg.Account.Username))
+	}
+	if !utils.IsValidStatus(cfg.Setup.Status) {
+		errs = append(errs, fmt.Errorf("setup.status %q is invalid, allowed are: away, chat, dnd, xa", cfg.Setup.Status))
+	}
+	if cfg.Setup.CmdPrefix == "" {
+		errs = append(errs, fmt.Errorf("setup.cmd_prefix is empty"))
+	}
+	for k, room := range cfg.Setup.Rooms {
+		for _, key := range []string{"jid", "nickname"} {
+			if v, ok := room[key].(string); !ok || v == "" {
+				errs = append(errs, fmt.Errorf("setup.rooms[%d].%s must be a non-empty string", k, key))
+			}
+		}
+		if v, ok := room["password"]; ok {
+			if _, ok := v.(string); !ok {
+				errs = append(errs, fmt.Errorf("setup.rooms[%d].password must be a string", k))
+			}
+		}
+	}
+
+	names := make([]string, 0, len(cfg.Plugin))
+	for name := range cfg.Plugin {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		// 未编译的模块只有在启用时才算错误
+		if _, ok := GetPluginMeta(name); !ok && cfg.Plugin[name]["enable"] != true {
+			continue
+		}
+		if err := CheckPluginOptions(name, cfg.Plugin[name]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return
+}
@@ -0,0 +1,424 @@
+package robot
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mattn/go-xmpp"
+	"github.com/yetist/xmppbot/utils"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+type ArgType int
+
+const (
+	StringArg ArgType = iota
+	IntArg
+	BoolArg
+	JIDArg // 必须包含"@"
+)
+
+// Arg 描述命令的一个参数。
+type Arg struct {
+	Name     string
+	Type     ArgType
+	Optional bool // 可选参数，只能位于必选参数之后
+	Variadic bool // 接收其余所有参数，只能是最后一个参数
+	Secret   bool // 如密码，在审计日志中隐藏
+}
+
+func (a Arg) check(val string) error {
+	switch a.Type {
+	case IntArg:
+		if _, err := strconv.Atoi(val); err != nil {
+			return fmt.Errorf("参数%s应为整数: %s", a.Name, val)
+		}
+	case BoolArg:
+		if _, err := utils.ParseBool(val); err != nil {
+			return fmt.Errorf("参数%s应为true或false: %s", a.Name, val)
+		}
+	case JIDArg:
+		if !strings.Contains(val, "@") {
+			return fmt.Errorf("参数%s应为jid: %s", a.Name, val)
+		}
+	}
+	return nil
+}
+
+func (a Arg) usage() string {
+	name := a.Name
+	if a.Variadic {
+		name += "..."
+	}
+	if a.Optional {
+		return "[" + name + "]"
+	}
+	return "<" + name + ">"
+}
+
+// Command 描述一条命令。Name为命令及子命令，如"room send", 输入时需加上命令前缀，如"--room send".
+type Command struct {
+	Name    string
+	Args    []Arg
+	Help    string
+	Perm    int  // 命令权限，0表示使用顶级命令(如"room")的权限，可通过"bot perm room.send"修改
+	Audit   bool // 是否记录审计日志
+	Handler func(msg xmpp.Chat, args *Args)
+}
+
+// 权限名称，如"room.send"
+func (c Command) permName() string {
+	return strings.Join(strings.Fields(c.Name), ".")
+}
+
+// Usage 返回命令格式，如"--room send <Rid> <msg...>"
+func (c Command) Usage(prefix string) string {
+	usage := []string{prefix + c.Name}
+	for _, a := range c.Args {
+		usage = append(usage, a.usage())
+	}
+	return strings.Join(usage, " ")
+}
+
+// 审计日志中的参数，隐藏Secret参数
+func (c Command) auditArgs(tokens []token) string {
+	var args []string
+	for i, t := range tokens {
+		if i < len(c.Args) && c.Args[i].Secret {
+			args = append(args, "***")
+		} else if strings.IndexFunc(t.val, unicode.IsSpace) >= 0 || t.val == "" {
+			args = append(args, strconv.Quote(t.val))
+		} else {
+			args = append(args, t.val)
+		}
+	}
+	return strings.Join(args, " ")
+}
+
+// 按参数描述解析，text为参数部分的原始文本，用于变长参数保留原始格式
+func (c Command) parse(tokens []token, text string) (*Args, error) {
+	args := &Args{values: map[string]string{}, lists: map[string][]string{}}
+	i := 0
+	for _, a := range c.Args {
+		if i >= len(tokens) {
+			if a.Optional {
+				continue
+			}
+			return nil, fmt.Errorf("缺少参数%s", a.Name)
+		}
+		if a.Variadic {
+			for _, t := range tokens[i:] {
+				if err := a.check(t.val); err != nil {
+					return nil, err
+				}
+				args.lists[a.Name] = append(args.lists[a.Name], t.val)
+			}
+			if len(tokens)-i == 1 {
+				args.values[a.Name] = tokens[i].val
+			} else {
+				args.values[a.Name] = strings.TrimSpace(text[tokens[i].start:])
+			}
+			i = len(tokens)
+			break
+		}
+		if err := a.check(tokens[i].val); err != nil {
+			return nil, err
+		}
+		args.values[a.Name] = tokens[i].val
+		args.lists[a.Name] = []string{tokens[i].val}
+		i++
+	}
+	if i < len(tokens) {
+		return nil, fmt.Errorf("多余的参数: %s", strings.TrimSpace(text[tokens[i].start:]))
+	}
+	return args, nil
+}
+
+// Args 是解析后的命令参数。
+type Args struct {
+	values map[string]string
+	lists  map[string][]string
+	result string
+	async  chan struct{}       // 调用Async后创建，命令完成时关闭
+	audit  func(result string) // 记录审计日志，命令不需要审计时为nil
+}
+
+// Has 返回可选参数是否提供。
+func (a *Args) Has(name string) bool {
+	_, ok := a.values[name]
+	return ok
+}
+
+// String 返回参数值，变长参数返回其余参数的原始文本。
+func (a *Args) String(name string) string {
+	return a.values[name]
+}
+
+func (a *Args) Int(name string) int {
+	i, _ := strconv.Atoi(a.values[name])
+	return i
+}
+
+func (a *Args) Bool(name string) bool {
+	switch strings.ToLower(a.values[name]) {
+	case "1", "true", "t", "y", "yes", "ok":
+		return true
+	}
+	return false
+}
+
+// List 返回变长参数的各个值。
+func (a *Args) List(name string) []string {
+	return a.lists[name]
+}
+
+// SetResult 设置命令的执行结果，用于审计日志，未设置时为"ok".
+func (a *Args) SetResult(result string) {
+	a.result = result
+}
+
+// Async 表示命令在Handler返回后继续在其它goroutine中执行，返回的函数用于在完成时设置执行结果，
+// 此时才记录审计日志，ExecCommand也等到此时才返回。返回的函数只有第一次调用有效。
+func (a *Args) Async() func(result string) {
+	finished := make(chan struct{})
+	a.async = finished
+	audit := a.audit
+	var once sync.Once
+	return func(result string) {
+		once.Do(func() {
+			if audit != nil {
+				audit(result)
+			}
+			close(finished)
+		})
+	}
+}
+
+type token struct {
+	val   string
+	start int
+}
+
+// SplitArgs 按空白分隔参数，支持单引号、双引号及反斜杠转义。
+func SplitArgs(text string) ([]string, error) {
+	tokens, err := splitArgs(text)
+	var args []string
+	for _, t := range tokens {
+		args = append(args, t.val)
+	}
+	return args, err
+}
+
+func splitArgs(text string) (tokens []token, err error) {
+	var cur []rune
+	var quote rune
+	start := -1
+	escape := false
+	for i, r := range text {
+		switch {
+		case escape:
+			cur = append(cur, r)
+			escape = false
+		case r == '\\' && quote != '\'':
+			escape = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur = append(cur, r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case unicode.IsSpace(r):
+			if start >= 0 {
+				tokens = append(tokens, token{string(cur), start})
+				cur = nil
+				start = -1
+			}
+			continue
+		default:
+			cur = append(cur, r)
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("引号不匹配")
+	}
+	if escape {
+		cur = append(cur, '\\')
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{string(cur), start})
+	}
+	return
+}
+
+// AddCommand 注册模块的命令，通常在模块的Start中调用，同名命令将被替换。
+// 模块需要在Chat中调用RunCommand处理这些命令。
+func (b *Bot) AddCommand(owner string, cmd Command) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.commands == nil {
+		b.commands = map[string][]Command{}
+	}
+	for k, v := range b.commands[owner] {
+		if v.Name == cmd.Name {
+			b.commands[owner][k] = cmd
+			return
+		}
+	}
+	b.commands[owner] = append(b.commands[owner], cmd)
+}
+
+// RemoveCommands 删除模块注册的所有命令，卸载模块时自动调用。
+func (b *Bot) RemoveCommands(owner string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.commands, owner)
+}
+
+func (b *Bot) getCommands(owner, group string) (cmds []Command) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	for _, v := range b.commands[owner] {
+		if strings.Fields(v.Name)[0] == group {
+			cmds = append(cmds, v)
+		}
+	}
+	return
+}
+
+// RunCommand 解析msg中属于owner模块的命令，检查权限及参数后调用命令处理函数。
+// "--room help"、"--room --help"及"--room send --help"将显示自动生成的帮助。
+// msg是owner模块的命令时返回true.
+func (b *Bot) RunCommand(owner string, msg xmpp.Chat) bool {
+	ok, _, _ := b.runCommand(owner, msg)
+	return ok
+}
+
+// 同RunCommand, 命令格式错误、没有权限或参数错误时同时返回错误。
+// 命令调用了Args.Async时返回的finished在命令完成时关闭，否则为nil.
+func (b *Bot) runCommand(owner string, msg xmpp.Chat) (ok bool, finished <-chan struct{}, err error) {
+	if len(msg.Text) == 0 || !msg.Stamp.IsZero() || !b.IsCmd(msg.Text) {
+		return false, nil, nil
+	}
+	text := strings.TrimSpace(msg.Text)
+	text = text[len(b.GetCmdString("")):]
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return false, nil, nil
+	}
+	group := fields[0]
+	cmds := b.getCommands(owner, group)
+	if len(cmds) == 0 {
+		return false, nil, nil
+	}
+
+	tokens, err := splitArgs(text)
+	if err != nil {
+		b.ReplyAuto(msg, "命令格式错误: "+err.Error())
+		return true, nil, err
+	}
+
+	// 匹配最长的命令名
+	var cmd *Command
+	n := 0
+	for k, v := range cmds {
+		names := strings.Fields(v.Name)
+		if len(names) <= n || len(names) > len(tokens) {
+			continue
+		}
+		match := true
+		for i, name := range names {
+			if tokens[i].val != name {
+				match = false
+				break
+			}
+		}
+		if match {
+			cmd, n = &cmds[k], len(names)
+		}
+	}
+
+	if cmd == nil {
+		if !b.admin.HasCmdPerm(group, 0, msg) {
+			return true, nil, errPermDenied
+		}
+		if len(tokens) == 1 || tokens[1].val == "help" || tokens[1].val == "--help" {
+			b.ReplyAuto(msg, b.CommandHelp(owner, group))
+			return true, nil, nil
+		}
+		b.ReplyAuto(msg, "不支持的命令: "+tokens[1].val+"\n查看帮助请发送: "+b.GetCmdString(group)+" help")
+		return true, nil, errors.New("不支持的命令: " + tokens[1].val)
+	}
+
+	tokens = tokens[n:]
+	rest := ""
+	if len(tokens) > 0 {
+		off := tokens[0].start
+		rest = text[off:]
+		for k := range tokens {
+			tokens[k].start -= off
+		}
+	}
+	if !b.admin.HasCmdPerm(cmd.permName(), cmd.Perm, msg) {
+		if cmd.Audit {
+			b.audit(msg, *cmd, cmd.auditArgs(tokens), "denied")
+		}
+		return true, nil, errPermDenied
+	}
+	if len(tokens) == 1 && tokens[0].val == "--help" {
+		b.ReplyAuto(msg, cmd.Usage(b.GetCmdString(""))+"\n"+cmd.Help)
+		return true, nil, nil
+	}
+	args, err := cmd.parse(tokens, rest)
+	if err != nil {
+		b.ReplyAuto(msg, err.Error()+"\n用法: "+cmd.Usage(b.GetCmdString("")))
+		return true, nil, err
+	}
+	if cmd.Audit {
+		args.audit = func(result string) {
+			b.audit(msg, *cmd, cmd.auditArgs(tokens), result)
+		}
+		defer func() {
+			if err := recover(); err != nil {
+				b.audit(msg, *cmd, cmd.auditArgs(tokens), fmt.Sprintf("panic: %v", err))
+				panic(err)
+			}
+			if args.async != nil {
+				return
+			}
+			if args.result == "" {
+				args.result = "ok"
+			}
+			b.audit(msg, *cmd, cmd.auditArgs(tokens), args.result)
+		}()
+	}
+	cmd.Handler(msg, args)
+	return true, args.async, nil
+}
+
+// CommandHelp 返回自动生成的命令帮助。
+func (b *Bot) CommandHelp(owner, group string) string {
+	prefix := b.GetCmdString("")
+	var usages []string
+	width := 0
+	cmds := b.getCommands(owner, group)
+	for _, v := range cmds {
+		usage := v.Usage(prefix)
+		if len(usage) > width {
+			width = len(usage)
+		}
+		usages = append(usages, usage)
+	}
+	help := []string{"==" + group + "命令== " + b.ShowPerm(group)}
+	for k, v := range cmds {
+		help = append(help, fmt.Sprintf("%-*s  %s", width, usages[k], v.Help))
+	}
+	help = append(help, "查看命令详情: "+prefix+group+" <命令> --help")
+	return strings.Join(help, "\n")
+}
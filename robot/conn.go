@@ -0,0 +1,78 @@
+package robot
+
+import (
+	"log"
+	"time"
+)
+
+// DialFunc 建立一个新的到服务器的连接。
+type DialFunc func() (Transport, error)
+
+// ConnManager 维护Bot的连接，连接断开后按Backoff重连，Bot和各模块在重连过程中保持运行。
+type ConnManager struct {
+	bot     *Bot
+	dial    DialFunc
+	backoff *Backoff
+	quit    chan bool
+}
+
+func NewConnManager(bot *Bot, dial DialFunc) *ConnManager {
+	opt := bot.GetConfig().Setup.Reconnect
+	return &ConnManager{
+		bot:     bot,
+		dial:    dial,
+		backoff: NewBackoff(time.Duration(opt.Min)*time.Second, time.Duration(opt.Max)*time.Second, opt.Factor, opt.Jitter),
+		quit:    make(chan bool),
+	}
+}
+
+// Run 接收消息，并在连接出错时自动重连，直到Stop被调用。
+func (c *ConnManager) Run() {
+	for {
+		err := c.bot.Run()
+		select {
+		case <-c.quit:
+			return
+		default:
+		}
+		if c.bot.isStopping() {
+			return
+		}
+		log.Print("bot get error:", err)
+		c.bot.conn().Close()
+		if !c.redial() {
+			return
+		}
+	}
+}
+
+// Stop 停止重连，使Run返回。
+func (c *ConnManager) Stop() {
+	close(c.quit)
+	c.bot.conn().Close()
+}
+
+func (c *ConnManager) redial() bool {
+	for {
+		wait := c.backoff.Next()
+		log.Printf("reconnect in %v (attempt %d)", wait, c.backoff.Attempt())
+		select {
+		case <-c.quit:
+			return false
+		case <-time.After(wait):
+		}
+		client, err := c.dial()
+		if err != nil {
+			log.Print("reconnect failed:", err)
+			continue
+		}
+		if c.bot.isStopping() {
+			client.Close()
+			return false
+		}
+		c.backoff.Reset()
+		c.bot.Reconnect(client)
+		log.Print("reconnected")
+		return true
+	}
+}
@@ -0,0 +1,488 @@
+package robot
+
+import (
+	"fmt"
+	"github.com/jakecoffman/cron"
+	"github.com/mattn/go-xmpp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// --cron next 默认显示的次数
+	DefaultCronPreview = 5
+	// 错过执行时间(如bot未运行)的一次性任务，在启动后多久执行
+	missedTaskDelay = 30 * time.Second
+)
+
+// CronTask 是通过--cron命令添加的计划任务，保存在状态数据库中。
+// Spec可以是6个字段的cron表达式、@daily、@every 1h30m等描述符，或"at 2006-01-02 15:04"的一次性任务。
+type CronTask struct {
+	ID      int
+	Spec    string
+	TZ      string // 时区，如Asia/Shanghai, 为空时使用本地时区
+	To      string
+	Text    string
+	Command bool   // Text是命令，以创建者的身份执行，结果发送到To
+	Owner   string // 创建者jid
+	Paused  bool
+	Created time.Time
+}
+
+// 旧版本中以命令的md5为ID保存的计划任务，载入时转换为CronTask.
+type CronEntry struct {
+	Spec string
+	To   string
+	Text string
+}
+
+func (t CronTask) name() string {
+	return "task-" + strconv.Itoa(t.ID)
+}
+
+func (t CronTask) oneShot() bool {
+	return strings.HasPrefix(t.Spec, "at ")
+}
+
+func (t CronTask) String() string {
+	spec := t.Spec
+	if t.TZ != "" {
+		spec += " " + t.TZ
+	}
+	s := fmt.Sprintf("#%d [%s] => [%s] : %s", t.ID, spec, t.To, t.Text)
+	if t.Command {
+		s = fmt.Sprintf("#%d [%s] => [%s] 执行: %s (%s)", t.ID, spec, t.To, t.Text, t.Owner)
+	}
+	if t.Paused {
+		return s + " (已暂停)"
+	}
+	if sched, err := parseSchedule(t.Spec, t.TZ); err == nil {
+		if next := sched.Next(time.Now()); !next.IsZero() {
+			s += " 下次: " + next.Format("2006-01-02 15:04:05 MST")
+		}
+	}
+	return s
+}
+
+// 按时区计算下次执行时间
+type tzSchedule struct {
+	cron.Schedule
+	loc *time.Location
+}
+
+func (s tzSchedule) Next(t time.Time) time.Time {
+	return s.Schedule.Next(t.In(s.loc))
+}
+
+// AtSchedule 是一次性的计划，到期执行后Next返回零值，不再执行。
+type AtSchedule time.Time
+
+func (s AtSchedule) Next(t time.Time) time.Time {
+	if at := time.Time(s); t.Before(at) {
+		return at
+	}
+	return time.Time{}
+}
+
+// 解析Spec开头的"TZ=Asia/Shanghai"或"CRON_TZ=Asia/Shanghai"
+func splitTZ(spec string) (string, string) {
+	spec = strings.TrimSpace(spec)
+	for _, prefix := range []string{"TZ=", "CRON_TZ="} {
+		if strings.HasPrefix(spec, prefix) {
+			fields := strings.SplitN(spec[len(prefix):], " ", 2)
+			if len(fields) == 2 {
+				return strings.TrimSpace(fields[1]), fields[0]
+			}
+			return "", fields[0]
+		}
+	}
+	return spec, ""
+}
+
+func loadLocation(tz string) (*time.Location, error) {
+	if tz == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("无效的时区: %s", tz)
+	}
+	return loc, nil
+}
+
+// 解析"at"后的时间，支持"2006-01-02 15:04[:05]"及"15:04[:05]"(今天或明天)
+func parseAt(text string, loc *time.Location, now time.Time) (time.Time, error) {
+	text = strings.TrimSpace(text)
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02T15:04:05", "2006-01-02T15:04"} {
+		if t, err := time.ParseInLocation(layout, text, loc); err == nil {
+			return t, nil
+		}
+	}
+	for _, layout := range []string{"15:04:05", "15:04"} {
+		if t, err := time.ParseInLocation(layout, text, loc); err == nil {
+			now = now.In(loc)
+			at := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), t.Second(), 0, loc)
+			if !at.After(now) {
+				at = at.AddDate(0, 0, 1)
+			}
+			return at, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("无效的时间: %s, 格式应为\"2006-01-02 15:04\"或\"15:04\"", text)
+}
+
+// 将一次性任务中的"at 15:04"转换为完整的时间，避免重新载入时计算出不同的时间
+func normalizeSpec(spec, tz string) (string, error) {
+	if !strings.HasPrefix(spec, "at ") {
+		return spec, nil
+	}
+	loc, err := loadLocation(tz)
+	if err != nil {
+		return "", err
+	}
+	t, err := parseAt(spec[len("at "):], loc, time.Now())
+	if err != nil {
+		return "", err
+	}
+	if !t.After(time.Now()) {
+		return "", fmt.Errorf("时间已过: %s", t.Format("2006-01-02 15:04:05"))
+	}
+	return "at " + t.Format("2006-01-02 15:04:05"), nil
+}
+
+// 解析计划任务的Spec, cron在格式错误时会panic, 这里转换为错误返回。
+func parseSchedule(spec, tz string) (sched cron.Schedule, err error) {
+	loc, err := loadLocation(tz)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(spec, "at ") {
+		t, err := parseAt(spec[len("at "):], loc, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		return AtSchedule(t), nil
+	}
+	if !strings.HasPrefix(spec, "@") && len(strings.Fields(spec)) != 6 {
+		return nil, fmt.Errorf("Spec应为6个字段(Seconds Minutes Hours DayofMonth Month DayofWeek)或@daily、@every 1h等描述符: %s", spec)
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			sched, err = nil, fmt.Errorf("%v", e)
+		}
+	}()
+	return tzSchedule{cron.Parse(spec), loc}, nil
+}
+
+func (m *Admin) getTask(id string) (CronTask, bool) {
+	m.cronLock.Lock()
+	defer m.cronLock.Unlock()
+	n, err := strconv.Atoi(strings.TrimPrefix(id, "#"))
+	if err != nil {
+		return CronTask{}, false
+	}
+	t, ok := m.tasks[n]
+	return t, ok
+}
+
+// 分配新的任务ID
+func (m *Admin) newTaskID() int {
+	m.cronLock.Lock()
+	defer m.cronLock.Unlock()
+	m.taskSeq++
+	return m.taskSeq
+}
+
+func (m *Admin) putTask(t CronTask) {
+	m.cronLock.Lock()
+	defer m.cronLock.Unlock()
+	m.tasks[t.ID] = t
+	m.saveTasks()
+}
+
+func (m *Admin) delTask(id int) {
+	m.cronLock.Lock()
+	defer m.cronLock.Unlock()
+	delete(m.tasks, id)
+	m.saveTasks()
+}
+
+// 需持有cronLock
+func (m *Admin) saveTasks() {
+	tasks := []CronTask{}
+	for _, t := range m.tasks {
+		tasks = append(tasks, t)
+	}
+	m.saveState("tasks", tasks)
+	m.saveState("task_seq", m.taskSeq)
+}
+
+func (m *Admin) sortedTasks() []CronTask {
+	m.cronLock.Lock()
+	defer m.cronLock.Unlock()
+	var tasks []CronTask
+	for _, t := range m.tasks {
+		tasks = append(tasks, t)
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].ID < tasks[j].ID })
+	return tasks
+}
+
+// 载入计划任务，并转换旧版本保存的任务
+func (m *Admin) loadTasks() {
+	store := m.bot.GetStore()
+	var tasks []CronTask
+	if ok, err := store.Get(m.Name, "tasks", &tasks); ok {
+		store.Get(m.Name, "task_seq", &m.taskSeq)
+	} else if err != nil {
+		fmt.Printf("[%s] Load tasks error: %v\n", m.Name, err)
+	}
+	m.tasks = map[int]CronTask{}
+	for _, t := range tasks {
+		m.tasks[t.ID] = t
+		if t.ID > m.taskSeq {
+			m.taskSeq = t.ID
+		}
+	}
+
+	crons := map[string]CronEntry{}
+	if ok, err := store.Get(m.Name, "crons", &crons); ok {
+		keys := make([]string, 0, len(crons))
+		for k := range crons {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, id := range keys {
+			c := crons[id]
+			m.putTask(CronTask{ID: m.newTaskID(), Spec: c.Spec, To: c.To, Text: c.Text, Created: time.Now()})
+		}
+		store.Delete(m.Name, "crons")
+	} else if err != nil {
+		fmt.Printf("[%s] Load crons error: %v\n", m.Name, err)
+	}
+
+	for _, t := range m.sortedTasks() {
+		if t.Paused {
+			continue
+		}
+		if err := m.scheduleTask(t); err != nil {
+			fmt.Printf("[%s] Load task #%d error: %v\n", m.Name, t.ID, err)
+		}
+	}
+}
+
+// 将任务加入cron, 已存在时替换
+func (m *Admin) scheduleTask(t CronTask) error {
+	sched, err := parseSchedule(t.Spec, t.TZ)
+	if err != nil {
+		return err
+	}
+	if at, ok := sched.(AtSchedule); ok && !time.Now().Before(time.Time(at)) {
+		sched = AtSchedule(time.Now().Add(missedTaskDelay))
+	}
+	c := m.bot.GetCron()
+	c.RemoveJob(t.name())
+	c.Schedule(sched, cron.FuncJob(func() { m.runTask(t.ID) }), t.name())
+	return nil
+}
+
+// 执行任务，一次性任务执行后删除
+func (m *Admin) runTask(id int) {
+	m.cronLock.Lock()
+	t, ok := m.tasks[id]
+	m.cronLock.Unlock()
+	if !ok {
+		return
+	}
+	text := t.Text
+	if t.Command {
+		out, err := m.bot.ExecCommand(t.Owner+"/"+t.name(), t.Text)
+		if err != nil {
+			m.bot.SendAuto(t.Owner, fmt.Sprintf("计划任务 #%d 执行失败: %v", t.ID, err))
+			out = ""
+		}
+		text = out
+	}
+	if text != "" {
+		if m.IsRoomID(t.To) {
+			m.bot.SendPub(t.To, text)
+		} else {
+			m.bot.SendAuto(t.To, text)
+		}
+	}
+	if t.oneShot() {
+		m.bot.GetCron().RemoveJob(t.name())
+		m.delTask(t.ID)
+	}
+}
+
+/* cron 命令处理 */
+func (m *Admin) cron_list(msg xmpp.Chat, args *Args) {
+	names := []string{"==所有计划任务列表=="}
+	for _, t := range m.sortedTasks() {
+		names = append(names, t.String())
+	}
+	m.bot.ReplyAuto(msg, strings.Join(names, "\n"))
+}
+
+func (m *Admin) cron_add(msg xmpp.Chat, args *Args) {
+	jid, _, _ := m.sender(msg)
+	m.addTask(msg, args, CronTask{To: args.String("jid"), Text: args.String("Message"), Owner: jid})
+}
+
+func (m *Admin) cron_add_cmd(msg xmpp.Chat, args *Args) {
+	jid, _, _ := m.sender(msg)
+	if jid == "" {
+		m.reply(msg, args, "添加新任务失败: 无法获取您的真实jid, 请通过私聊添加")
+		return
+	}
+	text := args.String("Command")
+	if !m.IsCmd(text) {
+		text = m.GetCmdString(text)
+	}
+	fields := strings.Fields(text[len(m.GetCmdString("")):])
+	if len(fields) == 0 || m.bot.commandOwner(fields[0]) == "" {
+		m.reply(msg, args, "添加新任务失败: 不支持的命令: "+text)
+		return
+	}
+	m.addTask(msg, args, CronTask{To: args.String("jid"), Text: text, Command: true, Owner: jid})
+}
+
+func (m *Admin) addTask(msg xmpp.Chat, args *Args, t CronTask) {
+	spec, tz := splitTZ(args.String("Spec"))
+	t.TZ, t.Created = tz, time.Now()
+	var err error
+	if t.Spec, err = normalizeSpec(spec, tz); err == nil {
+		_, err = parseSchedule(t.Spec, t.TZ)
+	}
+	if err != nil {
+		m.reply(msg, args, "添加新任务失败: "+err.Error())
+		return
+	}
+	t.ID = m.newTaskID()
+	m.scheduleTask(t)
+	m.putTask(t)
+	m.reply(msg, args, "已添加计划任务 "+t.String())
+}
+
+func (m *Admin) cron_del(msg xmpp.Chat, args *Args) {
+	t, ok := m.getTask(args.String("ID"))
+	if !ok {
+		m.reply(msg, args, "没有此计划任务: "+args.String("ID"))
+		return
+	}
+	m.bot.GetCron().RemoveJob(t.name())
+	m.delTask(t.ID)
+	m.reply(msg, args, fmt.Sprintf("已删除计划任务 #%d", t.ID))
+}
+
+func (m *Admin) cron_pause(msg xmpp.Chat, args *Args) {
+	t, ok := m.getTask(args.String("ID"))
+	if !ok {
+		m.reply(msg, args, "没有此计划任务: "+args.String("ID"))
+		return
+	}
+	m.bot.GetCron().RemoveJob(t.name())
+	t.Paused = true
+	m.putTask(t)
+	m.reply(msg, args, fmt.Sprintf("已暂停计划任务 #%d", t.ID))
+}
+
+func (m *Admin) cron_resume(msg xmpp.Chat, args *Args) {
+	t, ok := m.getTask(args.String("ID"))
+	if !ok {
+		m.reply(msg, args, "没有此计划任务: "+args.String("ID"))
+		return
+	}
+	t.Paused = false
+	if err := m.scheduleTask(t); err != nil {
+		m.reply(msg, args, "恢复计划任务失败: "+err.Error())
+		return
+	}
+	m.putTask(t)
+	m.reply(msg, args, "已恢复计划任务 "+t.String())
+}
+
+func (m *Admin) cron_edit(msg xmpp.Chat, args *Args) {
+	t, ok := m.getTask(args.String("ID"))
+	if !ok {
+		m.reply(msg, args, "没有此计划任务: "+args.String("ID"))
+		return
+	}
+	value := args.String("value")
+	switch args.String("field") {
+	case "spec":
+		spec, tz := splitTZ(value)
+		t.Spec = spec
+		if tz != "" {
+			t.TZ = tz
+		}
+	case "tz":
+		t.TZ = value
+		if value == "local" {
+			t.TZ = ""
+		}
+	case "to":
+		if !strings.Contains(value, "@") {
+			m.reply(msg, args, "参数to应为jid: "+value)
+			return
+		}
+		t.To = value
+	case "text":
+		t.Text = value
+	default:
+		m.reply(msg, args, "不支持修改"+args.String("field")+", 可以修改的字段为: spec, tz, to, text")
+		return
+	}
+	var err error
+	if t.Spec, err = normalizeSpec(t.Spec, t.TZ); err == nil {
+		_, err = parseSchedule(t.Spec, t.TZ)
+	}
+	if err != nil {
+		m.reply(msg, args, "修改计划任务失败: "+err.Error())
+		return
+	}
+	if !t.Paused {
+		m.scheduleTask(t)
+	}
+	m.putTask(t)
+	m.reply(msg, args, "已修改计划任务 "+t.String())
+}
+
+func (m *Admin) cron_run_now(msg xmpp.Chat, args *Args) {
+	t, ok := m.getTask(args.String("ID"))
+	if !ok {
+		m.reply(msg, args, "没有此计划任务: "+args.String("ID"))
+		return
+	}
+	m.runTask(t.ID)
+	m.reply(msg, args, fmt.Sprintf("已执行计划任务 #%d", t.ID))
+}
+
+// 预览任务或Spec接下来n次的执行时间
+func (m *Admin) cron_next(msg xmpp.Chat, args *Args) {
+	spec, tz := splitTZ(args.String("ID|Spec"))
+	if t, ok := m.getTask(spec); ok {
+		spec, tz = t.Spec, t.TZ
+	}
+	sched, err := parseSchedule(spec, tz)
+	if err != nil {
+		m.bot.ReplyAuto(msg, err.Error())
+		return
+	}
+	n := DefaultCronPreview
+	if args.Has("n") {
+		n = args.Int("n")
+	}
+	txt := []string{"==" + spec + " 接下来的执行时间=="}
+	next := time.Now()
+	for i := 0; i < n && i < 100; i++ {
+		if next = sched.Next(next); next.IsZero() {
+			break
+		}
+		txt = append(txt, next.Format("2006-01-02 15:04:05 MST Mon"))
+	}
+	m.bot.ReplyAuto(msg, strings.Join(txt, "\n"))
+}
@@ -0,0 +1,240 @@
+package robot
+
+import (
+	"github.com/mattn/go-xmpp"
+	"github.com/yetist/xmppbot/utils"
+	"hash/fnv"
+	"log"
+	"sync"
+)
+
+const (
+	DefaultQueueSize    = 100
+	DefaultQueueWorkers = 1
+	QueueDrop           = "drop"  // 队列满时丢弃新消息
+	QueueBlock          = "block" // 队列满时等待，暂停接收消息
+)
+
+// QueueStats 是模块消息队列的统计。
+type QueueStats struct {
+	Depth     int // 队列中等待处理的消息数
+	Size      int // 队列容量(所有worker之和)
+	Workers   int
+	Processed int
+	Dropped   int
+}
+
+// 每个模块一个消息队列，按会话分配到固定的worker, 使同一会话中的消息按顺序处理。
+type pluginQueue struct {
+	bot     *Bot
+	plugin  PluginIface
+	policy  string
+	chans   []chan interface{}
+	quit    chan bool // 停用模块时关闭，丢弃未处理的消息
+	done    chan bool // 退出时关闭，处理完队列中的消息后结束
+	wg      sync.WaitGroup
+	lock    sync.Mutex
+	stopped bool
+	stats   QueueStats
+}
+
+func newPluginQueue(bot *Bot, plugin PluginIface, size, workers int, policy string) *pluginQueue {
+	q := &pluginQueue{
+		bot:    bot,
+		plugin: plugin,
+		policy: policy,
+		quit:   make(chan bool),
+		done:   make(chan bool),
+		stats:  QueueStats{Size: size * workers, Workers: workers},
+	}
+	for i := 0; i < workers; i++ {
+		ch := make(chan interface{}, size)
+		q.chans = append(q.chans, ch)
+		q.wg.Add(1)
+		go q.work(ch)
+	}
+	return q
+}
+
+func (q *pluginQueue) work(ch chan interface{}) {
+	defer q.wg.Done()
+	for {
+		select {
+		case stanza := <-ch:
+			q.handle(stanza)
+		case <-q.quit:
+			return
+		case <-q.done:
+			for {
+				select {
+				case stanza := <-ch:
+					q.handle(stanza)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (q *pluginQueue) handle(stanza interface{}) {
+	switch v := stanza.(type) {
+	case xmpp.Chat:
+		q.bot.callPlugin(q.plugin, func() { q.plugin.Chat(v) })
+	case xmpp.Presence:
+		q.bot.callPlugin(q.plugin, func() { q.plugin.Presence(v) })
+	}
+	q.lock.Lock()
+	q.stats.Processed++
+	q.lock.Unlock()
+}
+
+// 将消息放入所属会话的worker队列，队列满时按policy丢弃或等待。
+func (q *pluginQueue) push(stanza interface{}) {
+	h := fnv.New32a()
+	h.Write([]byte(conversation(stanza)))
+	ch := q.chans[h.Sum32()%uint32(len(q.chans))]
+
+	select {
+	case ch <- stanza:
+		return
+	default:
+	}
+	if q.policy == QueueBlock {
+		select {
+		case ch <- stanza:
+		case <-q.quit:
+		case <-q.done:
+		}
+		return
+	}
+	q.lock.Lock()
+	q.stats.Dropped++
+	dropped := q.stats.Dropped
+	q.lock.Unlock()
+	if dropped%100 == 1 {
+		log.Printf("plugin %s queue is full, %d messages dropped", q.plugin.GetName(), dropped)
+	}
+}
+
+// 停用模块，丢弃未处理的消息
+func (q *pluginQueue) stop() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if !q.stopped {
+		q.stopped = true
+		close(q.quit)
+	}
+}
+
+// 处理完队列中的消息后结束
+func (q *pluginQueue) finish() {
+	q.lock.Lock()
+	if !q.stopped {
+		q.stopped = true
+		close(q.done)
+	}
+	q.lock.Unlock()
+	q.wg.Wait()
+}
+
+func (q *pluginQueue) Stats() QueueStats {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	s := q.stats
+	for _, ch := range q.chans {
+		s.Depth += len(ch)
+	}
+	return s
+}
+
+// 消息所属的会话: 聊天室消息为聊天室jid, 其它为对方的jid
+func conversation(stanza interface{}) string {
+	var jid string
+	switch v := stanza.(type) {
+	case xmpp.Chat:
+		jid = v.Remote
+	case xmpp.Presence:
+		jid = v.From
+	}
+	id, _ := utils.SplitJID(jid)
+	return id
+}
+
+// 为模块创建消息队列，管理员模块的命令不能丢弃，总是使用block.
+func (b *Bot) startQueue(plugin PluginIface) {
+	opt := b.GetConfig().Setup.Queue
+	size, workers, policy := opt.Size, opt.Workers, opt.Policy
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+	if workers <= 0 {
+		workers = DefaultQueueWorkers
+	}
+	if _, ok := plugin.(*Admin); ok {
+		policy = QueueBlock
+	} else if policy != QueueBlock {
+		policy = QueueDrop
+	}
+	q := newPluginQueue(b, plugin, size, workers, policy)
+	b.lock.Lock()
+	if b.queues == nil {
+		b.queues = map[string]*pluginQueue{}
+	}
+	if old, ok := b.queues[plugin.GetName()]; ok {
+		old.stop()
+	}
+	b.queues[plugin.GetName()] = q
+	b.lock.Unlock()
+}
+
+func (b *Bot) stopQueue(name string) {
+	b.lock.Lock()
+	q, ok := b.queues[name]
+	delete(b.queues, name)
+	b.lock.Unlock()
+	if ok {
+		q.stop()
+	}
+}
+
+// 等待所有队列中的消息处理完毕
+func (b *Bot) finishQueues() {
+	b.lock.RLock()
+	var queues []*pluginQueue
+	for _, q := range b.queues {
+		queues = append(queues, q)
+	}
+	b.lock.RUnlock()
+	for _, q := range queues {
+		q.finish()
+	}
+}
+
+// 将收到的消息放入各模块的队列
+func (b *Bot) dispatch(stanza interface{}) {
+	switch stanza.(type) {
+	case xmpp.Chat, xmpp.Presence:
+	default:
+		return
+	}
+	for _, v := range b.GetPlugins() {
+		b.lock.RLock()
+		q, ok := b.queues[v.GetName()]
+		b.lock.RUnlock()
+		if ok {
+			q.push(stanza)
+		}
+	}
+}
+
+// GetQueueStats 返回模块消息队列的统计。
+func (b *Bot) GetQueueStats(name string) QueueStats {
+	b.lock.RLock()
+	q, ok := b.queues[name]
+	b.lock.RUnlock()
+	if ok {
+		return q.Stats()
+	}
+	return QueueStats{}
+}
@@ -0,0 +1,104 @@
+package robot
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mattn/go-xmpp"
+	"log"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+var errPermDenied = errors.New("没有权限")
+
+// ExecCommand等待异步执行的命令完成的最长时间
+const execAsyncTimeout = 5 * time.Minute
+
+// 查找注册了顶级命令group的模块
+func (b *Bot) commandOwner(group string) string {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	for owner, cmds := range b.commands {
+		for _, v := range cmds {
+			if strings.Fields(v.Name)[0] == group {
+				return owner
+			}
+		}
+	}
+	return ""
+}
+
+// ExecCommand 以from(如"user@example.com/task-1")的身份执行命令text, 如同from通过私聊发出，
+// 返回命令发给from的回复。命令不存在、没有权限、参数错误或命令处理函数出错时返回错误，
+// 此时命令的回复作为错误信息。
+func (b *Bot) ExecCommand(from, text string) (out string, err error) {
+	text = strings.TrimSpace(text)
+	if !b.IsCmd(text) {
+		return "", fmt.Errorf("不是命令: %s", text)
+	}
+	fields := strings.Fields(text[len(b.GetCmdString("")):])
+	if len(fields) == 0 {
+		return "", fmt.Errorf("不是命令: %s", text)
+	}
+	owner := b.commandOwner(fields[0])
+	if owner == "" {
+		return "", fmt.Errorf("不支持的命令: %s", fields[0])
+	}
+	if !b.startCapture(from) {
+		return "", fmt.Errorf("%s正在执行命令", from)
+	}
+	defer func() {
+		out = strings.Join(b.stopCapture(from), "\n")
+		if e := recover(); e != nil {
+			log.Printf("exec command %q as %s panic: %v\n%s", text, from, e, debug.Stack())
+			err = fmt.Errorf("panic: %v", e)
+		} else if err != nil && out != "" {
+			// 回复中已包含错误原因及用法
+			err = errors.New(out)
+		}
+	}()
+	_, finished, err := b.runCommand(owner, xmpp.Chat{Remote: from, Type: "chat", Text: text})
+	if finished != nil {
+		// 命令在其它goroutine中继续执行，如--room kick等待服务器响应
+		select {
+		case <-finished:
+		case <-time.After(execAsyncTimeout):
+			err = errors.New("等待命令完成超时")
+		}
+	}
+	return
+}
+
+// 开始收集发给to的消息，to已在收集中时返回false
+func (b *Bot) startCapture(to string) bool {
+	b.execLock.Lock()
+	defer b.execLock.Unlock()
+	if b.captures == nil {
+		b.captures = map[string][]string{}
+	}
+	if _, ok := b.captures[to]; ok {
+		return false
+	}
+	b.captures[to] = []string{}
+	return true
+}
+
+func (b *Bot) stopCapture(to string) []string {
+	b.execLock.Lock()
+	defer b.execLock.Unlock()
+	lines := b.captures[to]
+	delete(b.captures, to)
+	return lines
+}
+
+// 发给to的消息正在被收集时，保存消息并返回true
+func (b *Bot) capture(to, text string) bool {
+	b.execLock.Lock()
+	defer b.execLock.Unlock()
+	lines, ok := b.captures[to]
+	if ok {
+		b.captures[to] = append(lines, text)
+	}
+	return ok
+}
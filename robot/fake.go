@@ -0,0 +1,164 @@
+package robot
+
+import (
+	"errors"
+	"github.com/mattn/go-xmpp"
+	"strings"
+	"sync"
+)
+
+var ErrTransportClosed = errors.New("transport closed")
+
+// FakeTransport 是不连接服务器的Transport, 它记录所有发出的消息，
+// 并可以通过Inject模拟收到xmpp.Chat/xmpp.Presence, 用于测试。
+type FakeTransport struct {
+	lock    sync.Mutex
+	Chats   []xmpp.Chat // Send发出的消息
+	Orgs    []string    // SendOrg发出的原始xml
+	Calls   []string    // 其它调用，如"join room@conference.example.org/nick"
+	Rooms   map[string]string
+	incomes chan interface{}
+	done    chan struct{} // Close时关闭
+	closed  bool
+}
+
+func NewFakeTransport() *FakeTransport {
+	return &FakeTransport{
+		Rooms:   map[string]string{},
+		incomes: make(chan interface{}, 64),
+		done:    make(chan struct{}),
+	}
+}
+
+// Inject 模拟收到一条消息，消息将由Recv返回。缓冲区已满时等待Recv, 不持有锁，
+// 以免阻塞其它方法。
+func (t *FakeTransport) Inject(stanza interface{}) {
+	select {
+	case t.incomes <- stanza:
+	case <-t.done:
+	}
+}
+
+// Sent 返回所有发给to的消息内容。
+func (t *FakeTransport) Sent(to string) []string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	var texts []string
+	for _, v := range t.Chats {
+		if v.Remote == to {
+			texts = append(texts, v.Text)
+		}
+	}
+	return texts
+}
+
+// Last 返回最后发出的一条消息。
+func (t *FakeTransport) Last() (chat xmpp.Chat, ok bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if len(t.Chats) == 0 {
+		return
+	}
+	return t.Chats[len(t.Chats)-1], true
+}
+
+// Reset 清除所有记录。
+func (t *FakeTransport) Reset() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.Chats = nil
+	t.Orgs = nil
+	t.Calls = nil
+}
+
+func (t *FakeTransport) record(call ...string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.Calls = append(t.Calls, strings.Join(call, " "))
+}
+
+func (t *FakeTransport) Send(chat xmpp.Chat) (n int, err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.closed {
+		return 0, ErrTransportClosed
+	}
+	t.Chats = append(t.Chats, chat)
+	return len(chat.Text), nil
+}
+
+func (t *FakeTransport) SendOrg(org string) (n int, err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.closed {
+		return 0, ErrTransportClosed
+	}
+	t.Orgs = append(t.Orgs, org)
+	return len(org), nil
+}
+
+func (t *FakeTransport) Recv() (stanza interface{}, err error) {
+	select {
+	case stanza = <-t.incomes:
+		return stanza, nil
+	case <-t.done:
+		return nil, ErrTransportClosed
+	}
+}
+
+func (t *FakeTransport) JoinMUC(jid, nick string) {
+	t.record("join", jid+"/"+nick)
+	t.lock.Lock()
+	t.Rooms[jid] = nick
+	t.lock.Unlock()
+}
+
+func (t *FakeTransport) JoinProtectedMUC(jid, nick, password string) {
+	t.record("join", jid+"/"+nick, password)
+	t.lock.Lock()
+	t.Rooms[jid] = nick
+	t.lock.Unlock()
+}
+
+func (t *FakeTransport) LeaveMUC(jid string) {
+	t.record("leave", jid)
+	t.lock.Lock()
+	delete(t.Rooms, jid)
+	t.lock.Unlock()
+}
+
+func (t *FakeTransport) InviteToMUC(from, nick, to, roomid, password, reason string) {
+	t.record("invite", to, roomid, reason)
+}
+
+func (t *FakeTransport) Roster() error {
+	t.record("roster")
+	return nil
+}
+
+func (t *FakeTransport) ApproveSubscription(jid string) {
+	t.record("approve", jid)
+}
+
+func (t *FakeTransport) RevokeSubscription(jid string) {
+	t.record("revoke", jid)
+}
+
+func (t *FakeTransport) RequestSubscription(jid string) {
+	t.record("request", jid)
+}
+
+func (t *FakeTransport) PingC2S(jid, server string) error {
+	t.record("ping", server)
+	return nil
+}
+
+func (t *FakeTransport) Close() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if !t.closed {
+		t.closed = true
+		close(t.done)
+	}
+	return nil
+}
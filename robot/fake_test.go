@@ -0,0 +1,35 @@
+package robot
+
+import (
+	"github.com/mattn/go-xmpp"
+	"testing"
+	"time"
+)
+
+// 缓冲区已满时Inject等待Recv, 但不能阻塞其它方法
+func TestFakeTransportInjectFull(t *testing.T) {
+	fake := NewFakeTransport()
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			fake.Inject(xmpp.Chat{Remote: "u@example.org", Text: "hi"})
+		}
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	fake.Send(xmpp.Chat{Remote: "u@example.org", Text: "hello"})
+	if sent := fake.Sent("u@example.org"); len(sent) != 1 {
+		t.Fatalf("Sent = %q", sent)
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := fake.Recv(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+	fake.Close()
+	if _, err := fake.Recv(); err != ErrTransportClosed {
+		t.Fatalf("Recv after Close: %v", err)
+	}
+	fake.Inject(xmpp.Chat{}) // Close后不阻塞
+}
@@ -0,0 +1,120 @@
+package robot
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+	"time"
+)
+
+const (
+	// 未配置setup.max_failures时，模块连续出错多少次后被停用
+	DefaultMaxFailures = 3
+	// 处理时间超过此值时记录日志
+	slowPluginCall = 5 * time.Second
+)
+
+// PluginStats 记录模块处理消息的次数、耗时及出错情况。
+type PluginStats struct {
+	Calls     int
+	Errors    int
+	Failures  int // 连续出错次数，成功处理后清零
+	Total     time.Duration
+	Max       time.Duration
+	LastError string
+}
+
+// 调用模块的消息处理函数f，捕获panic并记录耗时，连续出错达到上限时停用模块并通知管理员。
+func (b *Bot) callPlugin(plugin PluginIface, f func()) {
+	start := time.Now()
+	defer func() {
+		err := recover()
+		d := time.Now().Sub(start)
+		name := plugin.GetName()
+		if err != nil {
+			log.Printf("plugin %s panic: %v\n%s", name, err, debug.Stack())
+		} else if d > slowPluginCall {
+			log.Printf("plugin %s is slow: %v", name, d)
+		}
+		if failures := b.recordCall(name, d, err); failures == b.maxFailures() {
+			b.disableFailedPlugin(plugin, failures, err)
+		}
+	}()
+	f()
+}
+
+func (b *Bot) recordCall(name string, d time.Duration, err interface{}) int {
+	b.statsLock.Lock()
+	defer b.statsLock.Unlock()
+	if b.stats == nil {
+		b.stats = map[string]*PluginStats{}
+	}
+	s, ok := b.stats[name]
+	if !ok {
+		s = &PluginStats{}
+		b.stats[name] = s
+	}
+	s.Calls++
+	s.Total += d
+	if d > s.Max {
+		s.Max = d
+	}
+	if err != nil {
+		s.Errors++
+		s.Failures++
+		s.LastError = fmt.Sprint(err)
+	} else {
+		s.Failures = 0
+	}
+	return s.Failures
+}
+
+// GetPluginStats 返回模块的处理统计。
+func (b *Bot) GetPluginStats(name string) PluginStats {
+	b.statsLock.Lock()
+	defer b.statsLock.Unlock()
+	if s, ok := b.stats[name]; ok {
+		return *s
+	}
+	return PluginStats{}
+}
+
+// 重新启用模块时清除连续出错次数
+func (b *Bot) resetFailures(name string) {
+	b.statsLock.Lock()
+	defer b.statsLock.Unlock()
+	if s, ok := b.stats[name]; ok {
+		s.Failures = 0
+	}
+}
+
+func (b *Bot) maxFailures() int {
+	if n := b.GetConfig().Setup.MaxFailures; n > 0 {
+		return n
+	}
+	return DefaultMaxFailures
+}
+
+// 停用连续出错的模块，内置的管理员模块不能停用，仅通知管理员。
+func (b *Bot) disableFailedPlugin(plugin PluginIface, failures int, err interface{}) {
+	name := plugin.GetName()
+	var text string
+	if _, ok := plugin.(*Admin); ok {
+		text = fmt.Sprintf("模块%s连续%d次出错: %v", name, failures, err)
+	} else {
+		func() {
+			defer func() {
+				if err := recover(); err != nil {
+					log.Printf("plugin %s stop panic: %v", name, err)
+				}
+			}()
+			b.DisablePlugin(name)
+		}()
+		text = fmt.Sprintf("模块%s连续%d次出错，已被停用: %v\n修复后可使用 %s enable %s 重新启用。",
+			name, failures, err, b.GetCmdString("plugin"), name)
+	}
+	log.Print(text)
+	for _, admin := range b.GetConfig().Setup.Admin {
+		b.SendAuto(admin, text)
+	}
+}
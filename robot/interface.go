@@ -2,6 +2,7 @@ package robot
 
 import (
 	"github.com/mattn/go-xmpp"
+	"github.com/yetist/xmppbot/config"
 	"time"
 )
 
@@ -14,8 +15,14 @@ type AdminIface interface {
 	GetCmdString(cmd string) string
 	LoginTime() time.Time
 	HasPerm(name string, msg xmpp.Chat) bool
+	HasCmdPerm(name string, perm int, msg xmpp.Chat) bool
 	ShowPerm(name string) string
 	SetPerm(name string, perm int)
+	Reload(old, cfg config.Config) []string
+	Actor(msg xmpp.Chat) string
+	JoinRooms()
+	SaveBlocks()
+	ChangeNick(room *Room, nick string, done func(err error))
 }
 
 type PluginIface interface {
@@ -30,5 +37,5 @@ type PluginIface interface {
 	Chat(chat xmpp.Chat)
 	Presence(pres xmpp.Presence)
 	GetOptions() map[string]string
-	SetOption(key, val string)
+	SetOption(key, val string) error
 }
@@ -0,0 +1,130 @@
+package robot
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/mattn/go-xmpp"
+	"html"
+	"strings"
+	"time"
+)
+
+// 等待iq回应的最长时间
+const iqTimeout = 30 * time.Second
+
+type iqWaiter struct {
+	f     func(iq xmpp.IQ)
+	timer *time.Timer
+}
+
+// SendIQ 向to发送iq请求，query为<iq/>中的xml内容。收到回应(result或error)时调用f,
+// 超时未收到回应时以Type为"timeout"的IQ调用f. f为nil时不等待回应。
+func (b *Bot) SendIQ(to, typ, query string, f func(iq xmpp.IQ)) error {
+	b.iqLock.Lock()
+	b.iqSeq++
+	id := fmt.Sprintf("xmppbot%d", b.iqSeq)
+	if f != nil {
+		if b.iqWaiters == nil {
+			b.iqWaiters = map[string]*iqWaiter{}
+		}
+		b.iqWaiters[id] = &iqWaiter{f: f, timer: time.AfterFunc(iqTimeout, func() {
+			if w := b.takeIQWaiter(id); w != nil {
+				w.f(xmpp.IQ{ID: id, From: to, Type: "timeout"})
+			}
+		})}
+	}
+	b.iqLock.Unlock()
+
+	org := fmt.Sprintf("<iq to='%s' type='%s' id='%s'>%s</iq>", html.EscapeString(to), typ, id, query)
+	if _, err := b.conn().SendOrg(org); err != nil {
+		b.takeIQWaiter(id)
+		return err
+	}
+	return nil
+}
+
+func (b *Bot) takeIQWaiter(id string) *iqWaiter {
+	b.iqLock.Lock()
+	defer b.iqLock.Unlock()
+	w, ok := b.iqWaiters[id]
+	if !ok {
+		return nil
+	}
+	delete(b.iqWaiters, id)
+	w.timer.Stop()
+	return w
+}
+
+// 收到iq回应时调用对应的处理函数
+func (b *Bot) handleIQ(iq xmpp.IQ) {
+	if iq.Type != "result" && iq.Type != "error" {
+		return
+	}
+	if w := b.takeIQWaiter(iq.ID); w != nil {
+		go w.f(iq)
+	}
+}
+
+// SendIQWait 发送iq请求并等待回应，收到error或超时时返回错误。
+func (b *Bot) SendIQWait(to, typ, query string) (xmpp.IQ, error) {
+	ch := make(chan xmpp.IQ, 1)
+	if err := b.SendIQ(to, typ, query, func(iq xmpp.IQ) { ch <- iq }); err != nil {
+		return xmpp.IQ{}, err
+	}
+	iq := <-ch
+	if iq.Type != "result" {
+		return iq, IQError(iq)
+	}
+	return iq, nil
+}
+
+// 常见的错误条件，见RFC 6120 8.3.3
+var iqConditions = map[string]string{
+	"bad-request":             "请求格式错误",
+	"conflict":                "与现有状态冲突",
+	"feature-not-implemented": "服务器不支持此功能",
+	"forbidden":               "没有权限",
+	"item-not-found":          "找不到对象",
+	"jid-malformed":           "jid格式错误",
+	"not-acceptable":          "请求不被接受",
+	"not-allowed":             "不允许此操作",
+	"not-authorized":          "未授权",
+	"remote-server-not-found": "找不到服务器",
+	"service-unavailable":     "服务不可用",
+}
+
+// IQError 返回type为error或timeout的iq回应中的错误原因。
+func IQError(iq xmpp.IQ) error {
+	if iq.Type == "timeout" {
+		return errors.New("服务器无响应")
+	}
+	var v struct {
+		Error struct {
+			Items []struct {
+				XMLName xml.Name
+				Text    string `xml:",chardata"`
+			} `xml:",any"`
+		} `xml:"error"`
+	}
+	if err := xml.Unmarshal([]byte("<iq>"+string(iq.Query)+"</iq>"), &v); err != nil {
+		return errors.New("未知错误")
+	}
+	var cond, text string
+	for _, item := range v.Error.Items {
+		if item.XMLName.Local == "text" {
+			text = strings.TrimSpace(item.Text)
+		} else if cond == "" {
+			cond = item.XMLName.Local
+		}
+	}
+	if s, ok := iqConditions[cond]; ok {
+		cond = s
+	} else if cond == "" {
+		cond = "未知错误"
+	}
+	if text != "" {
+		return fmt.Errorf("%s: %s", cond, text)
+	}
+	return errors.New(cond)
+}
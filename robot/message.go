@@ -0,0 +1,284 @@
+package robot
+
+import (
+	"html"
+	"net/url"
+	"strings"
+)
+
+const (
+	nodeText = iota
+	nodeBold
+	nodeItalic
+	nodeStrike
+	nodeCode
+	nodeCodeBlock
+	nodeLink
+	nodeImage
+	nodeBreak
+	nodeList
+)
+
+type msgNode struct {
+	kind  int
+	text  string
+	url   string
+	items []*Message
+}
+
+// Message 用于构造富文本消息。
+//
+// 发送时生成三种格式: 经过过滤的XHTML-IM(XEP-0071)，使用XEP-0393样式的消息正文，
+// 以及不带任何标记的纯文本(用于日志及ExecCommand等)。
+//
+//	m := robot.NewMessage().Text("标题是").Link(title, url).Line().Image(src, "图片")
+//	bot.ReplyPubMsg(msg, m)
+type Message struct {
+	nodes []msgNode
+}
+
+func NewMessage() *Message {
+	return &Message{}
+}
+
+func (m *Message) add(n msgNode) *Message {
+	m.nodes = append(m.nodes, n)
+	return m
+}
+
+// Text 添加普通文本，其中的换行会保留。
+func (m *Message) Text(text string) *Message {
+	return m.add(msgNode{kind: nodeText, text: text})
+}
+
+func (m *Message) Bold(text string) *Message {
+	return m.add(msgNode{kind: nodeBold, text: text})
+}
+
+func (m *Message) Italic(text string) *Message {
+	return m.add(msgNode{kind: nodeItalic, text: text})
+}
+
+func (m *Message) Strike(text string) *Message {
+	return m.add(msgNode{kind: nodeStrike, text: text})
+}
+
+// Code 添加行内代码。
+func (m *Message) Code(text string) *Message {
+	return m.add(msgNode{kind: nodeCode, text: text})
+}
+
+// CodeBlock 添加独占多行的代码块。
+func (m *Message) CodeBlock(text string) *Message {
+	return m.add(msgNode{kind: nodeCodeBlock, text: strings.TrimRight(text, "\n")})
+}
+
+// Link 添加链接，text为空时显示链接地址。不安全的链接(如javascript:)只显示文本。
+func (m *Message) Link(text, href string) *Message {
+	if text == "" {
+		text = href
+	}
+	return m.add(msgNode{kind: nodeLink, text: text, url: href})
+}
+
+// Image 添加图片，src可以是http(s)地址或data:image/...
+func (m *Message) Image(src, alt string) *Message {
+	return m.add(msgNode{kind: nodeImage, text: alt, url: src})
+}
+
+// Line 换行。
+func (m *Message) Line() *Message {
+	return m.add(msgNode{kind: nodeBreak})
+}
+
+// List 添加无序列表，每一项是一个Message.
+func (m *Message) List(items ...*Message) *Message {
+	return m.add(msgNode{kind: nodeList, items: items})
+}
+
+// Append 将other的内容添加到m之后。
+func (m *Message) Append(other *Message) *Message {
+	if other != nil {
+		m.nodes = append(m.nodes, other.nodes...)
+	}
+	return m
+}
+
+// IsRich 返回消息中是否包含文本以外的内容，只有这样的消息才需要XHTML-IM.
+func (m *Message) IsRich() bool {
+	for _, n := range m.nodes {
+		if n.kind != nodeText && n.kind != nodeBreak {
+			return true
+		}
+	}
+	return false
+}
+
+// 允许出现在消息中的链接
+func safeURL(href string, image bool) bool {
+	u, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		return u.Host != ""
+	case "xmpp", "mailto", "ftp":
+		return !image
+	case "data":
+		return image && strings.HasPrefix(strings.ToLower(u.Opaque), "image/")
+	}
+	return false
+}
+
+// 文本中的换行转为<br/>
+func escapeLines(text string) string {
+	return strings.Replace(html.EscapeString(text), "\n", "<br/>", -1)
+}
+
+// 等宽字体，XEP-0071推荐的元素中没有<code>和<pre>
+const monospace = "<span style='font-family: monospace'>"
+
+// 代码中的空格不能合并
+func escapeCode(text string) string {
+	return strings.Replace(escapeLines(text), " ", "&#160;", -1)
+}
+
+// HTML 返回XHTML-IM的body内容，所有文本都已转义，只使用XEP-0071推荐的元素。
+func (m *Message) HTML() string {
+	var buf []string
+	for _, n := range m.nodes {
+		switch n.kind {
+		case nodeText:
+			buf = append(buf, escapeLines(n.text))
+		case nodeBold:
+			buf = append(buf, "<strong>"+escapeLines(n.text)+"</strong>")
+		case nodeItalic:
+			buf = append(buf, "<em>"+escapeLines(n.text)+"</em>")
+		case nodeStrike:
+			buf = append(buf, "<span style='text-decoration: line-through'>"+escapeLines(n.text)+"</span>")
+		case nodeCode:
+			buf = append(buf, monospace+escapeCode(n.text)+"</span>")
+		case nodeCodeBlock:
+			buf = append(buf, "<p>"+monospace+escapeCode(n.text)+"</span></p>")
+		case nodeLink:
+			if safeURL(n.url, false) {
+				buf = append(buf, "<a href='"+html.EscapeString(n.url)+"'>"+escapeLines(n.text)+"</a>")
+			} else {
+				buf = append(buf, escapeLines(n.text))
+			}
+		case nodeImage:
+			if safeURL(n.url, true) {
+				buf = append(buf, "<img alt='"+html.EscapeString(n.text)+"' src='"+html.EscapeString(n.url)+"'/>")
+			} else {
+				buf = append(buf, escapeLines(n.text))
+			}
+		case nodeBreak:
+			buf = append(buf, "<br/>")
+		case nodeList:
+			var items []string
+			for _, v := range n.items {
+				items = append(items, "<li>"+v.HTML()+"</li>")
+			}
+			buf = append(buf, "<ul>"+strings.Join(items, "")+"</ul>")
+		}
+	}
+	return strings.Join(buf, "")
+}
+
+// 给每一行加上XEP-0393的样式标记，行首尾的空白放在标记之外
+func styleSpan(text, mark string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		s := strings.TrimSpace(line)
+		if s == "" {
+			continue
+		}
+		start := strings.Index(line, s)
+		lines[i] = line[:start] + mark + s + mark + line[start+len(s):]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// 纯文本渲染，styled为true时使用XEP-0393样式
+type textWriter struct {
+	buf    []string
+	styled bool
+}
+
+func (w *textWriter) write(s string) {
+	w.buf = append(w.buf, s)
+}
+
+func (w *textWriter) String() string {
+	return strings.Join(w.buf, "")
+}
+
+// 块元素需要从新的一行开始
+func (w *textWriter) newline() {
+	if s := w.String(); s != "" && !strings.HasSuffix(s, "\n") {
+		w.write("\n")
+	}
+}
+
+func (w *textWriter) render(m *Message) {
+	for _, n := range m.nodes {
+		switch n.kind {
+		case nodeText:
+			w.write(n.text)
+		case nodeBold, nodeItalic, nodeStrike, nodeCode:
+			if !w.styled {
+				w.write(n.text)
+				break
+			}
+			w.write(styleSpan(n.text, map[int]string{nodeBold: "*", nodeItalic: "_", nodeStrike: "~", nodeCode: "`"}[n.kind]))
+		case nodeCodeBlock:
+			w.newline()
+			if w.styled {
+				w.write("```\n" + n.text + "\n```\n")
+			} else {
+				w.write(n.text + "\n")
+			}
+		case nodeLink:
+			if n.text == n.url || !safeURL(n.url, false) {
+				w.write(n.text)
+			} else {
+				w.write(n.text + " <" + n.url + ">")
+			}
+		case nodeImage:
+			// data:地址太长，只显示说明
+			if safeURL(n.url, true) && !strings.HasPrefix(n.url, "data:") {
+				w.write("[" + n.text + "] <" + n.url + ">")
+			} else {
+				w.write("[" + n.text + "]")
+			}
+		case nodeBreak:
+			w.write("\n")
+		case nodeList:
+			w.newline()
+			for _, v := range n.items {
+				sub := &textWriter{styled: w.styled}
+				sub.render(v)
+				w.write("• " + strings.Replace(strings.TrimRight(sub.String(), "\n"), "\n", "\n  ", -1) + "\n")
+			}
+		}
+	}
+}
+
+// Styled 返回使用XEP-0393样式的文本，作为消息的body发送。
+func (m *Message) Styled() string {
+	w := &textWriter{styled: true}
+	w.render(m)
+	return strings.TrimRight(w.String(), "\n")
+}
+
+// Plain 返回不带任何标记的纯文本。
+func (m *Message) Plain() string {
+	w := &textWriter{}
+	w.render(m)
+	return strings.TrimRight(w.String(), "\n")
+}
+
+func (m *Message) String() string {
+	return m.Plain()
+}
@@ -0,0 +1,70 @@
+package robot
+
+import (
+	"testing"
+)
+
+func TestMessageRender(t *testing.T) {
+	tests := []struct {
+		name          string
+		msg           *Message
+		html          string
+		styled, plain string
+	}{
+		{"escape", NewMessage().Text("a<b & 'c'\n\"d\"").Bold("<x>"),
+			"a&lt;b &amp; &#39;c&#39;<br/>&#34;d&#34;<strong>&lt;x&gt;</strong>",
+			"a<b & 'c'\n\"d\"*<x>*", "a<b & 'c'\n\"d\"<x>"},
+		{"styles", NewMessage().Bold(" b ").Italic("i\nj").Strike("s"),
+			"<strong> b </strong><em>i<br/>j</em><span style='text-decoration: line-through'>s</span>",
+			" *b* _i_\n_j_~s~", " b i\njs"},
+		{"code", NewMessage().Text("run ").Code("a <b>").CodeBlock("if x {\n  y()\n}\n"),
+			"run <span style='font-family: monospace'>a&#160;&lt;b&gt;</span>" +
+				"<p><span style='font-family: monospace'>if&#160;x&#160;{<br/>&#160;&#160;y()<br/>}</span></p>",
+			"run `a <b>`\n```\nif x {\n  y()\n}\n```", "run a <b>\nif x {\n  y()\n}"},
+		{"link", NewMessage().Link("site", "https://example.org/?a=1&b=2"),
+			"<a href='https://example.org/?a=1&amp;b=2'>site</a>",
+			"site <https://example.org/?a=1&b=2>", "site <https://example.org/?a=1&b=2>"},
+		{"link quotes", NewMessage().Link("x", "https://example.org/'onmouseover='alert(1)\""),
+			"<a href='https://example.org/&#39;onmouseover=&#39;alert(1)&#34;'>x</a>",
+			"x <https://example.org/'onmouseover='alert(1)\">", "x <https://example.org/'onmouseover='alert(1)\">"},
+		{"javascript", NewMessage().Link("click", "javascript:alert(1)"), "click", "click", "click"},
+		{"javascript spaces", NewMessage().Link("click", " JavaScript:alert(1)"), "click", "click", "click"},
+		{"data link", NewMessage().Link("click", "data:text/html,<script>alert(1)</script>"), "click", "click", "click"},
+		{"no host", NewMessage().Link("click", "http:alert"), "click", "click", "click"},
+		{"xmpp link", NewMessage().Link("", "xmpp:r@c?join"),
+			"<a href='xmpp:r@c?join'>xmpp:r@c?join</a>", "xmpp:r@c?join", "xmpp:r@c?join"},
+		{"image", NewMessage().Image("https://example.org/a.png", "a'b"),
+			"<img alt='a&#39;b' src='https://example.org/a.png'/>",
+			"[a'b] <https://example.org/a.png>", "[a'b] <https://example.org/a.png>"},
+		{"data image", NewMessage().Image("data:image/png;base64,AAAA", "pic"),
+			"<img alt='pic' src='data:image/png;base64,AAAA'/>", "[pic]", "[pic]"},
+		{"data html image", NewMessage().Image("data:text/html,<b>x</b>", "pic"), "pic", "[pic]", "[pic]"},
+		{"mailto image", NewMessage().Image("mailto:a@example.org", "pic"), "pic", "[pic]", "[pic]"},
+		{"nested list", NewMessage().Text("list:").List(
+			NewMessage().Bold("one"),
+			NewMessage().Text("two").List(NewMessage().Text("2a"), NewMessage().Link("2b", "javascript:x")),
+		).Text("end"),
+			"list:<ul><li><strong>one</strong></li><li>two<ul><li>2a</li><li>2b</li></ul></li></ul>end",
+			"list:\n• *one*\n• two\n  • 2a\n  • 2b\nend", "list:\n• one\n• two\n  • 2a\n  • 2b\nend"},
+	}
+	for _, tt := range tests {
+		if got := tt.msg.HTML(); got != tt.html {
+			t.Errorf("%s: HTML() = %q, want %q", tt.name, got, tt.html)
+		}
+		if got := tt.msg.Styled(); got != tt.styled {
+			t.Errorf("%s: Styled() = %q, want %q", tt.name, got, tt.styled)
+		}
+		if got := tt.msg.Plain(); got != tt.plain {
+			t.Errorf("%s: Plain() = %q, want %q", tt.name, got, tt.plain)
+		}
+	}
+}
+
+func TestIsRich(t *testing.T) {
+	if NewMessage().Text("a").Line().Text("b").IsRich() {
+		t.Error("text message is rich")
+	}
+	if !NewMessage().Text("a").Code("b").IsRich() {
+		t.Error("code message is not rich")
+	}
+}
@@ -0,0 +1,232 @@
+package robot
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mattn/go-xmpp"
+	"github.com/yetist/xmppbot/utils"
+	"html"
+	"strings"
+	"time"
+)
+
+// 聊天室成员的从属关系，见XEP-0045
+var Affiliations = []string{"owner", "admin", "member", "none", "outcast"}
+
+// 发送XEP-0045的管理请求，item为<item/>的属性
+func (b *Bot) mucAdmin(roomid, item, reason string) error {
+	query := "<query xmlns='" + nsMUCAdmin + "'><item " + item
+	if reason != "" {
+		query += "><reason>" + html.EscapeString(reason) + "</reason></item></query>"
+	} else {
+		query += "/></query>"
+	}
+	_, err := b.SendIQWait(roomid, "set", query)
+	return err
+}
+
+// 检查bot在聊天室中的角色，未知时(bot不是主持人时无法查询)交给服务器判断
+func (b *Bot) checkMyRole(roomid string, affiliation bool) error {
+	room := b.GetRoom(roomid)
+	if room == nil {
+		return errors.New("Bot未进入此聊天室")
+	}
+	me, ok := room.GetOccupant(room.GetNick())
+	if !ok {
+		return nil
+	}
+	if affiliation && me.Affiliation != "" && me.Affiliation != "owner" && me.Affiliation != "admin" {
+		return errors.New("Bot不是聊天室的管理员")
+	}
+	if !affiliation && me.Role != "" && me.Role != "moderator" {
+		return errors.New("Bot不是聊天室的主持人")
+	}
+	return nil
+}
+
+// SetRole 修改聊天室成员nick的角色(moderator, participant, visitor, none), 需要bot是主持人。
+func (b *Bot) SetRole(roomid, nick, role, reason string) error {
+	if err := b.checkMyRole(roomid, false); err != nil {
+		return err
+	}
+	return b.mucAdmin(roomid, "nick='"+html.EscapeString(nick)+"' role='"+role+"'", reason)
+}
+
+// SetAffiliation 修改jid在聊天室中的从属关系(owner, admin, member, none, outcast), 需要bot是管理员。
+func (b *Bot) SetAffiliation(roomid, jid, affiliation, reason string) error {
+	if err := b.checkMyRole(roomid, true); err != nil {
+		return err
+	}
+	return b.mucAdmin(roomid, "jid='"+html.EscapeString(jid)+"' affiliation='"+affiliation+"'", reason)
+}
+
+// Kick 将nick踢出聊天室。
+func (b *Bot) Kick(roomid, nick, reason string) error {
+	return b.SetRole(roomid, nick, "none", reason)
+}
+
+// Ban 禁止jid进入聊天室。
+func (b *Bot) Ban(roomid, jid, reason string) error {
+	return b.SetAffiliation(roomid, jid, "outcast", reason)
+}
+
+// SetSubject 修改聊天室的主题，等待服务器转发新主题或返回错误，最长等待iqTimeout.
+func (b *Bot) SetSubject(roomid, subject string) error {
+	if b.GetRoom(roomid) == nil {
+		return errors.New("Bot未进入此聊天室")
+	}
+	ch := make(chan error, 1)
+	b.iqLock.Lock()
+	if _, ok := b.subjects[roomid]; ok {
+		b.iqLock.Unlock()
+		return errors.New("正在修改此聊天室的主题")
+	}
+	if b.subjects == nil {
+		b.subjects = map[string]chan error{}
+	}
+	b.subjects[roomid] = ch
+	b.iqLock.Unlock()
+	defer func() {
+		b.iqLock.Lock()
+		if b.subjects[roomid] == ch {
+			delete(b.subjects, roomid)
+		}
+		b.iqLock.Unlock()
+	}()
+
+	org := fmt.Sprintf("<message to='%s' type='groupchat'><subject>%s</subject></message>",
+		html.EscapeString(roomid), html.EscapeString(subject))
+	if _, err := b.conn().SendOrg(org); err != nil {
+		return err
+	}
+	select {
+	case err := <-ch:
+		return err
+	case <-time.After(iqTimeout):
+		return errors.New("服务器无响应")
+	}
+}
+
+// 收到修改主题的回应时通知SetSubject. go-xmpp的Chat不含<subject/>, 因此把bot自己发出的
+// 没有正文的聊天室消息当作主题的转发，把聊天室发来的error消息当作拒绝修改主题。
+func (b *Bot) handleSubject(msg xmpp.Chat) {
+	roomid, nick := utils.SplitJID(msg.Remote)
+	var err error
+	switch {
+	case msg.Type == "groupchat" && msg.Text == "" && msg.Stamp.IsZero() && nick != "":
+		if room := b.GetRoom(roomid); room == nil || !room.IsMyNick(nick) {
+			return
+		}
+	case msg.Type == "error" && nick == "":
+		err = errors.New("服务器拒绝(没有修改主题的权限)")
+	default:
+		return
+	}
+	b.iqLock.Lock()
+	ch, ok := b.subjects[roomid]
+	delete(b.subjects, roomid)
+	b.iqLock.Unlock()
+	if ok {
+		ch <- err
+	}
+}
+
+/* room 管理命令 */
+
+// Who可以是成员的昵称或jid, 昵称时查找成员的真实jid
+func (m *Admin) whoJID(room *Room, who string) (string, error) {
+	if strings.Contains(who, "@") {
+		return who, nil
+	}
+	o, ok := room.GetOccupant(who)
+	if !ok {
+		return "", fmt.Errorf("%s不在聊天室中", who)
+	}
+	if o.JID == "" {
+		return "", fmt.Errorf("无法获得%s的真实jid, 请直接指定jid", who)
+	}
+	jid, _ := utils.SplitJID(o.JID)
+	return jid, nil
+}
+
+// 对每个聊天室执行fn, 回复执行结果。等待服务器回应最长需要iqTimeout, 因此在新的goroutine中执行，不阻塞模块的worker
+func (m *Admin) moderate(msg xmpp.Chat, args *Args, action string, fn func(room *Room) error) {
+	rooms := m.findRooms(msg, args)
+	if len(rooms) == 0 {
+		return
+	}
+	done := args.Async()
+	go func() {
+		var results []string
+		for _, room := range rooms {
+			if err := fn(room); err != nil {
+				results = append(results, room.JID+": "+action+"失败, "+err.Error())
+			} else {
+				results = append(results, room.JID+": "+action+"成功")
+			}
+		}
+		text := strings.Join(results, "\n")
+		m.bot.ReplyAuto(msg, text)
+		done(text)
+	}()
+}
+
+func (m *Admin) room_kick(msg xmpp.Chat, args *Args) {
+	nick := args.String("Nick")
+	m.moderate(msg, args, "踢出"+nick, func(room *Room) error {
+		return m.bot.Kick(room.JID, nick, args.String("Reason"))
+	})
+}
+
+func (m *Admin) room_ban(msg xmpp.Chat, args *Args) {
+	who := args.String("Who")
+	m.moderate(msg, args, "禁止"+who, func(room *Room) error {
+		jid, err := m.whoJID(room, who)
+		if err != nil {
+			return err
+		}
+		return m.bot.Ban(room.JID, jid, args.String("Reason"))
+	})
+}
+
+func (m *Admin) room_unban(msg xmpp.Chat, args *Args) {
+	jid := args.String("jid")
+	m.moderate(msg, args, "解除禁止"+jid, func(room *Room) error {
+		return m.bot.SetAffiliation(room.JID, jid, "none", "")
+	})
+}
+
+func (m *Admin) room_voice(msg xmpp.Chat, args *Args) {
+	nick := args.String("Nick")
+	m.moderate(msg, args, "允许"+nick+"发言", func(room *Room) error {
+		return m.bot.SetRole(room.JID, nick, "participant", args.String("Reason"))
+	})
+}
+
+func (m *Admin) room_devoice(msg xmpp.Chat, args *Args) {
+	nick := args.String("Nick")
+	m.moderate(msg, args, "禁止"+nick+"发言", func(room *Room) error {
+		return m.bot.SetRole(room.JID, nick, "visitor", args.String("Reason"))
+	})
+}
+
+func (m *Admin) room_topic(msg xmpp.Chat, args *Args) {
+	m.moderate(msg, args, "修改主题", func(room *Room) error {
+		return m.bot.SetSubject(room.JID, args.String("Topic"))
+	})
+}
+
+func (m *Admin) room_affiliation(msg xmpp.Chat, args *Args) {
+	who, aff := args.String("Who"), args.String("Affiliation")
+	if !utils.ListContains(Affiliations, aff) {
+		m.reply(msg, args, "Affiliation应为"+strings.Join(Affiliations, ", ")+"之一")
+		return
+	}
+	m.moderate(msg, args, "设置"+who+"为"+aff, func(room *Room) error {
+		jid, err := m.whoJID(room, who)
+		if err != nil {
+			return err
+		}
+		return m.bot.SetAffiliation(room.JID, jid, aff, args.String("Reason"))
+	})
+}
@@ -0,0 +1,342 @@
+package robot
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mattn/go-xmpp"
+	"github.com/yetist/xmppbot/utils"
+	"time"
+)
+
+// 聊天室的进入状态
+const (
+	RoomJoining = iota // 正在进入
+	RoomJoined         // 已进入
+	RoomFailed         // 进入失败，等待重试
+)
+
+const (
+	roomJoinTimeout = 30 * time.Second // 超过此时间未收到自己的presence, 视为进入失败
+	roomRetryMin    = 10 * time.Second
+	roomRetryMax    = 10 * time.Minute
+	roomNickTries   = 3 // 依次尝试nick, nick_, nick2
+)
+
+// 第i个备用昵称
+func fallbackNick(nick string, i int) string {
+	switch i {
+	case 0:
+		return nick
+	case 1:
+		return nick + "_"
+	}
+	return fmt.Sprintf("%s%d", nick, i)
+}
+
+// State 返回进入状态及进入失败的原因。
+func (r *Room) State() (state int, reason string) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.state, r.reason
+}
+
+func (r *Room) IsJoined() bool {
+	state, _ := r.State()
+	return state == RoomJoined
+}
+
+func (r *Room) StateString() string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	switch r.state {
+	case RoomJoined:
+		return "已进入"
+	case RoomFailed:
+		return "进入失败: " + r.reason + ", " + r.since.Format("01-02 15:04")
+	}
+	return "正在进入"
+}
+
+// IsMyNick 返回nick是否为bot在聊天室中使用或正在尝试的昵称。
+func (r *Room) IsMyNick(nick string) bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return nick == r.Nickname || nick == r.joinNick || (r.newNick != "" && nick == r.newNick)
+}
+
+// IsNickChange 返回bot昵称nick的unavailable是否是因为bot修改了昵称，而不是离开聊天室。
+func (r *Room) IsNickChange(nick string) bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if r.newNick != "" {
+		return nick == r.Nickname
+	}
+	return r.oldNick != "" && nick == r.oldNick && nick != r.Nickname
+}
+
+// 取消正在进行的修改昵称，返回需要调用的nickDone, 需持有r.lock
+func (r *Room) takeNickChange() func(err error) {
+	done := r.nickDone
+	if r.nickTimer != nil {
+		r.nickTimer.Stop()
+	}
+	r.newNick, r.nickGone, r.nickTimer, r.nickDone = "", false, nil, nil
+	return done
+}
+
+// 停止进入超时及重试的计时器，需持有r.lock
+func (r *Room) stopTimers() {
+	r.joinSeq++
+	if r.joinTimer != nil {
+		r.joinTimer.Stop()
+		r.joinTimer = nil
+	}
+}
+
+// 不再自动进入聊天室，离开聊天室时调用，此后收到的离开presence不会被当作被踢出
+func (r *Room) stopJoin() {
+	r.lock.Lock()
+	r.stopTimers()
+	r.state = RoomJoining
+	done := r.takeNickChange()
+	r.lock.Unlock()
+	if done != nil {
+		done(errors.New("已离开聊天室"))
+	}
+}
+
+// 计时器到期时执行fn, 计时器已被停止或替换时忽略，需持有r.lock
+func (r *Room) afterFunc(d time.Duration, fn func()) {
+	seq := r.joinSeq
+	r.joinTimer = time.AfterFunc(d, func() {
+		r.lock.RLock()
+		stale := seq != r.joinSeq
+		r.lock.RUnlock()
+		if !stale {
+			fn()
+		}
+	})
+}
+
+// 进入聊天室，从配置的昵称开始尝试
+func (m *Admin) joinRoom(room *Room) {
+	room.clearOccupants()
+	room.lock.Lock()
+	room.stopTimers()
+	if room.wantNick == "" {
+		room.wantNick = room.Nickname
+	}
+	if room.retry == nil {
+		room.retry = NewBackoff(roomRetryMin, roomRetryMax, 2, 0.2)
+	}
+	room.state = RoomJoining
+	room.since = time.Now()
+	room.nickTry = 0
+	room.oldNick = ""
+	done := room.takeNickChange()
+	room.lock.Unlock()
+	if done != nil {
+		done(errors.New("正在重新进入聊天室"))
+	}
+	m.tryJoin(room)
+}
+
+// ChangeNick 修改bot在聊天室中的昵称，收到服务器发来的新昵称的presence后才生效。
+// 完成、被拒绝或超时后调用done(可以为nil)。未进入聊天室时在下次进入时使用新昵称。
+func (m *Admin) ChangeNick(room *Room, nick string, done func(err error)) {
+	if done == nil {
+		done = func(err error) {}
+	}
+	room.lock.Lock()
+	if room.state != RoomJoined {
+		room.Nickname, room.wantNick = nick, nick
+		room.lock.Unlock()
+		done(nil)
+		return
+	}
+	if room.newNick != "" {
+		err := fmt.Errorf("正在修改昵称为%s", room.newNick)
+		room.lock.Unlock()
+		done(err)
+		return
+	}
+	if nick == room.Nickname {
+		room.lock.Unlock()
+		done(nil)
+		return
+	}
+	room.newNick, room.nickGone, room.nickDone = nick, false, done
+	room.nickTimer = time.AfterFunc(roomJoinTimeout, func() {
+		m.nickChanged(room, nick, errors.New("等待服务器响应超时"))
+	})
+	room.lock.Unlock()
+	if err := m.bot.sendNick(room, nick); err != nil {
+		m.nickChanged(room, nick, err)
+	}
+}
+
+// 修改昵称完成，err为nil时新昵称生效
+func (m *Admin) nickChanged(room *Room, nick string, err error) {
+	room.lock.Lock()
+	if room.newNick != nick {
+		// 已完成或已取消
+		room.lock.Unlock()
+		return
+	}
+	gone := room.nickGone
+	done := room.takeNickChange()
+	if err == nil {
+		room.oldNick = room.Nickname
+		room.Nickname, room.wantNick = nick, nick
+	}
+	room.lock.Unlock()
+	if err != nil && gone {
+		// 旧昵称已离开，新昵称却没有进入
+		m.joinFailed(room, "修改昵称失败: "+err.Error())
+	}
+	done(err)
+}
+
+// 使用第nickTry个昵称进入聊天室
+func (m *Admin) tryJoin(room *Room) {
+	room.lock.Lock()
+	nick := fallbackNick(room.wantNick, room.nickTry)
+	room.joinNick = nick
+	password := room.Password
+	room.afterFunc(roomJoinTimeout, func() {
+		m.joinFailed(room, "等待服务器响应超时")
+	})
+	room.lock.Unlock()
+	if len(password) > 0 {
+		m.bot.JoinProtectedMUC(room.JID, nick, password)
+	} else {
+		m.bot.JoinMUC(room.JID, nick)
+	}
+}
+
+// 进入失败或被移出聊天室，通知管理员并稍后重试
+func (m *Admin) joinFailed(room *Room, reason string) {
+	room.lock.Lock()
+	room.stopTimers()
+	if m.getRoom(room.JID) != room {
+		room.lock.Unlock()
+		return
+	}
+	room.state = RoomFailed
+	room.reason = reason
+	room.since = time.Now()
+	wait := room.retry.Next()
+	notify := !room.notified
+	room.notified = true
+	room.afterFunc(wait, func() {
+		if m.getRoom(room.JID) == room {
+			m.joinRoom(room)
+		}
+	})
+	room.lock.Unlock()
+
+	text := fmt.Sprintf("无法进入聊天室%s: %s, 将在%v后重试", room.JID, reason, wait.Round(time.Second))
+	fmt.Printf("[%s] %s\n", m.Name, text)
+	if notify {
+		m.notifyAdmins(text)
+	}
+}
+
+func (m *Admin) notifyAdmins(text string) {
+	for _, v := range m.getAdmins() {
+		m.bot.SendAuto(v, text)
+	}
+}
+
+// 根据bot自己在聊天室中的presence更新进入状态
+func (m *Admin) updateJoinState(pres xmpp.Presence) {
+	roomid, nick := utils.SplitJID(pres.From)
+	room := m.getRoom(roomid)
+	if room == nil {
+		return
+	}
+	room.lock.Lock()
+	state, joinNick, current, newNick := room.state, room.joinNick, room.Nickname, room.newNick
+	room.lock.Unlock()
+
+	switch {
+	case pres.Type == "error" && state == RoomJoining:
+		// 无法区分错误原因，先当作昵称冲突尝试备用昵称
+		room.lock.Lock()
+		room.stopTimers()
+		room.nickTry++
+		retry := room.nickTry < roomNickTries
+		room.lock.Unlock()
+		if retry {
+			m.tryJoin(room)
+			return
+		}
+		reason := "服务器拒绝(昵称冲突、需要密码或被禁止)"
+		if pres.Status != "" {
+			reason = pres.Status
+		}
+		m.joinFailed(room, reason)
+	case pres.Type == "error" && newNick != "" && (nick == newNick || nick == current):
+		reason := "服务器拒绝(昵称冲突或不允许修改昵称)"
+		if pres.Status != "" {
+			reason = pres.Status
+		}
+		m.nickChanged(room, newNick, errors.New(reason))
+	case pres.Type == "" && state == RoomJoined && newNick != "" && nick == newNick:
+		m.nickChanged(room, newNick, nil)
+	case pres.Type == "unavailable" && state == RoomJoined && newNick != "" && nick == current:
+		// 修改昵称时先收到旧昵称的unavailable(状态码303)，再收到新昵称的presence
+		room.lock.Lock()
+		if room.newNick == newNick {
+			room.nickGone = true
+		}
+		room.lock.Unlock()
+	case pres.Type == "" && state != RoomJoined && nick == joinNick:
+		room.lock.Lock()
+		room.stopTimers()
+		room.state = RoomJoined
+		room.reason = ""
+		room.since = time.Now()
+		room.Nickname = nick
+		room.retry.Reset()
+		recovered := room.notified
+		room.notified = false
+		want := room.wantNick
+		room.lock.Unlock()
+		if recovered {
+			m.notifyAdmins("已重新进入聊天室" + room.JID)
+		}
+		if nick != want {
+			fmt.Printf("[%s] Nickname %s is used in %s, joined as %s\n", m.Name, want, room.JID, nick)
+		}
+	case pres.Type == "unavailable" && state == RoomJoined && nick == current:
+		// 被踢出、被禁止或聊天室被销毁
+		reason := "被移出聊天室"
+		if pres.Status != "" {
+			reason += "(" + pres.Status + ")"
+		}
+		m.joinFailed(room, reason)
+	}
+}
+
+// GetRooms 返回bot加入的所有聊天室。
+func (b *Bot) GetRooms() []*Room {
+	return b.admin.GetRooms()
+}
+
+// GetRoom 返回聊天室roomid, bot未加入此聊天室时返回nil. 通过Room.State()查看是否已进入。
+func (b *Bot) GetRoom(roomid string) *Room {
+	for _, v := range b.admin.GetRooms() {
+		if v.JID == roomid {
+			return v
+		}
+	}
+	return nil
+}
+
+// JoinRooms 重新进入所有聊天室，用于断线重连。
+func (m *Admin) JoinRooms() {
+	for _, room := range m.GetRooms() {
+		m.joinRoom(room)
+	}
+}
@@ -0,0 +1,122 @@
+package robot
+
+import (
+	"github.com/mattn/go-xmpp"
+	"github.com/yetist/xmppbot/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 等待发出包含text的原始数据
+func waitOrg(t *testing.T, fake *FakeTransport, text string) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		fake.lock.Lock()
+		for _, org := range fake.Orgs {
+			if strings.Contains(org, text) {
+				fake.lock.Unlock()
+				return
+			}
+		}
+		fake.lock.Unlock()
+	}
+	t.Fatalf("%s not sent", text)
+}
+
+// 等待bot回复一条消息给to
+func waitReply(t *testing.T, fake *FakeTransport, to string) string {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if sent := fake.Sent(to); len(sent) > 0 {
+			return sent[0]
+		}
+	}
+	t.Fatalf("no reply to %s", to)
+	return ""
+}
+
+// 修改昵称在服务器确认后才生效，被拒绝时保留原来的昵称
+func TestRoomNick(t *testing.T) {
+	bot, fake := newTestBot(t, func(cfg *config.Config) {
+		cfg.Setup.Rooms = []map[string]interface{}{{"jid": "r@c", "nickname": "bot"}}
+	})
+	room := bot.admin.(*Admin).getRoom("r@c")
+	fake.Inject(xmpp.Presence{From: "r@c/bot"})
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if state, _ := room.State(); state == RoomJoined {
+			break
+		}
+	}
+
+	// 昵称冲突
+	fake.Reset()
+	fake.Inject(xmpp.Chat{Remote: testAdmin, Type: "chat", Text: "--room nick r@c bot2"})
+	waitOrg(t, fake, "to='r@c/bot2'")
+	if nick := room.GetNick(); nick != "bot" {
+		t.Fatalf("nick = %s before confirmed", nick)
+	}
+	fake.Inject(xmpp.Presence{From: "r@c/bot2", Type: "error"})
+	if reply := waitReply(t, fake, testAdmin); !strings.HasPrefix(reply, "r@c: 修改昵称为bot2失败") {
+		t.Errorf("conflict reply = %q", reply)
+	}
+	if nick := room.GetNick(); nick != "bot" {
+		t.Errorf("nick = %s after conflict", nick)
+	}
+	if state, _ := room.State(); state != RoomJoined {
+		t.Errorf("state = %d after conflict", state)
+	}
+
+	// 服务器确认: 旧昵称的unavailable不是离开聊天室
+	fake.Reset()
+	fake.Inject(xmpp.Chat{Remote: testAdmin, Type: "chat", Text: "--room nick r@c bot2"})
+	waitOrg(t, fake, "to='r@c/bot2'")
+	fake.Inject(xmpp.Presence{From: "r@c/bot", Type: "unavailable"})
+	fake.Inject(xmpp.Presence{From: "r@c/bot2"})
+	if reply := waitReply(t, fake, testAdmin); reply != "r@c: 修改昵称为bot2成功" {
+		t.Errorf("change reply = %q", reply)
+	}
+	if nick := room.GetNick(); nick != "bot2" {
+		t.Errorf("nick = %s after confirmed", nick)
+	}
+	if state, _ := room.State(); state != RoomJoined {
+		t.Errorf("state = %d after change", state)
+	}
+
+	// 新昵称的unavailable才是离开聊天室
+	fake.Inject(xmpp.Presence{From: "r@c/bot2", Type: "unavailable"})
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if state, _ := room.State(); state == RoomFailed {
+			return
+		}
+	}
+	t.Error("leaving with the new nick not detected")
+}
+
+// 修改主题等待聊天室转发新主题或返回错误
+func TestRoomTopic(t *testing.T) {
+	_, fake := newTestBot(t, func(cfg *config.Config) {
+		cfg.Setup.Rooms = []map[string]interface{}{{"jid": "r@c", "nickname": "bot"}}
+	})
+	fake.Inject(xmpp.Presence{From: "r@c/bot"})
+
+	fake.Reset()
+	fake.Inject(xmpp.Chat{Remote: testAdmin, Type: "chat", Text: "--room topic r@c hello world"})
+	waitOrg(t, fake, "<subject>hello world</subject>")
+	time.Sleep(50 * time.Millisecond)
+	if sent := fake.Sent(testAdmin); len(sent) != 0 {
+		t.Fatalf("replied before the room answered: %q", sent)
+	}
+	fake.Inject(xmpp.Chat{Remote: "r@c", Type: "error"})
+	if reply := waitReply(t, fake, testAdmin); !strings.HasPrefix(reply, "r@c: 修改主题失败") {
+		t.Errorf("denied reply = %q", reply)
+	}
+
+	fake.Reset()
+	fake.Inject(xmpp.Chat{Remote: testAdmin, Type: "chat", Text: "--room topic r@c hello"})
+	waitOrg(t, fake, "<subject>hello</subject>")
+	fake.Inject(xmpp.Chat{Remote: "r@c/bot", Type: "groupchat"})
+	if reply := waitReply(t, fake, testAdmin); reply != "r@c: 修改主题成功" {
+		t.Errorf("changed reply = %q", reply)
+	}
+}
@@ -0,0 +1,195 @@
+package robot
+
+import (
+	"encoding/xml"
+	"github.com/mattn/go-xmpp"
+	"github.com/yetist/xmppbot/utils"
+	"sort"
+	"time"
+)
+
+const nsMUCAdmin = "http://jabber.org/protocol/muc#admin"
+
+// 收到成员的presence后，延迟多久查询成员列表，用于合并短时间内的多次查询
+const occupantsDelay = 2 * time.Second
+
+// Occupant 是聊天室中的成员。
+//
+// go-xmpp的Presence不包含MUC的<item/>, 因此JID、Affiliation和Role来自XEP-0045的管理查询，
+// 只有bot是主持人时才能获得。成员的presence变化(包括角色改变)后到重新查询完成之前，
+// 以及查询被拒绝时，Affiliation和Role为空，依据它们的判断应把空值当作没有权限。
+type Occupant struct {
+	Nick        string
+	JID         string // 真实jid, bot无权查看时为空
+	Affiliation string // owner, admin, member, outcast, none, 未知时为空
+	Role        string // moderator, participant, visitor, none, 未知时为空
+	Show        string // away, chat, dnd, xa, 在线时为空
+	Status      string
+	Joined      time.Time // 进入聊天室的时间(bot进入之前已在聊天室中的，为bot进入的时间)
+}
+
+func (r *Room) SetOccupant(o Occupant) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.occupants == nil {
+		r.occupants = map[string]Occupant{}
+	}
+	r.occupants[o.Nick] = o
+}
+
+// 修改成员nick的信息，成员不存在时先添加并返回true
+func (r *Room) updateOccupant(nick string, fn func(o *Occupant)) (added bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.occupants == nil {
+		r.occupants = map[string]Occupant{}
+	}
+	o, ok := r.occupants[nick]
+	if !ok {
+		o = Occupant{Nick: nick, Joined: time.Now()}
+	}
+	fn(&o)
+	r.occupants[nick] = o
+	return !ok
+}
+
+func (r *Room) RemoveOccupant(nick string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.occupants, nick)
+}
+
+func (r *Room) GetOccupant(nick string) (o Occupant, ok bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	o, ok = r.occupants[nick]
+	return
+}
+
+// Occupants 返回聊天室的成员列表，按进入时间排序。
+func (r *Room) Occupants() []Occupant {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	list := make([]Occupant, 0, len(r.occupants))
+	for _, v := range r.occupants {
+		list = append(list, v)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].Joined.Equal(list[j].Joined) {
+			return list[i].Joined.Before(list[j].Joined)
+		}
+		return list[i].Nick < list[j].Nick
+	})
+	return list
+}
+
+// 进入或离开聊天室时清除成员列表
+func (r *Room) clearOccupants() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.occupants = nil
+}
+
+type mucAdminQuery struct {
+	XMLName xml.Name `xml:"query"`
+	Items   []struct {
+		Nick        string `xml:"nick,attr"`
+		JID         string `xml:"jid,attr"`
+		Affiliation string `xml:"affiliation,attr"`
+		Role        string `xml:"role,attr"`
+	} `xml:"item"`
+}
+
+// 清除所有成员的从属关系及角色
+func (r *Room) clearRoles() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for k, o := range r.occupants {
+		o.Affiliation, o.Role = "", ""
+		r.occupants[k] = o
+	}
+}
+
+// 通过XEP-0045的管理查询获取聊天室成员的真实jid、从属关系及角色，bot需要是聊天室的主持人。
+func (m *Admin) queryOccupants(room *Room) {
+	for _, role := range []string{"moderator", "participant", "visitor"} {
+		query := "<query xmlns='" + nsMUCAdmin + "'><item role='" + role + "'/></query>"
+		m.bot.SendIQ(room.JID, "get", query, func(iq xmpp.IQ) {
+			if iq.Type != "result" {
+				// bot不是主持人(或不再是)，已有的角色信息无法确认
+				room.lock.Lock()
+				room.queryDeny = true
+				room.lock.Unlock()
+				room.clearRoles()
+				return
+			}
+			var q mucAdminQuery
+			if err := xml.Unmarshal(iq.Query, &q); err != nil {
+				return
+			}
+			for _, v := range q.Items {
+				item := v
+				room.updateOccupant(v.Nick, func(o *Occupant) {
+					o.JID, o.Affiliation, o.Role = item.JID, item.Affiliation, item.Role
+				})
+			}
+		})
+	}
+}
+
+// 合并短时间内多次成员变化，延迟查询成员列表。self为true时是bot自己的presence
+func (m *Admin) scheduleOccupants(room *Room, self bool) {
+	room.lock.Lock()
+	defer room.lock.Unlock()
+	if self {
+		room.queryDeny = false
+	}
+	if room.queryTimer != nil || room.queryDeny {
+		return
+	}
+	room.queryTimer = time.AfterFunc(occupantsDelay, func() {
+		room.lock.Lock()
+		room.queryTimer = nil
+		room.lock.Unlock()
+		m.queryOccupants(room)
+	})
+}
+
+// 根据聊天室成员的Presence更新成员列表
+func (m *Admin) updateOccupants(pres xmpp.Presence) {
+	roomid, nick := utils.SplitJID(pres.From)
+	room := m.getRoom(roomid)
+	if room == nil || nick == "" {
+		return
+	}
+	if pres.Type == "unavailable" {
+		if nick == room.GetNick() && !room.IsNickChange(nick) {
+			room.clearOccupants()
+		} else {
+			room.RemoveOccupant(nick)
+		}
+		return
+	}
+	// 角色或从属关系的变化也通过presence通知，重新查询之前不再信任原来的值
+	room.updateOccupant(nick, func(o *Occupant) {
+		o.Show, o.Status = pres.Show, pres.Status
+		o.Affiliation, o.Role = "", ""
+	})
+	m.scheduleOccupants(room, room.IsMyNick(nick))
+}
+
+// GetOccupants 返回聊天室roomid的成员列表，bot不在此聊天室时返回nil.
+func (b *Bot) GetOccupants(roomid string) []Occupant {
+	if room := b.GetRoom(roomid); room != nil {
+		return room.Occupants()
+	}
+	return nil
+}
+
+// GetOccupant 返回聊天室roomid中昵称为nick的成员。
+func (b *Bot) GetOccupant(roomid, nick string) (Occupant, bool) {
+	if room := b.GetRoom(roomid); room != nil {
+		return room.GetOccupant(nick)
+	}
+	return Occupant{}, false
+}
@@ -0,0 +1,63 @@
+package robot
+
+import (
+	"github.com/mattn/go-xmpp"
+	"github.com/yetist/xmppbot/config"
+	"testing"
+	"time"
+)
+
+// 等待成员信息满足ok
+func waitOccupant(t *testing.T, bot *Bot, nick string, ok func(o Occupant) bool) Occupant {
+	t.Helper()
+	var o Occupant
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if o, _ = bot.GetOccupant("r@c", nick); ok(o) {
+			return o
+		}
+	}
+	t.Fatalf("occupant %s = %+v", nick, o)
+	return o
+}
+
+// 每次成员的presence变化后重新查询角色，查询完成之前及被拒绝时角色为空
+func TestOccupantRoles(t *testing.T) {
+	bot, fake := newTestBot(t, func(cfg *config.Config) {
+		cfg.Setup.Rooms = []map[string]interface{}{{"jid": "r@c", "nickname": "bot"}}
+	})
+	const wait = occupantsDelay + time.Second
+	fake.Inject(xmpp.Presence{From: "r@c/alice"})
+	fake.Inject(xmpp.Presence{From: "r@c/bot"})
+	id := waitIQ(fake, "<item role='moderator'/>", wait)
+	if id == "" {
+		t.Fatal("occupants not queried")
+	}
+	fake.Reset()
+	fake.Inject(xmpp.IQ{ID: id, From: "r@c", Type: "result",
+		Query: []byte("<query xmlns='" + nsMUCAdmin + "'><item nick='alice' jid='alice@example.org/x' affiliation='admin' role='moderator'/></query>")})
+	waitOccupant(t, bot, "alice", func(o Occupant) bool {
+		return o.Role == "moderator" && o.Affiliation == "admin" && o.JID == "alice@example.org/x"
+	})
+
+	// 角色改变(如被取消主持人)也是一次presence, 在重新查询之前不再信任原来的角色
+	fake.Inject(xmpp.Presence{From: "r@c/alice", Show: "away"})
+	waitOccupant(t, bot, "alice", func(o Occupant) bool {
+		return o.Role == "" && o.Affiliation == "" && o.JID == "alice@example.org/x" && o.Show == "away"
+	})
+	if id = waitIQ(fake, "<item role='moderator'/>", wait); id == "" {
+		t.Fatal("occupants not queried again after presence change")
+	}
+
+	// 查询被拒绝后，bot自己的presence变化之前不再查询
+	fake.Reset()
+	fake.Inject(xmpp.IQ{ID: id, From: "r@c", Type: "error"})
+	time.Sleep(50 * time.Millisecond)
+	fake.Inject(xmpp.Presence{From: "r@c/bob"})
+	if id = waitIQ(fake, "<item role='moderator'/>", wait); id != "" {
+		t.Fatal("occupants queried after the query was denied")
+	}
+	fake.Inject(xmpp.Presence{From: "r@c/bot"})
+	if id = waitIQ(fake, "<item role='moderator'/>", wait); id == "" {
+		t.Fatal("occupants not queried after bot presence changed")
+	}
+}
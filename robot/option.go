@@ -0,0 +1,292 @@
+package robot
+
+import (
+	"errors"
+	"fmt"
+	"github.com/yetist/xmppbot/utils"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type OptionType int
+
+const (
+	StringOption OptionType = iota
+	BoolOption
+	IntOption
+	FloatOption
+	ListOption // 字符串列表
+)
+
+func (t OptionType) String() string {
+	switch t {
+	case BoolOption:
+		return "bool"
+	case IntOption:
+		return "int"
+	case FloatOption:
+		return "float"
+	case ListOption:
+		return "list"
+	}
+	return "string"
+}
+
+// Option 描述模块的一个可配置属性。
+type Option struct {
+	Name        string
+	Type        OptionType
+	Default     interface{}
+	Description string
+	Required    bool
+	Hidden      bool // 仅可在配置文件中设置，不能通过命令查看和修改，如密码
+}
+
+// Schema 是模块所有可配置属性的描述，模块注册时通过PluginMeta提供。
+type Schema []Option
+
+func (s Schema) lookup(name string) (Option, bool) {
+	for _, v := range s {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return Option{}, false
+}
+
+// Validate 检查模块配置，返回按类型转换并补充了默认值的配置。
+func (s Schema) Validate(opt map[string]interface{}) (map[string]interface{}, error) {
+	values, errs := s.normalize(opt)
+	for k, v := range opt {
+		if k == "enable" {
+			if _, ok := v.(bool); !ok {
+				errs = append(errs, fmt.Sprintf("option enable: expect bool, got %v", v))
+			}
+		} else if _, ok := s.lookup(k); !ok {
+			errs = append(errs, fmt.Sprintf("unknown option %s", k))
+		}
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return values, errors.New(strings.Join(errs, "; "))
+	}
+	return values, nil
+}
+
+// 转换配置，无法转换的属性使用默认值。
+func (s Schema) normalize(opt map[string]interface{}) (values map[string]interface{}, errs []string) {
+	values = map[string]interface{}{}
+	for _, o := range s {
+		v, ok := opt[o.Name]
+		if !ok {
+			if o.Required {
+				errs = append(errs, fmt.Sprintf("option %s is required", o.Name))
+			}
+			values[o.Name] = convertOption(o.Type, o.Default)
+			continue
+		}
+		if val := convertOption(o.Type, v); val != nil {
+			values[o.Name] = val
+		} else {
+			errs = append(errs, fmt.Sprintf("option %s: expect %s, got %v", o.Name, o.Type, v))
+			values[o.Name] = convertOption(o.Type, o.Default)
+		}
+	}
+	return
+}
+
+// 将toml中读取的值转换为t类型，无法转换时返回nil
+func convertOption(t OptionType, v interface{}) interface{} {
+	switch t {
+	case StringOption:
+		if v == nil {
+			return ""
+		}
+		if s, ok := v.(string); ok {
+			return s
+		}
+	case BoolOption:
+		if v == nil {
+			return false
+		}
+		if b, ok := v.(bool); ok {
+			return b
+		}
+	case IntOption:
+		switch i := v.(type) {
+		case nil:
+			return 0
+		case int:
+			return i
+		case int64:
+			return int(i)
+		}
+	case FloatOption:
+		switch f := v.(type) {
+		case nil:
+			return 0.0
+		case float64:
+			return f
+		case int:
+			return float64(f)
+		case int64:
+			return float64(f)
+		}
+	case ListOption:
+		switch l := v.(type) {
+		case nil:
+			return []string{}
+		case []string:
+			return l
+		case []interface{}:
+			list := []string{}
+			for _, i := range l {
+				s, ok := i.(string)
+				if !ok {
+					return nil
+				}
+				list = append(list, s)
+			}
+			return list
+		}
+	}
+	return nil
+}
+
+// OptionSet 保存模块属性的当前值，嵌入到模块中即可得到GetOptions和SetOption.
+type OptionSet struct {
+	lock   sync.RWMutex
+	schema Schema
+	values map[string]interface{}
+}
+
+func NewOptionSet(schema Schema, opt map[string]interface{}) *OptionSet {
+	values, _ := schema.normalize(opt)
+	return &OptionSet{schema: schema, values: values}
+}
+
+// Load 重新载入全部属性值，通常在配置文件修改后调用。
+func (o *OptionSet) Load(opt map[string]interface{}) {
+	values, _ := o.schema.normalize(opt)
+	o.lock.Lock()
+	o.values = values
+	o.lock.Unlock()
+}
+
+func (o *OptionSet) get(name string) interface{} {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	return o.values[name]
+}
+
+func (o *OptionSet) String(name string) string {
+	s, _ := o.get(name).(string)
+	return s
+}
+
+func (o *OptionSet) Bool(name string) bool {
+	b, _ := o.get(name).(bool)
+	return b
+}
+
+func (o *OptionSet) Int(name string) int {
+	i, _ := o.get(name).(int)
+	return i
+}
+
+func (o *OptionSet) Float(name string) float64 {
+	f, _ := o.get(name).(float64)
+	return f
+}
+
+func (o *OptionSet) List(name string) []string {
+	l, _ := o.get(name).([]string)
+	return append([]string{}, l...)
+}
+
+// GetOptions 返回可通过命令查看的属性及其说明。
+func (o *OptionSet) GetOptions() map[string]string {
+	opts := map[string]string{}
+	for _, v := range o.schema {
+		if !v.Hidden {
+			opts[v.Name] = o.format(v) + "  #" + v.Description
+		}
+	}
+	return opts
+}
+
+func (o *OptionSet) format(opt Option) string {
+	switch v := o.get(opt.Name).(type) {
+	case bool:
+		return utils.BoolToString(v)
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []string:
+		return strings.Join(v, ",")
+	case string:
+		return v
+	}
+	return ""
+}
+
+// Set 按属性类型解析并设置val, 列表类型的值以逗号分隔。
+func (o *OptionSet) Set(key, val string) error {
+	opt, ok := o.schema.lookup(key)
+	if !ok || opt.Hidden {
+		return fmt.Errorf("unknown option %s", key)
+	}
+	var v interface{}
+	switch opt.Type {
+	case StringOption:
+		v = val
+	case BoolOption:
+		b, err := utils.ParseBool(val)
+		if err != nil {
+			return fmt.Errorf("option %s: expect bool, got %s", key, val)
+		}
+		v = b
+	case IntOption:
+		i, err := strconv.Atoi(val)
+		if err != nil {
+			return fmt.Errorf("option %s: expect int, got %s", key, val)
+		}
+		v = i
+	case FloatOption:
+		f, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return fmt.Errorf("option %s: expect float, got %s", key, val)
+		}
+		v = f
+	case ListOption:
+		list := []string{}
+		for _, i := range strings.Split(val, ",") {
+			if i = strings.TrimSpace(i); i != "" {
+				list = append(list, i)
+			}
+		}
+		v = list
+	}
+	o.lock.Lock()
+	o.values[key] = v
+	o.lock.Unlock()
+	return nil
+}
+
+func (o *OptionSet) SetOption(key, val string) error {
+	return o.Set(key, val)
+}
+
+// Describe 生成模块描述，lines为模块说明，其后列出可配置属性。
+func (o *OptionSet) Describe(lines ...string) string {
+	msg := append(lines, "本模块可配置属性:")
+	options := o.GetOptions()
+	keys := utils.SortMapKeys(options)
+	for _, v := range keys {
+		msg = append(msg, fmt.Sprintf("%-20s : %s", v, options[v]))
+	}
+	return strings.Join(msg, "\n")
+}
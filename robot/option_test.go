@@ -0,0 +1,43 @@
+package robot
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestOptionSetSet(t *testing.T) {
+	schema := Schema{
+		{Name: "protect", Type: BoolOption},
+		{Name: "rate", Type: IntOption, Default: 6},
+		{Name: "ratio", Type: FloatOption},
+		{Name: "allow", Type: ListOption},
+	}
+	tests := []struct {
+		key, val string
+		ok       bool
+		want     string
+	}{
+		{"protect", "true", true, "true"},
+		{"protect", "Yes", true, "true"},
+		{"protect", "0", true, "false"},
+		{"protect", "no", true, "false"},
+		{"protect", "flase", false, "false"},
+		{"protect", "", false, "false"},
+		{"rate", "10", true, "10"},
+		{"rate", "ten", false, "6"},
+		{"ratio", "0.5", true, "0.5"},
+		{"ratio", "half", false, "0"},
+		{"allow", "a, b,,c", true, "[a b c]"},
+		{"nosuch", "1", false, "<nil>"},
+	}
+	for _, tt := range tests {
+		o := NewOptionSet(schema, nil)
+		err := o.Set(tt.key, tt.val)
+		if (err == nil) != tt.ok {
+			t.Errorf("Set(%s, %q) error = %v", tt.key, tt.val, err)
+		}
+		if got := fmt.Sprint(o.get(tt.key)); got != tt.want {
+			t.Errorf("Set(%s, %q): %s = %q, want %q", tt.key, tt.val, tt.key, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package robot
+
+import (
+	"fmt"
+	"github.com/mattn/go-xmpp"
+	"github.com/yetist/xmppbot/utils"
+	"html"
+	"log"
+	"sync"
+	"time"
+)
+
+// 未配置setup.outbox时的默认值
+const (
+	DefaultOutboxRate      = 5.0  // 每秒最多发送的消息数
+	DefaultOutboxBurst     = 10   // 允许连续发送的消息数
+	DefaultOutboxDestRate  = 1.0  // 每个好友或聊天室每秒最多发送的消息数
+	DefaultOutboxDestBurst = 5    // 每个好友或聊天室允许连续发送的消息数
+	DefaultOutboxSize      = 1000 // 等待发送的消息数上限
+	DefaultOutboxCoalesce  = 2000 // 合并后消息的最大长度
+)
+
+// 消息的优先级，数值小的先发送
+const (
+	PriorityHigh   = iota // 发给管理员的消息
+	PriorityNormal        // 其它消息
+	numPriorities
+)
+
+// 消息被限速的时间超过此值时计入Delayed
+const outboxDelayThreshold = time.Second
+
+// 清理空闲的限速记录
+const maxDestBuckets = 1000
+
+// OutboxStats 是发送队列的统计。
+type OutboxStats struct {
+	Depth     int // 等待发送的消息数
+	Sent      int
+	Dropped   int           // 队列满时丢弃的消息数
+	Delayed   int           // 因限速等待超过1秒的消息数
+	Coalesced int           // 被合并到前一条消息中的消息数
+	MaxDelay  time.Duration // 消息在队列中等待的最长时间
+}
+
+type outMessage struct {
+	chat   xmpp.Chat
+	html   string // XHTML-IM的body内容，为空时是纯文本消息
+	dest   string // 限速对象: 好友或聊天室的jid
+	queued time.Time
+}
+
+// 令牌桶限速
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// 返回还需等待多久才能发送，rate小于0时不限速
+func (t *tokenBucket) wait(now time.Time, rate float64, burst int) time.Duration {
+	if rate < 0 {
+		return 0
+	}
+	if t.last.IsZero() {
+		t.tokens = float64(burst)
+	} else if t.tokens += now.Sub(t.last).Seconds() * rate; t.tokens > float64(burst) {
+		t.tokens = float64(burst)
+	}
+	t.last = now
+	if t.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - t.tokens) / rate * float64(time.Second))
+}
+
+func (t *tokenBucket) take(rate float64) {
+	if rate >= 0 {
+		t.tokens--
+	}
+}
+
+type outboxLimits struct {
+	rate, destRate   float64
+	burst, destBurst int
+	size, coalesce   int
+}
+
+// 按setup.outbox返回限速设置，未设置(0)时使用默认值，负数表示不限制
+func (b *Bot) outboxLimits() outboxLimits {
+	opt := b.GetConfig().Setup.Outbox
+	l := outboxLimits{opt.Rate, opt.DestRate, opt.Burst, opt.DestBurst, opt.Size, opt.Coalesce}
+	if l.rate == 0 {
+		l.rate = DefaultOutboxRate
+	}
+	if l.burst <= 0 {
+		l.burst = DefaultOutboxBurst
+	}
+	if l.destRate == 0 {
+		l.destRate = DefaultOutboxDestRate
+	}
+	if l.destBurst <= 0 {
+		l.destBurst = DefaultOutboxDestBurst
+	}
+	if l.size <= 0 {
+		l.size = DefaultOutboxSize
+	}
+	if l.coalesce == 0 {
+		l.coalesce = DefaultOutboxCoalesce
+	}
+	// 不要把拆分后的消息又合并起来
+	if size, _ := b.replyLimits(); l.coalesce > size {
+		l.coalesce = size
+	}
+	return l
+}
+
+// 发送队列，所有聊天消息都经由它限速发送，发给管理员的消息优先发送。
+type outbox struct {
+	bot    *Bot
+	lock   sync.Mutex
+	lanes  [numPriorities][]*outMessage
+	global tokenBucket
+	dests  map[string]*tokenBucket
+	stats  OutboxStats
+	wake   chan bool
+	closed bool
+	done   chan bool
+	now    func() time.Time // 当前时间，测试时替换
+}
+
+func newOutbox(bot *Bot) *outbox {
+	o := &outbox{
+		bot:   bot,
+		dests: map[string]*tokenBucket{},
+		wake:  make(chan bool, 1),
+		done:  make(chan bool),
+		now:   time.Now,
+	}
+	go o.run()
+	return o
+}
+
+func (o *outbox) depth() (n int) {
+	for _, lane := range o.lanes {
+		n += len(lane)
+	}
+	return
+}
+
+// 将消息放入队列，队列满时丢弃优先级不高于它的最早的消息，没有时丢弃此消息
+func (o *outbox) push(m *outMessage, prio int, limits outboxLimits) {
+	o.lock.Lock()
+	if o.depth() >= limits.size {
+		evicted := false
+		for p := numPriorities - 1; p >= prio; p-- {
+			if len(o.lanes[p]) > 0 {
+				o.lanes[p] = o.lanes[p][1:]
+				evicted = true
+				break
+			}
+		}
+		o.stats.Dropped++
+		if o.stats.Dropped%100 == 1 {
+			log.Printf("outbox is full, %d messages dropped", o.stats.Dropped)
+		}
+		if !evicted {
+			o.lock.Unlock()
+			return
+		}
+	}
+	o.lanes[prio] = append(o.lanes[prio], m)
+	o.lock.Unlock()
+	o.notify()
+}
+
+func (o *outbox) notify() {
+	select {
+	case o.wake <- true:
+	default:
+	}
+}
+
+// 取出下一条可以发送的消息，没有时返回需要等待的时间(0表示等待新消息)。
+// 队列已关闭且为空时ok为false.
+func (o *outbox) next(limits outboxLimits) (m *outMessage, wait time.Duration, ok bool) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.depth() == 0 {
+		return nil, 0, !o.closed
+	}
+	now := o.now()
+	if wait = o.global.wait(now, limits.rate, limits.burst); wait > 0 {
+		return nil, wait, true
+	}
+	for p := range o.lanes {
+		for i, v := range o.lanes[p] {
+			d, ok := o.dests[v.dest]
+			if !ok {
+				d = &tokenBucket{}
+				o.dests[v.dest] = d
+			}
+			if w := d.wait(now, limits.destRate, limits.destBurst); w > 0 {
+				if wait == 0 || w < wait {
+					wait = w
+				}
+				continue
+			}
+			o.lanes[p] = append(o.lanes[p][:i:i], o.lanes[p][i+1:]...)
+			m = v
+			o.coalesce(p, i, m, limits.coalesce)
+			o.global.take(limits.rate)
+			d.take(limits.destRate)
+			o.pruneDests(now, limits)
+			if delay := now.Sub(m.queued); delay > o.stats.MaxDelay {
+				o.stats.MaxDelay = delay
+			}
+			if now.Sub(m.queued) > outboxDelayThreshold {
+				o.stats.Delayed++
+			}
+			o.stats.Sent++
+			return m, 0, true
+		}
+	}
+	return nil, wait, true
+}
+
+// 合并之后紧接着的发往同一聊天室的消息，i为m原来在队列中的位置
+func (o *outbox) coalesce(p, i int, m *outMessage, max int) {
+	if m.chat.Type != "groupchat" || m.html != "" || max < 0 {
+		return
+	}
+	lane := o.lanes[p]
+	for i < len(lane) {
+		v := lane[i]
+		if v.chat.Type != "groupchat" || v.html != "" || v.chat.Remote != m.chat.Remote ||
+			len(m.chat.Text)+1+len(v.chat.Text) > max {
+			break
+		}
+		m.chat.Text += "\n" + v.chat.Text
+		lane = append(lane[:i:i], lane[i+1:]...)
+		o.stats.Coalesced++
+	}
+	o.lanes[p] = lane
+}
+
+// 删除已恢复满额的限速记录
+func (o *outbox) pruneDests(now time.Time, limits outboxLimits) {
+	if len(o.dests) <= maxDestBuckets {
+		return
+	}
+	for k, v := range o.dests {
+		if v.wait(now, limits.destRate, limits.destBurst) == 0 && v.tokens >= float64(limits.destBurst) {
+			delete(o.dests, k)
+		}
+	}
+}
+
+func (o *outbox) run() {
+	defer close(o.done)
+	for {
+		m, wait, ok := o.next(o.bot.outboxLimits())
+		if !ok {
+			return
+		}
+		if m != nil {
+			o.bot.deliver(m)
+			continue
+		}
+		var timer <-chan time.Time
+		if wait > 0 {
+			timer = time.After(wait)
+		}
+		select {
+		case <-o.wake:
+		case <-timer:
+		}
+	}
+}
+
+// 发送完队列中的消息后结束
+func (o *outbox) flush() {
+	o.lock.Lock()
+	o.closed = true
+	o.lock.Unlock()
+	o.notify()
+	<-o.done
+}
+
+func (o *outbox) Stats() OutboxStats {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	s := o.stats
+	s.Depth = o.depth()
+	return s
+}
+
+// 将消息放入发送队列
+func (b *Bot) send(chat xmpp.Chat, xhtml string) {
+	dest, _ := utils.SplitJID(chat.Remote)
+	prio := PriorityNormal
+	if chat.Type != "groupchat" && b.admin.IsAdminID(dest) {
+		prio = PriorityHigh
+	}
+	b.out.push(&outMessage{chat: chat, html: xhtml, dest: dest, queued: time.Now()}, prio, b.outboxLimits())
+}
+
+func (b *Bot) deliver(m *outMessage) {
+	var err error
+	if m.html != "" {
+		org := fmt.Sprintf("<message to='%s' type='%s' xml:lang='en'><body>%s</body>"+
+			"<html xmlns='http://jabber.org/protocol/xhtml-im'><body xmlns='http://www.w3.org/1999/xhtml'>%s</body></html></message>",
+			html.EscapeString(m.chat.Remote), html.EscapeString(m.chat.Type), html.EscapeString(m.chat.Text), m.html)
+		_, err = b.conn().SendOrg(org)
+	} else {
+		_, err = b.conn().Send(m.chat)
+	}
+	if err != nil {
+		log.Printf("send message to %s error: %v", m.chat.Remote, err)
+	}
+}
+
+// GetOutboxStats 返回发送队列的统计。
+func (b *Bot) GetOutboxStats() OutboxStats {
+	return b.out.Stats()
+}
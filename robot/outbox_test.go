@@ -0,0 +1,165 @@
+package robot
+
+import (
+	"fmt"
+	"github.com/mattn/go-xmpp"
+	"testing"
+	"time"
+)
+
+var outboxT0 = time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+// 使用假时钟，不启动发送goroutine的发送队列
+func newTestOutbox(clock *time.Time) *outbox {
+	return &outbox{
+		dests: map[string]*tokenBucket{},
+		wake:  make(chan bool, 1),
+		now:   func() time.Time { return *clock },
+	}
+}
+
+func outMsg(to, typ, text string, queued time.Time) *outMessage {
+	return &outMessage{chat: xmpp.Chat{Remote: to, Type: typ, Text: text}, dest: to, queued: queued}
+}
+
+// 依次取出消息，直到需要等待
+func drain(o *outbox, limits outboxLimits) (texts []string, wait time.Duration) {
+	for {
+		m, wait, _ := o.next(limits)
+		if m == nil {
+			return texts, wait
+		}
+		texts = append(texts, m.chat.Text)
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	var b tokenBucket
+	now := outboxT0
+	for i := 0; i < 2; i++ {
+		if w := b.wait(now, 1, 2); w != 0 {
+			t.Fatalf("burst %d: wait %v", i, w)
+		}
+		b.take(1)
+	}
+	if w := b.wait(now, 1, 2); w != time.Second {
+		t.Errorf("after burst: wait %v, want 1s", w)
+	}
+	if w := b.wait(now.Add(500*time.Millisecond), 1, 2); w != 500*time.Millisecond {
+		t.Errorf("after 0.5s: wait %v, want 0.5s", w)
+	}
+	if w := b.wait(now.Add(time.Hour), 1, 2); w != 0 || b.tokens != 2 {
+		t.Errorf("after 1h: wait %v, tokens %v, want 0 and burst", w, b.tokens)
+	}
+	var unlimited tokenBucket
+	for i := 0; i < 100; i++ {
+		if w := unlimited.wait(now, -1, 1); w != 0 {
+			t.Fatalf("unlimited: wait %v", w)
+		}
+		unlimited.take(-1)
+	}
+}
+
+func TestOutboxRate(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits outboxLimits
+		msgs   []string // "目标:内容"
+		sent   []string
+		wait   time.Duration
+		later  []string // 等待wait后发送的消息
+	}{
+		{"global", outboxLimits{rate: 1, burst: 2, destRate: -1, size: 10, coalesce: -1},
+			[]string{"a:1", "b:2", "c:3"}, []string{"1", "2"}, time.Second, []string{"3"}},
+		{"dest", outboxLimits{rate: -1, destRate: 0.5, burst: 1, destBurst: 1, size: 10, coalesce: -1},
+			[]string{"a:1", "a:2", "b:3", "a:4"}, []string{"1", "3"}, 2 * time.Second, []string{"2"}},
+		{"unlimited", outboxLimits{rate: -1, destRate: -1, size: 10, coalesce: -1},
+			[]string{"a:1", "a:2", "a:3"}, []string{"1", "2", "3"}, 0, nil},
+	}
+	for _, tt := range tests {
+		clock := outboxT0
+		o := newTestOutbox(&clock)
+		for _, v := range tt.msgs {
+			o.push(outMsg(v[:1]+"@example.org", "chat", v[2:], clock), PriorityNormal, tt.limits)
+		}
+		sent, wait := drain(o, tt.limits)
+		if fmt.Sprint(sent) != fmt.Sprint(tt.sent) || wait != tt.wait {
+			t.Errorf("%s: sent %v wait %v, want %v %v", tt.name, sent, wait, tt.sent, tt.wait)
+		}
+		clock = clock.Add(wait)
+		if later, _ := drain(o, tt.limits); fmt.Sprint(later) != fmt.Sprint(tt.later) {
+			t.Errorf("%s: later sent %v, want %v", tt.name, later, tt.later)
+		}
+	}
+}
+
+func TestOutboxPush(t *testing.T) {
+	clock := outboxT0
+	limits := outboxLimits{rate: -1, destRate: -1, size: 2, coalesce: -1}
+	o := newTestOutbox(&clock)
+	o.push(outMsg("u@example.org", "chat", "n1", clock), PriorityNormal, limits)
+	o.push(outMsg("u@example.org", "chat", "n2", clock), PriorityNormal, limits)
+	// 队列满时丢弃最早的普通消息，管理员的消息优先发送
+	o.push(outMsg("a@example.com", "chat", "h1", clock), PriorityHigh, limits)
+	o.push(outMsg("a@example.com", "chat", "h2", clock), PriorityHigh, limits)
+	// 没有优先级不高于它的消息时丢弃此消息
+	o.push(outMsg("u@example.org", "chat", "n3", clock), PriorityNormal, limits)
+	if s := o.Stats(); s.Depth != 2 || s.Dropped != 3 {
+		t.Errorf("stats %+v, want depth 2, dropped 3", s)
+	}
+	if sent, _ := drain(o, limits); fmt.Sprint(sent) != "[h1 h2]" {
+		t.Errorf("sent %v, want [h1 h2]", sent)
+	}
+
+	o.push(outMsg("u@example.org", "chat", "n4", clock), PriorityNormal, limits)
+	o.push(outMsg("a@example.com", "chat", "h3", clock), PriorityHigh, limits)
+	if sent, _ := drain(o, limits); fmt.Sprint(sent) != "[h3 n4]" {
+		t.Errorf("sent %v, want [h3 n4]", sent)
+	}
+}
+
+func TestOutboxCoalesce(t *testing.T) {
+	clock := outboxT0
+	limits := outboxLimits{rate: -1, destRate: -1, size: 10, coalesce: 6}
+	o := newTestOutbox(&clock)
+	for _, m := range []*outMessage{
+		outMsg("r@c", "groupchat", "a", clock),
+		outMsg("r@c", "groupchat", "b", clock),
+		outMsg("r@c", "groupchat", "c", clock),
+		outMsg("r@c", "groupchat", "d", clock), // 合并后超过6字节，不再合并
+		outMsg("x@c", "groupchat", "e", clock),
+		outMsg("r@c", "groupchat", "f", clock),
+		outMsg("r@c", "chat", "g", clock),
+		{chat: xmpp.Chat{Remote: "r@c", Type: "groupchat", Text: "h"}, html: "<b>h</b>", dest: "r@c", queued: clock},
+		outMsg("r@c", "groupchat", "i", clock),
+	} {
+		o.push(m, PriorityNormal, limits)
+	}
+	sent, _ := drain(o, limits)
+	if want := fmt.Sprintf("%q", []string{"a\nb\nc", "d", "e", "f", "g", "h", "i"}); fmt.Sprintf("%q", sent) != want {
+		t.Errorf("sent %q, want %s", sent, want)
+	}
+	if s := o.Stats(); s.Coalesced != 2 || s.Sent != 7 {
+		t.Errorf("stats %+v, want coalesced 2, sent 7", s)
+	}
+}
+
+func TestOutboxDelayed(t *testing.T) {
+	clock := outboxT0
+	limits := outboxLimits{rate: 1, burst: 1, destRate: -1, size: 10, coalesce: -1}
+	o := newTestOutbox(&clock)
+	o.push(outMsg("u@example.org", "chat", "1", clock), PriorityNormal, limits)
+	o.push(outMsg("u@example.org", "chat", "2", clock), PriorityNormal, limits)
+	o.push(outMsg("u@example.org", "chat", "3", clock), PriorityNormal, limits)
+	for sent := 0; sent < 3; {
+		if m, wait, _ := o.next(limits); m == nil {
+			clock = clock.Add(wait)
+		} else {
+			sent++
+		}
+	}
+	// 第2条等待1秒，第3条等待2秒
+	if s := o.Stats(); s.Sent != 3 || s.Delayed != 1 || s.MaxDelay != 2*time.Second || s.Depth != 0 {
+		t.Errorf("stats %+v, want sent 3, delayed 1, max delay 2s", s)
+	}
+}
@@ -0,0 +1,180 @@
+package robot
+
+import (
+	"fmt"
+	"github.com/mattn/go-xmpp"
+	"github.com/yetist/xmppbot/utils"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// 未配置setup.reply时的默认值
+const (
+	DefaultReplyMaxSize  = 2000 // 每条消息的最大长度(字节)
+	DefaultReplyMaxParts = 3    // 一次最多发送的消息数
+	minReplyMaxSize      = 64   // setup.reply.max_size的最小值
+)
+
+// 未通过--more查看的内容保留多久
+const pagesTTL = 10 * time.Minute
+
+// 等待通过--more查看的内容
+type pages struct {
+	typ    string
+	parts  []string
+	expire time.Time
+}
+
+func (b *Bot) replyLimits() (size, parts int) {
+	opt := b.GetConfig().Setup.Reply
+	if size = opt.MaxSize; size <= 0 {
+		size = DefaultReplyMaxSize
+	} else if size < minReplyMaxSize {
+		size = minReplyMaxSize
+	}
+	if parts = opt.MaxParts; parts <= 0 {
+		parts = DefaultReplyMaxParts
+	}
+	return
+}
+
+// 按行将text拆分为不超过size字节的多段，超长的行按字符拆分，每段至少包含一个字符
+func splitText(text string, size int) []string {
+	if len(text) <= size {
+		return []string{text}
+	}
+	var parts []string
+	cur := ""
+	for _, line := range strings.Split(text, "\n") {
+		for len(line) > size {
+			n := size
+			for n > 0 && !utf8.RuneStart(line[n]) {
+				n--
+			}
+			if n == 0 {
+				_, n = utf8.DecodeRuneInString(line)
+			}
+			if cur != "" {
+				parts = append(parts, cur)
+				cur = ""
+			}
+			parts = append(parts, line[:n])
+			line = line[n:]
+		}
+		if cur == "" {
+			cur = line
+		} else if len(cur)+1+len(line) <= size {
+			cur += "\n" + line
+		} else {
+			parts = append(parts, cur)
+			cur = line
+		}
+	}
+	if cur == "" && len(parts) > 0 {
+		return parts
+	}
+	return append(parts, cur)
+}
+
+// 还有rest条未发送时附加在最后一条消息后的提示
+func (b *Bot) moreNote(rest int) string {
+	return fmt.Sprintf("\n(还有%d条，请发送%s查看)", rest, b.GetCmdString("more"))
+}
+
+// 返回一次发送的n条消息及剩余的部分，有剩余时最后一条附加提示，加上提示后仍不超过size
+func (b *Bot) page(parts []string, n, size int) (page, rest []string) {
+	if len(parts) <= n {
+		return parts, nil
+	}
+	page = append([]string{}, parts[:n]...)
+	rest = parts[n:]
+	// 拆分最后一条会增加剩余条数，按最多的情况预留提示的长度
+	last := page[n-1]
+	if avail := size - len(b.moreNote(len(rest)+len(last))); avail > 0 && len(last)+len(b.moreNote(len(rest))) > size {
+		split := splitText(last, avail)
+		page[n-1] = split[0]
+		rest = append(append([]string{}, split[1:]...), rest...)
+	}
+	page[n-1] += b.moreNote(len(rest))
+	return page, rest
+}
+
+// 拆分后发送，超过setup.reply.max_parts的部分保存起来，通过--more查看
+func (b *Bot) sendPages(to, typ, text string) {
+	size, n := b.replyLimits()
+	parts, rest := b.page(splitText(text, size), n, size)
+	if len(rest) > 0 {
+		b.pagerLock.Lock()
+		if b.pages == nil {
+			b.pages = map[string]*pages{}
+		}
+		b.pages[to] = &pages{typ: typ, parts: rest, expire: time.Now().Add(pagesTTL)}
+		b.pagerLock.Unlock()
+	}
+	for _, v := range parts {
+		b.send(xmpp.Chat{Remote: to, Type: typ, Text: v}, "")
+	}
+}
+
+// 拆分后全部发送
+func (b *Bot) sendSplit(to, typ, text string) {
+	size, _ := b.replyLimits()
+	for _, v := range splitText(text, size) {
+		b.send(xmpp.Chat{Remote: to, Type: typ, Text: v}, "")
+	}
+}
+
+// 回复较长时改为私聊发送，在聊天室中提示
+func (b *Bot) replyPrivate(recv xmpp.Chat, text string) bool {
+	if !b.GetConfig().Setup.Reply.RoomPrivate {
+		return false
+	}
+	if size, _ := b.replyLimits(); len(text) <= size {
+		return false
+	}
+	roomid, nick := utils.SplitJID(recv.Remote)
+	b.ReplyAuto(recv, text)
+	b.send(xmpp.Chat{Remote: roomid, Type: "groupchat", Text: nick + ": 回复较长，已通过私聊发送。"}, "")
+	return true
+}
+
+// 发送保存的下一页内容
+func (b *Bot) morePages(to string) bool {
+	size, n := b.replyLimits()
+	b.pagerLock.Lock()
+	p, ok := b.pages[to]
+	if ok && time.Now().After(p.expire) {
+		ok = false
+	}
+	if !ok {
+		delete(b.pages, to)
+		b.pagerLock.Unlock()
+		return false
+	}
+	parts, rest := b.page(p.parts, n, size)
+	if len(rest) > 0 {
+		p.parts = rest
+		p.expire = time.Now().Add(pagesTTL)
+	} else {
+		delete(b.pages, to)
+	}
+	b.pagerLock.Unlock()
+	for _, v := range parts {
+		b.send(xmpp.Chat{Remote: to, Type: p.typ, Text: v}, "")
+	}
+	return true
+}
+
+/* more 命令 */
+func (m *Admin) more(msg xmpp.Chat, args *Args) {
+	// 聊天室中先查看公共回复，再查看私聊回复
+	if msg.Type == "groupchat" {
+		if roomid, _ := utils.SplitJID(msg.Remote); m.bot.morePages(roomid) {
+			return
+		}
+	}
+	if !m.bot.morePages(msg.Remote) {
+		m.bot.ReplyAuto(msg, "没有更多内容了。")
+	}
+}
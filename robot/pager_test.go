@@ -0,0 +1,28 @@
+package robot
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitText(t *testing.T) {
+	tests := []struct {
+		text string
+		size int
+		want []string
+	}{
+		{"short", 10, []string{"short"}},
+		{"line 1\nline 2\nline 3", 13, []string{"line 1\nline 2", "line 3"}},
+		{"abcdefgh", 3, []string{"abc", "def", "gh"}},
+		{"abcdef", 3, []string{"abc", "def"}},
+		{"中文消息", 7, []string{"中文", "消息"}},
+		// size小于一个字符时，每段一个字符
+		{"中文", 1, []string{"中", "文"}},
+		{"中文", 2, []string{"中", "文"}},
+	}
+	for _, tt := range tests {
+		if got := splitText(tt.text, tt.size); strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("splitText(%q, %d) = %q, want %q", tt.text, tt.size, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package robot
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+type NewFunc func(name string, opt map[string]interface{}) PluginIface
+
+// PluginMeta 是模块注册时提供的描述信息。
+type PluginMeta struct {
+	Summary string
+	Options Schema // 模块可配置属性，创建模块前将按此检查配置
+}
+
+type pluginEntry struct {
+	create NewFunc
+	meta   PluginMeta
+}
+
+var (
+	registryLock sync.RWMutex
+	registry     = map[string]pluginEntry{}
+)
+
+// RegisterPlugin 注册一个模块，通常在模块的init()中调用，name与配置文件中的[plugin.name]对应。
+// 同名模块重复注册将导致panic.
+func RegisterPlugin(name string, f NewFunc, meta PluginMeta) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if f == nil {
+		panic("robot: RegisterPlugin factory is nil for " + name)
+	}
+	if _, dup := registry[name]; dup {
+		panic("robot: RegisterPlugin called twice for " + name)
+	}
+	registry[name] = pluginEntry{create: f, meta: meta}
+}
+
+// CreatePlugin 按名称创建已注册的模块，opt将按模块的Schema检查并补充默认值后传给模块。
+func CreatePlugin(name string, opt map[string]interface{}) (PluginIface, error) {
+	registryLock.RLock()
+	entry, ok := registry[name]
+	registryLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("plugin %s is not compiled in", name)
+	}
+	values, err := entry.meta.Options.Validate(opt)
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s: %v", name, err)
+	}
+	return entry.create(name, values), nil
+}
+
+// CheckPluginOptions 按模块的Schema检查配置。
+func CheckPluginOptions(name string, opt map[string]interface{}) error {
+	meta, ok := GetPluginMeta(name)
+	if !ok {
+		return fmt.Errorf("plugin %s is not compiled in", name)
+	}
+	if _, err := meta.Options.Validate(opt); err != nil {
+		return fmt.Errorf("plugin %s: %v", name, err)
+	}
+	return nil
+}
+
+// RegisteredPlugins 返回所有已注册的模块名称(已排序)。
+func RegisteredPlugins() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetPluginMeta 返回已注册模块的描述信息。
+func GetPluginMeta(name string) (meta PluginMeta, ok bool) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	entry, ok := registry[name]
+	return entry.meta, ok
+}
@@ -0,0 +1,202 @@
+package robot
+
+import (
+	"errors"
+	"fmt"
+	"github.com/yetist/xmppbot/config"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// OptionLoader 由可在运行时重新载入属性的模块实现，嵌入OptionSet的模块自动满足。
+type OptionLoader interface {
+	Load(opt map[string]interface{})
+}
+
+// ReloadConfig 重新读取配置文件，并应用到运行中的Bot，返回所做的修改。
+func (b *Bot) ReloadConfig() ([]string, error) {
+	path := b.GetConfig().AppPath
+	if path == "" {
+		return nil, errors.New("no config file loaded")
+	}
+	cfg, err := config.LoadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if errs := CheckConfig(cfg); len(errs) > 0 {
+		var msg []string
+		for _, e := range errs {
+			msg = append(msg, e.Error())
+		}
+		return nil, errors.New(strings.Join(msg, "\n"))
+	}
+	return b.Reload(cfg), nil
+}
+
+// Reload 比较cfg与上次载入的配置文件，启用新增的模块、停用禁用的模块、更新修改了属性的模块，
+// 并按setup.rooms进入或离开聊天室。帐号设置不会重新载入。
+// 只有配置文件中修改过的项才会覆盖通过聊天命令所做的修改。
+func (b *Bot) Reload(cfg config.Config) (changes []string) {
+	b.reloadLock.Lock()
+	defer b.reloadLock.Unlock()
+
+	b.lock.Lock()
+	old := b.loaded
+	running := b.cfg
+	b.loaded = copyConfig(cfg)
+
+	cfg.AppName = running.AppName
+	cfg.AppVersion = running.AppVersion
+	cfg.AppConfig = running.AppConfig
+	cfg.Account = running.Account
+	// 未在配置文件中修改的项保留运行时的值
+	if cfg.Setup.CmdPrefix == old.Setup.CmdPrefix {
+		cfg.Setup.CmdPrefix = running.Setup.CmdPrefix
+	}
+	if cfg.Setup.AutoSubscribe == old.Setup.AutoSubscribe {
+		cfg.Setup.AutoSubscribe = running.Setup.AutoSubscribe
+	}
+	cfg.Plugin = running.Plugin
+	if cfg.Plugin == nil {
+		cfg.Plugin = map[string]map[string]interface{}{}
+	}
+	b.cfg = cfg
+	b.lock.Unlock()
+
+	if cfg.Setup.Status != old.Setup.Status || cfg.Setup.StatusMessage != old.Setup.StatusMessage {
+		b.SetStatus(cfg.Setup.Status, cfg.Setup.StatusMessage)
+		changes = append(changes, fmt.Sprintf("在线状态: %s %s", cfg.Setup.Status, cfg.Setup.StatusMessage))
+	}
+	if cfg.Setup.WebHost != old.Setup.WebHost || cfg.Setup.WebPort != old.Setup.WebPort ||
+		cfg.Setup.StateDB != old.Setup.StateDB || cfg.Setup.Reconnect != old.Setup.Reconnect {
+		changes = append(changes, "web_host, web_port, state_db及reconnect的修改需要重启后生效")
+	}
+	changes = append(changes, b.admin.Reload(old, b.loaded)...)
+
+	for _, name := range pluginNames(old.Plugin, b.loaded.Plugin) {
+		if change := b.reloadPlugin(name, old.Plugin[name], b.loaded.Plugin[name]); change != "" {
+			changes = append(changes, change)
+		}
+	}
+	return
+}
+
+// 按配置文件中模块配置的变化，启用、停用或更新模块
+func (b *Bot) reloadPlugin(name string, old, opt map[string]interface{}) string {
+	if reflect.DeepEqual(old, opt) {
+		return ""
+	}
+	b.lock.Lock()
+	cur := copyOptions(b.cfg.Plugin[name])
+	if opt == nil {
+		cur = nil
+	} else if cur == nil {
+		cur = copyOptions(opt)
+	}
+	// 仅更新配置文件中修改过的属性，并删除通过命令保存的同名属性
+	var keys []string
+	for k, v := range opt {
+		if !reflect.DeepEqual(old[k], v) {
+			cur[k] = v
+			keys = append(keys, k)
+		}
+	}
+	for k := range old {
+		if _, ok := opt[k]; !ok && opt != nil {
+			delete(cur, k)
+			keys = append(keys, k)
+		}
+	}
+	b.setPluginConfig(name, cur)
+	b.lock.Unlock()
+	sort.Strings(keys)
+	for _, k := range keys {
+		b.store.Delete("options", name+"."+k)
+	}
+
+	plugin := b.GetPluginByName(name)
+	enabled := opt != nil && cur["enable"] == true
+	switch {
+	case plugin == nil && enabled:
+		if err := b.AddPlugin(name); err != nil {
+			return fmt.Sprintf("启用模块%s失败: %v", name, err)
+		}
+		return "启用模块" + name
+	case plugin != nil && !enabled:
+		b.RemovePlugin(name)
+		return "停用模块" + name
+	case plugin != nil:
+		if err := CheckPluginOptions(name, cur); err != nil {
+			return fmt.Sprintf("更新模块%s失败: %v", name, err)
+		}
+		if loader, ok := plugin.(OptionLoader); ok {
+			loader.Load(cur)
+			b.loadPluginOptions(plugin)
+			plugin.Restart()
+		} else {
+			b.RemovePlugin(name)
+			if err := b.AddPlugin(name); err != nil {
+				return fmt.Sprintf("更新模块%s失败: %v", name, err)
+			}
+		}
+		return "更新模块" + name + "的属性: " + strings.Join(keys, ", ")
+	}
+	return ""
+}
+
+// 重新载入配置文件并记录日志，用于SIGHUP及配置文件监视等不需要回复的场合。
+func (b *Bot) reloadAndLog(reason string) {
+	changes, err := b.ReloadConfig()
+	if err != nil {
+		log.Printf("reload config (%s) error: %v", reason, err)
+		return
+	}
+	if len(changes) == 0 {
+		log.Printf("reload config (%s): nothing changed", reason)
+	}
+	for _, v := range changes {
+		log.Printf("reload config (%s): %s", reason, v)
+	}
+}
+
+func pluginNames(a, b map[string]map[string]interface{}) []string {
+	var names []string
+	for name := range a {
+		names = append(names, name)
+	}
+	for name := range b {
+		if _, ok := a[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func copyOptions(opt map[string]interface{}) map[string]interface{} {
+	if opt == nil {
+		return nil
+	}
+	c := map[string]interface{}{}
+	for k, v := range opt {
+		c[k] = v
+	}
+	return c
+}
+
+// 复制配置，使运行时的修改不影响保存的副本
+func copyConfig(cfg config.Config) config.Config {
+	c := cfg
+	c.Setup.Admin = append([]string{}, cfg.Setup.Admin...)
+	c.Setup.Rooms = nil
+	for _, v := range cfg.Setup.Rooms {
+		c.Setup.Rooms = append(c.Setup.Rooms, copyOptions(v))
+	}
+	c.Plugin = map[string]map[string]interface{}{}
+	for name, opt := range cfg.Plugin {
+		c.Plugin[name] = copyOptions(opt)
+	}
+	return c
+}
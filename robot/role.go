@@ -0,0 +1,347 @@
+package robot
+
+import (
+	"fmt"
+	"github.com/mattn/go-xmpp"
+	"github.com/yetist/xmppbot/utils"
+	"strings"
+)
+
+// Role 是用户的角色，值越大权限越高。
+type Role int
+
+const (
+	RoleGuest Role = iota
+	RoleMember
+	RoleOperator
+	RoleAdmin
+	RoleOwner
+)
+
+var roleNames = []string{"guest", "member", "operator", "admin", "owner"}
+
+func (r Role) String() string {
+	if r < RoleGuest || r > RoleOwner {
+		return fmt.Sprintf("Role(%d)", int(r))
+	}
+	return roleNames[r]
+}
+
+func ParseRole(name string) (Role, error) {
+	for k, v := range roleNames {
+		if strings.ToLower(name) == v {
+			return Role(k), nil
+		}
+	}
+	return RoleGuest, fmt.Errorf("无效的角色: %s, 有效的角色为: %s", name, strings.Join(roleNames, ", "))
+}
+
+// RoleRule 将角色授予Subject, Room不为空时仅对在该聊天室中(含聊天室私聊)发出的命令有效。
+// Subject可以是:
+//
+//	user@example.com        指定jid
+//	@example.com            整个域名
+//	affiliation:owner       聊天室从属关系(owner, admin, member)
+//	role:moderator          聊天室角色(moderator, participant, visitor)
+type RoleRule struct {
+	Subject string
+	Role    Role
+	Room    string
+}
+
+// CmdGrant 设置命令所需的最低角色，Cmd为顶级命令"room"或子命令"room.send".
+type CmdGrant struct {
+	Cmd  string
+	Role Role
+	Room string
+}
+
+// 规范化Subject, 无效时返回错误
+func parseSubject(subject string) (string, error) {
+	subject = strings.ToLower(strings.TrimSpace(subject))
+	if strings.HasPrefix(subject, "domain:") {
+		subject = "@" + subject[len("domain:"):]
+	}
+	switch {
+	case strings.HasPrefix(subject, "affiliation:"):
+		switch subject[len("affiliation:"):] {
+		case "owner", "admin", "member":
+			return subject, nil
+		}
+	case strings.HasPrefix(subject, "role:"):
+		switch subject[len("role:"):] {
+		case "moderator", "participant", "visitor":
+			return subject, nil
+		}
+	case strings.HasPrefix(subject, "@"):
+		if len(subject) > 1 && !strings.Contains(subject[1:], "@") {
+			return subject, nil
+		}
+	case strings.Contains(subject, "@"):
+		jid, _ := utils.SplitJID(subject)
+		return jid, nil
+	}
+	return "", fmt.Errorf("无效的对象: %s", subject)
+}
+
+func (r RoleRule) match(jid string, o Occupant) bool {
+	switch {
+	case strings.HasPrefix(r.Subject, "affiliation:"):
+		return o.Affiliation == r.Subject[len("affiliation:"):]
+	case strings.HasPrefix(r.Subject, "role:"):
+		return o.Role == r.Subject[len("role:"):]
+	case strings.HasPrefix(r.Subject, "@"):
+		return jid != "" && strings.HasSuffix(jid, r.Subject)
+	}
+	return jid == r.Subject
+}
+
+// 消息来自聊天室(含聊天室私聊)时，返回聊天室jid及发送者
+func (m *Admin) sender(msg xmpp.Chat) (jid, room string, o Occupant) {
+	roomid, nick := utils.SplitJID(msg.Remote)
+	if r := m.getRoom(roomid); r != nil {
+		o, _ = r.GetOccupant(nick)
+		jid, _ = utils.SplitJID(o.JID)
+		return strings.ToLower(jid), roomid, o
+	}
+	return strings.ToLower(roomid), "", o
+}
+
+// RoleOf 返回消息发送者的角色。
+func (m *Admin) RoleOf(msg xmpp.Chat) Role {
+	jid, room, o := m.sender(msg)
+	return m.roleOf(jid, room, o)
+}
+
+// 配置文件中的管理员为owner, 通过命令添加的管理员为admin, 好友为member, 然后取匹配的规则中最高的角色。
+func (m *Admin) roleOf(jid, room string, o Occupant) Role {
+	role := RoleGuest
+	if jid != "" {
+		if m.IsSysAdminID(jid) {
+			return RoleOwner
+		} else if m.IsAdminID(jid) {
+			role = RoleAdmin
+		} else if m.IsFriendID(jid) {
+			role = RoleMember
+		}
+	}
+	m.rolesLock.RLock()
+	defer m.rolesLock.RUnlock()
+	for _, r := range m.roles {
+		if (r.Room == "" || r.Room == room) && r.Role > role && r.match(jid, o) {
+			role = r.Role
+		}
+	}
+	return role
+}
+
+// 命令所需的最低角色，依次查找子命令及顶级命令在聊天室中及全局的设置，
+// 都没有设置时，需要管理员权限(AdminPerm)的命令为admin, 其它为guest.
+func (m *Admin) cmdRole(name string, perm int, room string) Role {
+	m.rolesLock.RLock()
+	defer m.rolesLock.RUnlock()
+	for _, cmd := range []string{name, strings.Split(name, ".")[0]} {
+		for _, r := range []string{room, ""} {
+			for _, g := range m.grants {
+				if g.Cmd == cmd && g.Room == r {
+					return g.Role
+				}
+			}
+		}
+	}
+	if perm&AdminPerm != 0 {
+		return RoleAdmin
+	}
+	return RoleGuest
+}
+
+func (m *Admin) setRole(rule RoleRule) {
+	m.rolesLock.Lock()
+	defer m.rolesLock.Unlock()
+	for k, v := range m.roles {
+		if v.Subject == rule.Subject && v.Room == rule.Room {
+			m.roles[k] = rule
+			return
+		}
+	}
+	m.roles = append(m.roles, rule)
+}
+
+func (m *Admin) getRole(subject, room string) (RoleRule, bool) {
+	m.rolesLock.RLock()
+	defer m.rolesLock.RUnlock()
+	for _, v := range m.roles {
+		if v.Subject == subject && v.Room == room {
+			return v, true
+		}
+	}
+	return RoleRule{}, false
+}
+
+func (m *Admin) delRole(subject, room string) {
+	m.rolesLock.Lock()
+	defer m.rolesLock.Unlock()
+	for k, v := range m.roles {
+		if v.Subject == subject && v.Room == room {
+			m.roles = append(m.roles[:k], m.roles[k+1:]...)
+			return
+		}
+	}
+}
+
+func (m *Admin) setGrant(grant CmdGrant) {
+	m.rolesLock.Lock()
+	defer m.rolesLock.Unlock()
+	for k, v := range m.grants {
+		if v.Cmd == grant.Cmd && v.Room == grant.Room {
+			m.grants[k] = grant
+			return
+		}
+	}
+	m.grants = append(m.grants, grant)
+}
+
+func (m *Admin) getGrant(cmd, room string) (CmdGrant, bool) {
+	m.rolesLock.RLock()
+	defer m.rolesLock.RUnlock()
+	for _, v := range m.grants {
+		if v.Cmd == cmd && v.Room == room {
+			return v, true
+		}
+	}
+	return CmdGrant{}, false
+}
+
+func (m *Admin) delGrant(cmd, room string) {
+	m.rolesLock.Lock()
+	defer m.rolesLock.Unlock()
+	for k, v := range m.grants {
+		if v.Cmd == cmd && v.Room == room {
+			m.grants = append(m.grants[:k], m.grants[k+1:]...)
+			return
+		}
+	}
+}
+
+func (m *Admin) saveRoles() {
+	m.rolesLock.RLock()
+	defer m.rolesLock.RUnlock()
+	m.saveState("roles", m.roles)
+	m.saveState("grants", m.grants)
+}
+
+/* admin role 命令 */
+func (m *Admin) role_list(msg xmpp.Chat, args *Args) {
+	m.rolesLock.RLock()
+	defer m.rolesLock.RUnlock()
+	txt := []string{"==角色列表==", "配置文件中的管理员: owner", "通过命令添加的管理员: admin", "好友: member"}
+	for _, r := range m.roles {
+		txt = append(txt, fmt.Sprintf("%s: %s%s", r.Subject, r.Role, inRoom(r.Room)))
+	}
+	txt = append(txt, "==命令权限==")
+	for _, g := range m.grants {
+		txt = append(txt, fmt.Sprintf("%s: %s%s", g.Cmd, g.Role, inRoom(g.Room)))
+	}
+	m.bot.ReplyAuto(msg, strings.Join(txt, "\n"))
+}
+
+func inRoom(room string) string {
+	if room == "" {
+		return ""
+	}
+	return " (" + room + ")"
+}
+
+// 只有owner可以授予或修改不低于自己的角色
+func (m *Admin) checkRole(msg xmpp.Chat, args *Args, role Role) bool {
+	if own := m.RoleOf(msg); own != RoleOwner && role >= own {
+		m.reply(msg, args, "您的角色为"+own.String()+"，不能授予或修改"+role.String()+"及以上的角色。")
+		return false
+	}
+	return true
+}
+
+func (m *Admin) role_set(msg xmpp.Chat, args *Args) {
+	subject, err := parseSubject(args.String("Subject"))
+	if err != nil {
+		m.reply(msg, args, err.Error())
+		return
+	}
+	role, err := ParseRole(args.String("Role"))
+	if err != nil {
+		m.reply(msg, args, err.Error())
+		return
+	}
+	if !m.checkRole(msg, args, role) {
+		return
+	}
+	if old, ok := m.getRole(subject, args.String("Rid")); ok && !m.checkRole(msg, args, old.Role) {
+		return
+	}
+	m.setRole(RoleRule{Subject: subject, Role: role, Room: args.String("Rid")})
+	m.saveRoles()
+	m.reply(msg, args, "已将"+subject+"的角色设置为"+role.String()+inRoom(args.String("Rid")))
+}
+
+func (m *Admin) role_del(msg xmpp.Chat, args *Args) {
+	subject, err := parseSubject(args.String("Subject"))
+	if err != nil {
+		m.reply(msg, args, err.Error())
+		return
+	}
+	rule, ok := m.getRole(subject, args.String("Rid"))
+	if !ok {
+		m.reply(msg, args, "没有为"+subject+"设置角色"+inRoom(args.String("Rid")))
+		return
+	}
+	if !m.checkRole(msg, args, rule.Role) {
+		return
+	}
+	m.delRole(subject, args.String("Rid"))
+	m.saveRoles()
+	m.reply(msg, args, "已删除"+subject+"的角色"+inRoom(args.String("Rid")))
+}
+
+func (m *Admin) role_grant(msg xmpp.Chat, args *Args) {
+	role, err := ParseRole(args.String("Role"))
+	if err != nil {
+		m.reply(msg, args, err.Error())
+		return
+	}
+	cmd := strings.Replace(args.String("cmd"), " ", ".", -1)
+	if !m.checkRole(msg, args, role) || !m.checkRole(msg, args, m.cmdRole(cmd, m.perm(strings.Split(cmd, ".")[0]), args.String("Rid"))) {
+		return
+	}
+	m.setGrant(CmdGrant{Cmd: cmd, Role: role, Room: args.String("Rid")})
+	m.saveRoles()
+	m.reply(msg, args, "命令"+cmd+"已设置为需要"+role.String()+"及以上角色"+inRoom(args.String("Rid")))
+}
+
+func (m *Admin) role_revoke(msg xmpp.Chat, args *Args) {
+	cmd := strings.Replace(args.String("cmd"), " ", ".", -1)
+	grant, ok := m.getGrant(cmd, args.String("Rid"))
+	if !ok {
+		m.reply(msg, args, "没有为命令"+cmd+"设置角色"+inRoom(args.String("Rid")))
+		return
+	}
+	if !m.checkRole(msg, args, grant.Role) {
+		return
+	}
+	m.delGrant(cmd, args.String("Rid"))
+	m.saveRoles()
+	m.reply(msg, args, "已删除命令"+cmd+"的角色设置"+inRoom(args.String("Rid")))
+}
+
+func (m *Admin) role_show(msg xmpp.Chat, args *Args) {
+	who := args.String("Who")
+	jid, room := strings.ToLower(who), args.String("Rid")
+	var o Occupant
+	if r := m.getRoom(room); r != nil {
+		// Who也可以是聊天室中的昵称
+		if v, ok := r.GetOccupant(who); ok {
+			o = v
+			jid, _ = utils.SplitJID(strings.ToLower(v.JID))
+		}
+	}
+	m.bot.ReplyAuto(msg, who+"的角色为"+m.roleOf(jid, room, o).String()+inRoom(room))
+}
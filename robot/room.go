@@ -3,13 +3,34 @@ package robot
 import (
 	"github.com/yetist/xmppbot/utils"
 	"strings"
+	"sync"
+	"time"
 )
 
 type Room struct {
-	JID      string
-	Nickname string
-	Password string
-	Block    []string
+	JID        string
+	Nickname   string
+	Password   string
+	lock       sync.RWMutex
+	blocks     []string            // 忽略其消息的昵称
+	occupants  map[string]Occupant // 按昵称索引的成员列表
+	queryTimer *time.Timer
+	queryDeny  bool   // 管理查询被拒绝，bot自己的presence变化(角色可能改变)之前不再查询
+	state      int    // RoomJoining, RoomJoined, RoomFailed
+	reason     string // 进入失败的原因
+	since      time.Time
+	wantNick   string // 配置的昵称，Nickname可能是冲突时使用的备用昵称
+	joinNick   string // 正在尝试的昵称
+	nickTry    int
+	retry      *Backoff
+	joinTimer  *time.Timer
+	joinSeq    int             // 每次停止计时器时加1, 用于忽略已过期的计时器
+	notified   bool            // 已通知管理员进入失败
+	newNick    string          // 正在修改的昵称，收到服务器确认后才成为Nickname
+	oldNick    string          // 上次修改之前的昵称
+	nickGone   bool            // 修改昵称时已收到旧昵称的unavailable
+	nickTimer  *time.Timer     // 等待服务器确认修改昵称的超时
+	nickDone   func(err error) // 修改昵称完成时调用
 }
 
 func NewRoom(jid, nickname, password string) *Room {
@@ -21,29 +42,48 @@ func NewRoom(jid, nickname, password string) *Room {
 }
 
 func (r *Room) ListBlocks() string {
-	return "== Block of " + r.JID + " ==\n" + strings.Join(r.Block, "\n")
+	return "== Block of " + r.JID + " ==\n" + strings.Join(r.Blocks(), "\n")
 }
 
-func (r *Room) BlockOne(nick string) {
-	for _, v := range r.Block {
-		if nick == v {
-			return
-		}
+// Blocks 返回忽略其消息的昵称列表。
+func (r *Room) Blocks() []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return append([]string{}, r.blocks...)
+}
+
+func (r *Room) setBlocks(blocks []string) {
+	r.lock.Lock()
+	r.blocks = append([]string{}, blocks...)
+	r.lock.Unlock()
+}
+
+// BlockOne 忽略nick的消息，nick已被忽略时返回false.
+func (r *Room) BlockOne(nick string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if utils.ListContains(r.blocks, nick) {
+		return false
 	}
-	r.Block = append(r.Block, nick)
+	r.blocks = append(r.blocks, nick)
+	return true
 }
 
-func (r *Room) UnBlockOne(nick string) {
-	r.Block = utils.ListDelete(r.Block, nick)
+// UnBlockOne 不再忽略nick的消息，nick未被忽略时返回false.
+func (r *Room) UnBlockOne(nick string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !utils.ListContains(r.blocks, nick) {
+		return false
+	}
+	r.blocks = utils.ListDelete(r.blocks, nick)
+	return true
 }
 
 func (r *Room) IsBlocked(nick string) bool {
-	for _, v := range r.Block {
-		if nick == v {
-			return true
-		}
-	}
-	return false
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return utils.ListContains(r.blocks, nick)
 }
 
 func (r *Room) GetJID() string {
@@ -51,13 +91,26 @@ func (r *Room) GetJID() string {
 }
 
 func (r *Room) SetNick(nick string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
 	r.Nickname = nick
+	r.wantNick = nick
 }
 
 func (r *Room) GetNick() string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
 	return r.Nickname
 }
 
+func (r *Room) SetPassword(password string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.Password = password
+}
+
 func (r *Room) GetPassword() string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
 	return r.Password
 }
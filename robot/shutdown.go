@@ -0,0 +1,92 @@
+package robot
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// 未配置setup.shutdown_timeout时，等待各模块退出的最长时间
+const DefaultShutdownTimeout = 10 * time.Second
+
+// Shutdown 停止Bot: 不再接收新消息并停止web服务及计划任务，等待队列中的消息处理完毕，
+// 在timeout内依次停止各模块，发出发送队列中的消息，最后停止管理员模块(离开所有聊天室)，
+// 然后发送下线状态并断开连接。
+// 有模块未能在timeout内退出时返回错误。
+func (b *Bot) Shutdown(timeout time.Duration) error {
+	b.lock.Lock()
+	if b.stopping {
+		b.lock.Unlock()
+		return nil
+	}
+	b.stopping = true
+	b.lock.Unlock()
+
+	deadline := time.Now().Add(timeout)
+	if b.watcher != nil {
+		b.watcher.Close()
+	}
+	b.web.Stop()
+	b.cron.Stop()
+
+	var errs []string
+	// 等待队列中的消息处理完毕，使其回复能够发出
+	if !waitUntil(deadline, func() { b.busy.Lock(); b.busy.Unlock() }) ||
+		!waitUntil(deadline, b.finishQueues) {
+		errs = append(errs, "queued messages")
+	}
+
+	var admin PluginIface
+	for _, v := range b.GetPlugins() {
+		if _, ok := v.(*Admin); ok {
+			admin = v
+			continue
+		}
+		if !waitUntil(deadline, v.Stop) {
+			errs = append(errs, "plugin "+v.GetName())
+		}
+	}
+	// 离开聊天室前发出队列中的消息
+	if !waitUntil(deadline, b.out.flush) {
+		errs = append(errs, "outbox")
+	}
+	if admin != nil && !waitUntil(deadline, admin.Stop) {
+		errs = append(errs, "plugin "+admin.GetName())
+	}
+
+	b.conn().SendOrg("<presence type='unavailable'/>")
+	b.conn().Close()
+	b.store.Close()
+	if len(errs) > 0 {
+		return fmt.Errorf("shutdown timeout: %s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// Bot是否正在停止
+func (b *Bot) isStopping() bool {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	return b.stopping
+}
+
+// 在deadline之前运行f, 超时返回false, f将在后台继续运行。
+func waitUntil(deadline time.Time, f func()) bool {
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		defer func() {
+			if err := recover(); err != nil {
+				log.Printf("shutdown: %v", err)
+			}
+		}()
+		f()
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(deadline.Sub(time.Now())):
+		return false
+	}
+}
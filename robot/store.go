@@ -0,0 +1,205 @@
+package robot
+
+import (
+	"encoding/json"
+	"github.com/go-xorm/xorm"
+	_ "github.com/mattn/go-sqlite3"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Store 保存运行时通过聊天命令修改的状态，值以json格式存储，按bucket分组。
+// 模块可以通过Bot.GetStore()使用它，bucket一般为模块名。
+type Store interface {
+	// Get 读取key的值到v中，key不存在时返回false.
+	Get(bucket, key string, v interface{}) (bool, error)
+	Put(bucket, key string, v interface{}) error
+	Delete(bucket, key string) error
+	Keys(bucket string) ([]string, error)
+	Close() error
+}
+
+// OpenStore 打开状态数据库，dbname为sqlite3文件路径，":memory:"表示仅保存在内存中。
+func OpenStore(dbname string) (Store, error) {
+	if dbname == "" {
+		dbname = "xmppbot-state.db"
+	}
+	if dbname == ":memory:" {
+		return NewMemoryStore(), nil
+	}
+	return NewDBStore("sqlite3", dbname)
+}
+
+// MemoryStore 是仅保存在内存中的Store.
+type MemoryStore struct {
+	lock     sync.RWMutex
+	data     map[string]map[string][]byte
+	audits   []AuditEvent // 最多保存MaxAuditCount条
+	auditSeq int64
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: map[string]map[string][]byte{}}
+}
+
+func (s *MemoryStore) Get(bucket, key string, v interface{}) (bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	data, ok := s.data[bucket][key]
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+func (s *MemoryStore) Put(bucket, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.data[bucket] == nil {
+		s.data[bucket] = map[string][]byte{}
+	}
+	s.data[bucket][key] = data
+	return nil
+}
+
+func (s *MemoryStore) Delete(bucket, key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.data[bucket], key)
+	return nil
+}
+
+func (s *MemoryStore) Keys(bucket string) ([]string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	keys := make([]string, 0, len(s.data[bucket]))
+	for k := range s.data[bucket] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *MemoryStore) AddAudit(e AuditEvent) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.auditSeq++
+	e.Id = s.auditSeq
+	s.audits = append(s.audits, e)
+	if len(s.audits) > MaxAuditCount {
+		s.audits = append([]AuditEvent{}, s.audits[len(s.audits)-MaxAuditCount:]...)
+	}
+	return nil
+}
+
+func (s *MemoryStore) Audits(n int) ([]AuditEvent, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	events := []AuditEvent{}
+	for i := len(s.audits) - 1; i >= 0 && len(events) < n; i-- {
+		events = append(events, s.audits[i])
+	}
+	return events, nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+type BotState struct {
+	Id      int64
+	Bucket  string    `xorm:"unique(bucket_name)"`
+	Name    string    `xorm:"unique(bucket_name)"`
+	Value   string    `xorm:"text"`
+	Updated time.Time `xorm:"updated"`
+}
+
+// DBStore 使用数据库保存状态。
+type DBStore struct {
+	lock sync.Mutex // Put需要先更新再插入
+	x    *xorm.Engine
+}
+
+func NewDBStore(dbtype, dbname string) (*DBStore, error) {
+	x, err := xorm.NewEngine(dbtype, dbname)
+	if err != nil {
+		return nil, err
+	}
+	x.ShowSQL = false
+	if err = x.Sync2(new(BotState), new(AuditEvent)); err != nil {
+		return nil, err
+	}
+	return &DBStore{x: x}, nil
+}
+
+// Engine 返回底层的数据库连接，供需要建立自己数据表的模块使用。
+func (s *DBStore) Engine() *xorm.Engine {
+	return s.x
+}
+
+func (s *DBStore) Get(bucket, key string, v interface{}) (bool, error) {
+	state := &BotState{}
+	has, err := s.x.Where("bucket = ? and name = ?", bucket, key).Get(state)
+	if err != nil || !has {
+		return false, err
+	}
+	return true, json.Unmarshal([]byte(state.Value), v)
+}
+
+func (s *DBStore) Put(bucket, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	// 模块在各自的worker中并发写入，先更新，没有更新到记录时再插入，
+	// 插入因同时写入同一个key而违反唯一约束时重新更新。
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i := 0; ; i++ {
+		n, err := s.x.Where("bucket = ? and name = ?", bucket, key).Cols("value").Update(&BotState{Value: string(data)})
+		if err != nil || n > 0 {
+			return err
+		}
+		if _, err = s.x.InsertOne(&BotState{Bucket: bucket, Name: key, Value: string(data)}); err == nil || i > 0 {
+			return err
+		}
+	}
+}
+
+func (s *DBStore) Delete(bucket, key string) error {
+	_, err := s.x.Where("bucket = ? and name = ?", bucket, key).Delete(new(BotState))
+	return err
+}
+
+func (s *DBStore) Keys(bucket string) ([]string, error) {
+	states := make([]BotState, 0)
+	if err := s.x.Where("bucket = ?", bucket).Cols("name").Find(&states); err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(states))
+	for _, v := range states {
+		keys = append(keys, v.Name)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *DBStore) AddAudit(e AuditEvent) error {
+	_, err := s.x.InsertOne(&e)
+	return err
+}
+
+func (s *DBStore) Audits(n int) ([]AuditEvent, error) {
+	events := make([]AuditEvent, 0)
+	err := s.x.Desc("id").Limit(n).Find(&events)
+	return events, err
+}
+
+func (s *DBStore) Close() error {
+	return s.x.Close()
+}
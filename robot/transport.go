@@ -0,0 +1,83 @@
+package robot
+
+import (
+	"github.com/mattn/go-xmpp"
+)
+
+// Transport 是Bot与xmpp服务器之间的连接，Bot的所有收发操作都经由它完成。
+type Transport interface {
+	Send(chat xmpp.Chat) (n int, err error)
+	SendOrg(org string) (n int, err error)
+	Recv() (stanza interface{}, err error)
+	JoinMUC(jid, nick string)
+	JoinProtectedMUC(jid, nick, password string)
+	LeaveMUC(jid string)
+	InviteToMUC(from, nick, to, roomid, password, reason string)
+	Roster() error
+	ApproveSubscription(jid string)
+	RevokeSubscription(jid string)
+	RequestSubscription(jid string)
+	PingC2S(jid, server string) error
+	Close() error
+}
+
+// XMPPTransport 使用go-xmpp的Client实现Transport.
+type XMPPTransport struct {
+	client *xmpp.Client
+}
+
+func NewXMPPTransport(client *xmpp.Client) *XMPPTransport {
+	return &XMPPTransport{client: client}
+}
+
+func (t *XMPPTransport) Send(chat xmpp.Chat) (n int, err error) {
+	return t.client.Send(chat)
+}
+
+func (t *XMPPTransport) SendOrg(org string) (n int, err error) {
+	return t.client.SendOrg(org)
+}
+
+func (t *XMPPTransport) Recv() (stanza interface{}, err error) {
+	return t.client.Recv()
+}
+
+func (t *XMPPTransport) JoinMUC(jid, nick string) {
+	t.client.JoinMUC(jid, nick)
+}
+
+func (t *XMPPTransport) JoinProtectedMUC(jid, nick, password string) {
+	t.client.JoinProtectedMUC(jid, nick, password)
+}
+
+func (t *XMPPTransport) LeaveMUC(jid string) {
+	t.client.LeaveMUC(jid)
+}
+
+func (t *XMPPTransport) InviteToMUC(from, nick, to, roomid, password, reason string) {
+	t.client.InviteToMUC(from, nick, to, roomid, password, reason)
+}
+
+func (t *XMPPTransport) Roster() error {
+	return t.client.Roster()
+}
+
+func (t *XMPPTransport) ApproveSubscription(jid string) {
+	t.client.ApproveSubscription(jid)
+}
+
+func (t *XMPPTransport) RevokeSubscription(jid string) {
+	t.client.RevokeSubscription(jid)
+}
+
+func (t *XMPPTransport) RequestSubscription(jid string) {
+	t.client.RequestSubscription(jid)
+}
+
+func (t *XMPPTransport) PingC2S(jid, server string) error {
+	return t.client.PingC2S(jid, server)
+}
+
+func (t *XMPPTransport) Close() error {
+	return t.client.Close()
+}
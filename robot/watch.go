@@ -0,0 +1,54 @@
+package robot
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"log"
+	"path/filepath"
+	"time"
+)
+
+// 编辑器保存文件时可能产生多个事件，等待一段时间后再重新载入
+const watchDelay = time.Second
+
+// 监视配置文件，文件修改后自动重新载入。
+// 监视的是所在目录，以便处理编辑器先写临时文件再改名的保存方式。
+func (b *Bot) watchConfig() {
+	path, err := filepath.Abs(b.cfg.AppPath)
+	if err != nil || b.cfg.AppPath == "" {
+		return
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("watch config error: %v", err)
+		return
+	}
+	if err = watcher.Add(filepath.Dir(path)); err != nil {
+		log.Printf("watch config error: %v", err)
+		watcher.Close()
+		return
+	}
+	b.watcher = watcher
+
+	go func() {
+		var timer <-chan time.Time
+		for {
+			select {
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if name, _ := filepath.Abs(ev.Name); name == path && ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+					timer = time.After(watchDelay)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("watch config error: %v", err)
+			case <-timer:
+				timer = nil
+				b.reloadAndLog("config file changed")
+			}
+		}
+	}()
+}
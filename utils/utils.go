@@ -22,6 +22,17 @@ func StringToBool(val string) bool {
 	return false
 }
 
+// ParseBool 严格解析布尔值，不是可识别的真值或假值时返回错误。
+func ParseBool(val string) (bool, error) {
+	switch strings.ToLower(val) {
+	case "1", "true", "t", "y", "yes", "ok":
+		return true, nil
+	case "0", "false", "f", "n", "no":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid bool %s", val)
+}
+
 func BoolToString(val bool) string {
 	if val {
 		return "true"
@@ -59,8 +70,58 @@ func ListDelete(list []string, key string) []string {
 	return list
 }
 
+func ListContains(list []string, key string) bool {
+	for _, v := range list {
+		if v == key {
+			return true
+		}
+	}
+	return false
+}
+
 func GetMd5(str string) string {
 	h := md5.New()
 	io.WriteString(h, str)
 	return fmt.Sprintf("%x", h.Sum(nil))
 }
+
+// DiffLines 逐行比较a和b, 返回以"- "(删除)和"+ "(新增)开头的差异行。
+func DiffLines(a, b []string) []string {
+	// lcs[i][j] 为a[i:]和b[j:]的最长公共子序列长度
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var diff []string
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if a[i] == b[j] {
+			i++
+			j++
+		} else if lcs[i+1][j] >= lcs[i][j+1] {
+			diff = append(diff, "- "+a[i])
+			i++
+		} else {
+			diff = append(diff, "+ "+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		diff = append(diff, "- "+a[i])
+	}
+	for ; j < len(b); j++ {
+		diff = append(diff, "+ "+b[j])
+	}
+	return diff
+}